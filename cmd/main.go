@@ -1,23 +1,42 @@
 package main
 
 import (
+	"context"
 	"files/configs/env"
 	"files/internal/api/middlewares"
+	"files/internal/api/middlewares/auth"
 	"files/internal/ioc"
+	"files/internal/repository"
 	"files/internal/routes"
 	"files/pkg/log"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"net/http"
 	"time"
 )
 
 func main() {
 	container := ioc.NewContainer()
 
+	// Незавершённые tus-загрузки и сессии с истёкшим сроком удаляются в фоне
+	go container.TusService.RunCleanup(context.Background())
+	go container.UploadSessionService.RunCleanup(context.Background())
+
 	// Отключаем режим отладки, чтобы не выводились лишние сообщения
 	gin.SetMode(gin.ReleaseMode)
 
+	r := setupRouter(container)
+
+	port := env.GetEnv("SERV_PORT", "3000")
+	log.Info("Starting server", zap.String("port", port))
+	if err := r.Run(":" + port); err != nil {
+		log.Fatal("Failed to start server", zap.Error(err))
+	}
+}
+
+// setupRouter собирает gin-роутер со всеми middleware и маршрутами.
+func setupRouter(container *ioc.Container) *gin.Engine {
 	// Инициализируем новый роутер (без встроенных логов)
 	r := gin.New()
 
@@ -26,11 +45,20 @@ func main() {
 		// Разрешаем запросы с любых источников
 		AllowAllOrigins: true,
 		// Разрешаем все основные HTTP-методы
-		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		// Разрешаем основные заголовки, включая необходимые для отправки файлов
-		AllowHeaders: []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
+		AllowHeaders: []string{
+			"Origin", "Content-Length", "Content-Type", "Authorization",
+			"Range", "If-None-Match", "If-Modified-Since", "If-Range",
+			"Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset",
+			"X-Checksum-SHA256",
+		},
 		// Заголовки, которые могут быть видны на стороне клиента
-		ExposeHeaders: []string{"Content-Length"},
+		ExposeHeaders: []string{
+			"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified",
+			"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Expires",
+		},
 		// Если требуется, можно передавать куки
 		AllowCredentials: true,
 		// Время, в течение которого результаты preflight-запроса кэшируются
@@ -46,14 +74,23 @@ func main() {
 	r.Use(middlewares.RequestLoggerMiddleware(container.Logger))
 
 	apiGroup := r.Group("/files")
-	//set auth for group
-	//apiGroup.Use(auth.JwtAuthMiddleware(container.JwtService))
+	// Проверка JWT для всех маршрутов /files; отключается AUTH_ENABLED=false
+	if container.AuthEnabled {
+		apiGroup.Use(auth.JwtAuthMiddleware(container.JwtService))
+	}
 
-	routes.S3Routes(apiGroup, container.S3Handler)
+	routes.S3Routes(apiGroup, container.S3Handler, container.ImageLimits)
+	routes.ImageRoutes(apiGroup, container.ImageHandler)
+	routes.TusRoutes(apiGroup, container.TusHandler, container.ImageLimits)
+	routes.UploadSessionRoutes(apiGroup, container.UploadSessionHandler, container.ImageLimits)
 
-	port := env.GetEnv("SERV_PORT", "3000")
-	log.Info("Starting server", zap.String("port", port))
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server", zap.Error(err))
+	// Для локального бэкенда раздаём файлы сами, чтобы ссылки из ответов открывались.
+	// Приватные файлы отдаются только по подписанной ссылке.
+	if fsRepo, ok := container.Storage.(*repository.FSRepository); ok {
+		storage := gin.WrapH(http.StripPrefix("/storage", fsRepo))
+		r.GET("/storage/*key", storage)
+		r.HEAD("/storage/*key", storage)
 	}
+
+	return r
 }
@@ -0,0 +1,2410 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"files/internal/api/handlers"
+	"files/internal/ioc"
+	"files/internal/repository"
+	"files/internal/services"
+	"files/pkg/imaging"
+	"files/pkg/log"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/image/webp"
+)
+
+// Роутер и контейнер создаются один раз: логгер в pkg/log глобальный и инициализируется единожды.
+// Тесты изолированы друг от друга собственными значениями :id.
+var (
+	testContainer *ioc.Container
+	testRouter    *gin.Engine
+)
+
+func TestMain(m *testing.M) {
+	// Один маленький вариант: каждый загруженный файл даёт два объекта — оригинал и _thumb
+	os.Setenv("IMAGE_VARIANTS", "thumb:4")
+	os.Setenv("IMAGE_PRESETS", "square=6x6:cover")
+	os.Setenv("IMAGE_SIGNING_KEY", "test-signing-key")
+	// Авторизацию проверяет TestJWTAuth на собственном роутере
+	os.Setenv("AUTH_ENABLED", "false")
+	gin.SetMode(gin.TestMode)
+
+	log.InitLogger()
+	testContainer = ioc.NewContainerWithStorage(log.GetLogger(), &presignMemory{
+		MemoryRepository: repository.NewMemoryRepository("http://cdn.test"),
+		signer:           repository.NewURLSigner(""),
+	})
+	testRouter = setupRouter(testContainer)
+
+	os.Exit(m.Run())
+}
+
+// presignMemory — хранилище в памяти, которое подписывает ссылки URLSigner: сам MemoryRepository
+// presign не поддерживает, а тестам нужны ответы /presign и прямой загрузки. Ссылки никем
+// не обслуживаются — тесты кладут объекты в хранилище сами.
+type presignMemory struct {
+	*repository.MemoryRepository
+	signer repository.URLSigner
+}
+
+func (r *presignMemory) PresignGetURL(ctx context.Context, key string, opts repository.PresignOptions) (string, error) {
+	if _, err := r.HeadFile(ctx, key); err != nil {
+		return "", err
+	}
+	return r.signer.Sign(r.FileURL(key), key, opts, time.Now()), nil
+}
+
+func (r *presignMemory) PresignUpload(
+	ctx context.Context,
+	key string,
+	opts repository.PresignUploadOptions,
+) (*repository.PresignedUpload, error) {
+	signed := r.signer.Sign(r.FileURL(key), key, repository.PresignOptions{Expires: opts.Expires}, time.Now())
+	upload := &repository.PresignedUpload{Method: opts.Method, URL: signed}
+	if opts.Method == repository.UploadMethodPost {
+		upload.Fields = map[string]string{"key": key, "Content-Type": opts.ContentType}
+	} else {
+		upload.Headers = map[string]string{"Content-Type": opts.ContentType}
+	}
+	return upload, nil
+}
+
+// missingUUID — корректный UUID, которого нет в хранилище.
+const missingUUID = "00000000-0000-4000-8000-000000000000"
+
+// testFile — файл для multipart-запроса.
+type testFile struct {
+	name string
+	data []byte
+}
+
+// pngBytes — корректное PNG-изображение 8x8.
+var pngBytes = encodePNG(8, 8)
+
+// encodePNG кодирует однотонное изображение заданного размера.
+func encodePNG(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader возвращает начало PNG-файла (сигнатура и IHDR) с заявленными размерами без данных.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12] = 8 // bit depth
+	ihdr[13] = 6 // RGBA
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	_ = binary.Write(&buf, binary.BigEndian, uint32(13))
+	buf.Write(ihdr)
+	_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+// photoWithMetadata возвращает JPEG 16x8 (левая половина красная, правая синяя), снятый «на боку»:
+// EXIF содержит Orientation=6, производителя, GPS и Copyright, плюс отдельный сегмент XMP.
+func photoWithMetadata() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 8 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		panic(err)
+	}
+
+	// TIFF (little-endian): IFD0 из 4 записей, затем GPS IFD и строковые значения
+	le := binary.LittleEndian
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	_ = binary.Write(&tiff, le, uint32(8))
+	const ifd0Size = 2 + 4*12 + 4
+	gpsOffset := uint32(8 + ifd0Size)
+	const gpsSize = 2 + 12 + 4
+	makeOffset := gpsOffset + gpsSize
+	copyrightOffset := makeOffset + 8
+	entry := func(tag, typ uint16, count, value uint32) {
+		_ = binary.Write(&tiff, le, tag)
+		_ = binary.Write(&tiff, le, typ)
+		_ = binary.Write(&tiff, le, count)
+		_ = binary.Write(&tiff, le, value)
+	}
+	_ = binary.Write(&tiff, le, uint16(4))
+	entry(0x010F, 2, 8, makeOffset)      // Make
+	entry(0x0112, 3, 1, 6)               // Orientation
+	entry(0x8298, 2, 5, copyrightOffset) // Copyright
+	entry(0x8825, 4, 1, gpsOffset)       // GPS IFD
+	_ = binary.Write(&tiff, le, uint32(0))
+	_ = binary.Write(&tiff, le, uint16(1))
+	entry(0x0001, 2, 2, uint32('N')) // GPSLatitudeRef
+	_ = binary.Write(&tiff, le, uint32(0))
+	tiff.WriteString("PhoneCo\x00")
+	tiff.WriteString("ACME\x00")
+
+	segment := func(marker byte, payload []byte) []byte {
+		out := []byte{0xFF, marker, 0, 0}
+		binary.BigEndian.PutUint16(out[2:], uint16(len(payload)+2))
+		return append(out, payload...)
+	}
+	var photo bytes.Buffer
+	photo.Write(encoded.Bytes()[:2])
+	photo.Write(segment(0xE1, append([]byte("Exif\x00\x00"), tiff.Bytes()...)))
+	photo.Write(segment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>secret</x:xmpmeta>")))
+	photo.Write(encoded.Bytes()[2:])
+	return photo.Bytes()
+}
+
+// withPNGChunk вставляет в PNG чанк сразу после IHDR.
+func withPNGChunk(data []byte, typ string, payload []byte) []byte {
+	const ihdrEnd = 8 + 8 + 13 + 4
+	var chunk bytes.Buffer
+	_ = binary.Write(&chunk, binary.BigEndian, uint32(len(payload)))
+	chunk.WriteString(typ)
+	chunk.Write(payload)
+	_ = binary.Write(&chunk, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(typ), payload...)))
+
+	out := append([]byte(nil), data[:ihdrEnd]...)
+	out = append(out, chunk.Bytes()...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+// newUploadRequest собирает multipart-запрос POST /files/upload/:id.
+func newUploadRequest(t *testing.T, id string, files ...testFile) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("comment", "not a file"); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		part, err := writer.CreateFormFile("files", f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/files/upload/"+id, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// serve прогоняет запрос через роутер и разбирает JSON-ответ в out (если out != nil).
+func serve(t *testing.T, req *http.Request, out any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+// upload загружает файлы и возвращает URL из ответа, проверяя статус 200.
+func upload(t *testing.T, id string, files ...testFile) []string {
+	t.Helper()
+
+	var resp struct {
+		URLs []string `json:"urls"`
+	}
+	rec := serve(t, newUploadRequest(t, id, files...), &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	return resp.URLs
+}
+
+// listKeys возвращает ключи из хранилища по префиксу.
+func listKeys(t *testing.T, prefix string) []string {
+	t.Helper()
+
+	keys := make([]string, 0)
+	err := testContainer.Storage.ListPages(context.Background(), prefix, func(page []repository.ObjectInfo) error {
+		for _, obj := range page {
+			keys = append(keys, obj.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestUploadMultiple(t *testing.T) {
+	urls := upload(t, "upload-ok",
+		testFile{name: "a.png", data: pngBytes},
+		testFile{name: "b.PNG", data: pngBytes},
+	)
+	if len(urls) != 2 {
+		t.Fatalf("expected 2 urls, got %v", urls)
+	}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "http://cdn.test/photos/upload-ok/") {
+			t.Errorf("unexpected url %q", u)
+		}
+	}
+
+	keys := listKeys(t, "photos/upload-ok/")
+	if len(keys) != 4 {
+		t.Fatalf("expected 2 originals and 2 thumbnails, got %v", keys)
+	}
+	info, err := testContainer.Storage.HeadFile(context.Background(), strings.TrimPrefix(urls[0], "http://cdn.test/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentType != "image/png" || info.Size != int64(len(pngBytes)) {
+		t.Errorf("unexpected stored object: %+v", info)
+	}
+}
+
+func TestUploadVariants(t *testing.T) {
+	var resp struct {
+		URLs  []string `json:"urls"`
+		Files []struct {
+			Key      string            `json:"key"`
+			URL      string            `json:"url"`
+			Variants map[string]string `json:"variants"`
+		} `json:"files"`
+	}
+	rec := serve(t, newUploadRequest(t, "upload-variants", testFile{name: "a.png", data: encodePNG(16, 8)}), &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(resp.Files) != 1 || resp.Files[0].URL != resp.URLs[0] {
+		t.Fatalf("unexpected response: %s", rec.Body.String())
+	}
+	thumbKey := strings.TrimSuffix(resp.Files[0].Key, ".png") + "_thumb.png"
+	if resp.Files[0].Variants["thumb"] != "http://cdn.test/"+thumbKey {
+		t.Fatalf("expected thumb url for %q, got %v", thumbKey, resp.Files[0].Variants)
+	}
+
+	body, _, err := testContainer.Storage.GetFile(context.Background(), thumbKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	cfg, err := png.DecodeConfig(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 4 || cfg.Height != 2 {
+		t.Fatalf("expected 4x2 thumbnail, got %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestUploadConvertsToWebP(t *testing.T) {
+	if !imaging.WebPSupported {
+		t.Skip("WebP encoding needs cgo")
+	}
+	t.Setenv("IMAGE_WEBP_CONVERT", "true")
+	t.Setenv("IMAGE_WEBP_QUALITY", "75")
+	router := setupRouter(testContainer)
+
+	type uploadResponse struct {
+		Files []struct {
+			Key  string `json:"key"`
+			URL  string `json:"url"`
+			Size int64  `json:"size"`
+			WebP *struct {
+				Key          string `json:"key"`
+				URL          string `json:"url"`
+				Size         int64  `json:"size"`
+				OriginalSize int64  `json:"original_size"`
+				SavedBytes   int64  `json:"saved_bytes"`
+			} `json:"webp"`
+		} `json:"files"`
+	}
+	original := encodePNG(32, 32)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, newUploadRequest(t, "upload-webp", testFile{name: "a.png", data: original}))
+	var resp uploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	file := resp.Files[0]
+	if file.WebP == nil || !strings.HasSuffix(file.WebP.Key, ".webp") || !strings.HasSuffix(file.Key, ".png") {
+		t.Fatalf("expected original png and webp copy, got %s", rec.Body.String())
+	}
+	if file.WebP.OriginalSize != int64(len(original)) || file.WebP.SavedBytes != file.WebP.OriginalSize-file.WebP.Size {
+		t.Fatalf("unexpected savings report: %+v", *file.WebP)
+	}
+	body, info, err := testContainer.Storage.GetFile(context.Background(), file.WebP.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if info.ContentType != "image/webp" {
+		t.Fatalf("expected image/webp, got %q", info.ContentType)
+	}
+	if _, err := webp.DecodeConfig(body); err != nil {
+		t.Fatalf("stored file is not a webp: %v", err)
+	}
+
+	// Без сохранения оригинала остаются только WebP и его варианты
+	t.Setenv("IMAGE_WEBP_KEEP_ORIGINAL", "false")
+	router = setupRouter(testContainer)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, newUploadRequest(t, "upload-webp-only", testFile{name: "a.png", data: original}))
+	resp = uploadResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if resp.Files[0].URL != resp.Files[0].WebP.URL {
+		t.Fatalf("expected the webp to be the main file, got %s", rec.Body.String())
+	}
+	for _, key := range listKeys(t, "photos/upload-webp-only/") {
+		if !strings.HasSuffix(key, ".webp") {
+			t.Fatalf("original must not be stored, got %q", key)
+		}
+	}
+}
+
+func TestUploadStripsMetadata(t *testing.T) {
+	var resp struct {
+		Files []struct {
+			Key      string            `json:"key"`
+			Variants map[string]string `json:"variants"`
+		} `json:"files"`
+	}
+	rec := serve(t, newUploadRequest(t, "upload-privacy",
+		testFile{name: "photo.jpg", data: photoWithMetadata()},
+		testFile{name: "notes.png", data: withPNGChunk(pngBytes, "tEXt", []byte("Comment\x00secret-location"))},
+	), &resp)
+	if rec.Code != http.StatusOK || len(resp.Files) != 2 {
+		t.Fatalf("expected 200 with 2 files, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	read := func(key string) []byte {
+		t.Helper()
+		body, _, err := testContainer.Storage.GetFile(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer body.Close()
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(body); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	photo := read(resp.Files[0].Key)
+	for _, secret := range []string{"PhoneCo", "xmpmeta"} {
+		if bytes.Contains(photo, []byte(secret)) {
+			t.Fatalf("metadata %q must be stripped", secret)
+		}
+	}
+	if !bytes.Contains(photo, []byte("ACME")) {
+		t.Fatal("copyright must be kept")
+	}
+	if meta := imaging.ReadMetadata(photo, "image/jpeg"); meta.Orientation != 1 {
+		t.Fatalf("orientation must be reset, got %d", meta.Orientation)
+	}
+
+	// Пиксели повёрнуты на 90° по часовой стрелке: левая (красная) половина оказалась сверху
+	img, err := jpeg.Decode(bytes.NewReader(photo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 16 {
+		t.Fatalf("expected rotated 8x16 image, got %dx%d", b.Dx(), b.Dy())
+	}
+	if r, _, b, _ := img.At(4, 3).RGBA(); r < b {
+		t.Fatal("expected red on top after rotation")
+	}
+	if r, _, b, _ := img.At(4, 12).RGBA(); b < r {
+		t.Fatal("expected blue at the bottom after rotation")
+	}
+	thumbKey := strings.TrimPrefix(resp.Files[0].Variants["thumb"], "http://cdn.test/")
+	thumb, _, err := image.DecodeConfig(bytes.NewReader(read(thumbKey)))
+	if err != nil || thumb.Width > thumb.Height {
+		t.Fatalf("thumbnail must be rotated too, got %+v (%v)", thumb, err)
+	}
+
+	if notes := read(resp.Files[1].Key); bytes.Contains(notes, []byte("secret-location")) {
+		t.Fatal("png text chunks must be stripped")
+	}
+
+	// Без режима приватности файл сохраняется как есть
+	t.Setenv("IMAGE_STRIP_METADATA", "false")
+	router := setupRouter(testContainer)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, newUploadRequest(t, "upload-no-privacy", testFile{name: "photo.jpg", data: photoWithMetadata()}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, key := range listKeys(t, "photos/upload-no-privacy/") {
+		if !strings.Contains(key, "_thumb") && !bytes.Contains(read(key), []byte("PhoneCo")) {
+			t.Fatal("metadata must be kept when privacy mode is off")
+		}
+	}
+}
+
+func TestUploadWithoutFiles(t *testing.T) {
+	rec := serve(t, newUploadRequest(t, "upload-empty"), nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// brokenStorage — хранилище, которое не принимает записи, как недоступный S3.
+type brokenStorage struct {
+	repository.Storage
+}
+
+func (s *brokenStorage) UploadFile(context.Context, string, string, io.Reader, repository.Visibility) (string, error) {
+	return "", errors.New("storage is unavailable")
+}
+
+func TestUploadStorageFailureIsServerError(t *testing.T) {
+	storage := &brokenStorage{Storage: repository.NewMemoryRepository("http://cdn.test")}
+	container := *testContainer
+	container.Storage = storage
+	container.S3Handler = handlers.NewS3Handler(services.NewS3Service(storage))
+	router := setupRouter(&container)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, newUploadRequest(t, "upload-broken", testFile{name: "a.png", data: pngBytes}))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("storage failure: expected 500, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUploadRejectsExtension(t *testing.T) {
+	req := newUploadRequest(t, "upload-bad-ext",
+		testFile{name: "ok.png", data: pngBytes},
+		testFile{name: "Script.SH", data: []byte("#!/bin/sh\necho pwned\n")},
+	)
+	var resp struct {
+		Details []struct {
+			Field string `json:"field"`
+			File  string `json:"file"`
+		} `json:"details"`
+	}
+	rec := serve(t, req, &resp)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// Имя файла возвращается как есть, без приведения к нижнему регистру
+	if len(resp.Details) != 1 || resp.Details[0].Field != "file" || resp.Details[0].File != "Script.SH" {
+		t.Fatalf("expected the rejected file in details, got %s", rec.Body.String())
+	}
+	// ok.png был записан до отклонения Script.SH и должен быть удалён
+	if keys := listKeys(t, "photos/upload-bad-ext/"); len(keys) != 0 {
+		t.Fatalf("nothing must be stored, got %v", keys)
+	}
+}
+
+func TestUploadRejectsSpoofedContent(t *testing.T) {
+	req := newUploadRequest(t, "upload-spoofed",
+		testFile{name: "ok.png", data: pngBytes},
+		testFile{name: "script.png", data: []byte("#!/bin/sh\necho pwned\n")},
+	)
+	rec := serve(t, req, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if keys := listKeys(t, "photos/upload-spoofed/"); len(keys) != 0 {
+		t.Fatalf("nothing must be stored, got %v", keys)
+	}
+}
+
+func TestUploadUsesDetectedContentType(t *testing.T) {
+	urls := upload(t, "upload-detected", testFile{name: "photo.jpg", data: pngBytes})
+	if !strings.HasSuffix(urls[0], ".png") {
+		t.Fatalf("expected key extension to follow the detected type, got %q", urls[0])
+	}
+	info, err := testContainer.Storage.HeadFile(context.Background(), strings.TrimPrefix(urls[0], "http://cdn.test/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentType != "image/png" {
+		t.Fatalf("expected image/png, got %q", info.ContentType)
+	}
+}
+
+func TestUploadRejectsCorruptedImage(t *testing.T) {
+	truncated := pngBytes[:len(pngBytes)-20]
+	var resp struct {
+		Details []struct {
+			File  string `json:"file"`
+			Error string `json:"error"`
+		} `json:"details"`
+	}
+	req := newUploadRequest(t, "upload-corrupted",
+		testFile{name: "ok.png", data: pngBytes},
+		testFile{name: "broken.png", data: truncated},
+	)
+	rec := serve(t, req, &resp)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(resp.Details) != 1 || resp.Details[0].File != "broken.png" {
+		t.Fatalf("expected the broken file in details, got %s", rec.Body.String())
+	}
+	if keys := listKeys(t, "photos/upload-corrupted/"); len(keys) != 0 {
+		t.Fatalf("nothing must be stored, got %v", keys)
+	}
+}
+
+func TestUploadRejectsOversizedImage(t *testing.T) {
+	// Заголовок заявляет 20000x20000 — декодер не должен даже пытаться выделить память под пиксели
+	var resp struct {
+		Details []struct {
+			Field string `json:"field"`
+			Error string `json:"error"`
+		} `json:"details"`
+	}
+	rec := serve(t, newUploadRequest(t, "upload-bomb", testFile{name: "bomb.png", data: pngHeader(20000, 20000)}), &resp)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(resp.Details) != 1 || !strings.Contains(resp.Details[0].Error, "20000") {
+		t.Fatalf("expected a dimension error, got %s", rec.Body.String())
+	}
+	if keys := listKeys(t, "photos/upload-bomb/"); len(keys) != 0 {
+		t.Fatalf("nothing must be stored, got %v", keys)
+	}
+}
+
+func TestUploadConfiguredImageLimits(t *testing.T) {
+	t.Setenv("IMAGE_MAX_WIDTH", "8")
+	t.Setenv("IMAGE_MAX_PIXELS", "100")
+	container := ioc.NewContainerWithStorage(log.GetLogger(), repository.NewMemoryRepository("http://cdn.test"))
+	if container.ImageLimits.MaxWidth != 8 || container.ImageLimits.MaxHeight != 10000 || container.ImageLimits.MaxPixels != 100 {
+		t.Fatalf("unexpected limits %+v", container.ImageLimits)
+	}
+	router := setupRouter(container)
+
+	for _, tc := range []struct {
+		file testFile
+		want int
+	}{
+		{testFile{name: "wide.png", data: encodePNG(16, 4)}, http.StatusBadRequest}, // шире 8
+		{testFile{name: "big.png", data: encodePNG(8, 16)}, http.StatusBadRequest},  // больше 100 пикселей
+		{testFile{name: "ok.png", data: encodePNG(8, 8)}, http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, newUploadRequest(t, "image-limits", tc.file))
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.file.name, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	big := make([]byte, 50<<20+1)
+	copy(big, pngBytes)
+	req := newUploadRequest(t, "upload-too-big",
+		testFile{name: "small.png", data: pngBytes},
+		testFile{name: "big.png", data: big},
+	)
+	rec := serve(t, req, nil)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if keys := listKeys(t, "photos/upload-too-big/"); len(keys) != 0 {
+		t.Fatalf("nothing must be stored, got %v", keys)
+	}
+}
+
+func TestDeleteAllByID(t *testing.T) {
+	upload(t, "delete-all", testFile{name: "a.png", data: pngBytes}, testFile{name: "b.gif", data: pngBytes})
+	upload(t, "delete-all-other", testFile{name: "c.png", data: pngBytes})
+	stored := listKeys(t, "photos/delete-all/")
+
+	var resp struct {
+		Deleted []string `json:"deleted"`
+		Failed  []any    `json:"failed"`
+	}
+	rec := serve(t, httptest.NewRequest(http.MethodDelete, "/files/upload/delete-all", nil), &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !slices.Equal(resp.Deleted, stored) || resp.Failed == nil || len(resp.Failed) != 0 {
+		t.Fatalf("expected deleted %v and empty failed, got %s", stored, rec.Body.String())
+	}
+	if keys := listKeys(t, "photos/delete-all/"); len(keys) != 0 {
+		t.Fatalf("expected folder to be empty, got %v", keys)
+	}
+	if keys := listKeys(t, "photos/delete-all-other/"); len(keys) != 2 {
+		t.Fatalf("other folders must stay intact, got %v", keys)
+	}
+
+	rec = serve(t, httptest.NewRequest(http.MethodDelete, "/files/upload/delete-all", nil), nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for empty folder, got %d", rec.Code)
+	}
+}
+
+func TestDeleteAllByIDManyFiles(t *testing.T) {
+	// Больше одной страницы листинга
+	ctx := context.Background()
+	total := repository.ListPageSize*2 + 10
+	for i := range total {
+		key := fmt.Sprintf("photos/delete-many/%05d.png", i)
+		if _, err := testContainer.Storage.UploadFile(ctx, key, "image/png", bytes.NewReader(pngBytes), repository.VisibilityPublic); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := serve(t, httptest.NewRequest(http.MethodDelete, "/files/upload/delete-many", nil), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if keys := listKeys(t, "photos/delete-many/"); len(keys) != 0 {
+		t.Fatalf("expected folder to be empty, got %d keys", len(keys))
+	}
+}
+
+// deniedStorage — хранилище, которое отказывается удалять ключи из denied, как S3 с AccessDenied.
+type deniedStorage struct {
+	repository.Storage
+	denied map[string]bool
+}
+
+func (s *deniedStorage) DeleteFilesBatch(ctx context.Context, keys []string) error {
+	var allowed []string
+	var failed []repository.DeleteFailure
+	for _, key := range keys {
+		if s.denied[key] {
+			failed = append(failed, repository.DeleteFailure{Key: key, Reason: "AccessDenied: Access Denied"})
+			continue
+		}
+		allowed = append(allowed, key)
+	}
+	if err := s.Storage.DeleteFilesBatch(ctx, allowed); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &repository.DeleteFilesError{Failed: failed}
+	}
+	return nil
+}
+
+func TestDeleteAllByIDPartialFailure(t *testing.T) {
+	keys := []string{"photos/s3-partial/a.png", "photos/s3-partial/b.png", "photos/s3-partial/c.png"}
+	memRepo := repository.NewMemoryRepository("http://cdn.test")
+	for _, key := range keys {
+		if _, err := memRepo.UploadFile(context.Background(), key, "image/png", bytes.NewReader(pngBytes), repository.VisibilityPublic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage := &deniedStorage{Storage: memRepo, denied: map[string]bool{keys[1]: true}}
+	container := *testContainer
+	container.Storage = storage
+	container.S3Handler = handlers.NewS3Handler(services.NewS3Service(storage))
+	router := setupRouter(&container)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/upload/s3-partial", nil))
+	var resp struct {
+		Deleted []string                   `json:"deleted"`
+		Failed  []repository.DeleteFailure `json:"failed"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if fmt.Sprint(resp.Deleted) != fmt.Sprint([]string{keys[0], keys[2]}) ||
+		len(resp.Failed) != 1 || resp.Failed[0].Key != keys[1] || resp.Failed[0].Reason == "" {
+		t.Fatalf("unexpected delete report %+v", resp)
+	}
+}
+
+func TestDeleteOneByUUID(t *testing.T) {
+	urls := upload(t, "delete-one", testFile{name: "a.png", data: pngBytes}, testFile{name: "b.png", data: pngBytes})
+	key := strings.TrimPrefix(urls[0], "http://cdn.test/")
+	fileUUID := strings.TrimSuffix(strings.TrimPrefix(key, "photos/delete-one/"), ".png")
+
+	var resp struct {
+		Keys []string `json:"keys"`
+	}
+	rec := serve(t, httptest.NewRequest(http.MethodDelete, "/files/upload/delete-one/"+fileUUID, nil), &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	thumbKey := strings.TrimSuffix(key, ".png") + "_thumb.png"
+	if len(resp.Keys) != 2 || resp.Keys[0] != key || resp.Keys[1] != thumbKey {
+		t.Fatalf("expected deleted keys %q and %q, got %v", key, thumbKey, resp.Keys)
+	}
+	if keys := listKeys(t, "photos/delete-one/"); len(keys) != 2 {
+		t.Fatalf("expected the other file and its thumbnail left, got %v", keys)
+	}
+
+	rec = serve(t, httptest.NewRequest(http.MethodDelete, "/files/upload/delete-one/"+fileUUID, nil), nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing uuid, got %d", rec.Code)
+	}
+
+	// Часть UUID не должна удалять по префиксу чужие файлы
+	left := listKeys(t, "photos/delete-one/")
+	rec = serve(t, httptest.NewRequest(http.MethodDelete, "/files/upload/delete-one/"+strings.TrimPrefix(left[0], "photos/delete-one/")[:1], nil), nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a uuid prefix, got %d", rec.Code)
+	}
+	if keys := listKeys(t, "photos/delete-one/"); len(keys) != 2 {
+		t.Fatalf("a uuid prefix must not delete files, got %v", keys)
+	}
+}
+
+func TestDeleteOneByUUIDPartialFailure(t *testing.T) {
+	const fileUUID = "3f0c9a52-6b1e-4d7a-9c2f-8e5b1a4d6c70"
+	keys := []string{"photos/s3-partial/" + fileUUID + ".png", "photos/s3-partial/" + fileUUID + "_thumb.png"}
+	memRepo := repository.NewMemoryRepository("http://cdn.test")
+	for _, key := range keys {
+		if _, err := memRepo.UploadFile(context.Background(), key, "image/png", bytes.NewReader(pngBytes), repository.VisibilityPublic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage := &deniedStorage{Storage: memRepo, denied: map[string]bool{keys[1]: true}}
+	container := *testContainer
+	container.Storage = storage
+	container.S3Handler = handlers.NewS3Handler(services.NewS3Service(storage))
+	router := setupRouter(&container)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/files/upload/s3-partial/"+fileUUID, nil))
+	var resp struct {
+		Keys   []string                   `json:"keys"`
+		Failed []repository.DeleteFailure `json:"failed"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if fmt.Sprint(resp.Keys) != fmt.Sprint(keys[:1]) || len(resp.Failed) != 1 || resp.Failed[0].Key != keys[1] {
+		t.Fatalf("unexpected delete report %+v", resp)
+	}
+}
+
+// listedFile — элемент списка файлов в формате objects.
+type listedFile struct {
+	Key          string    `json:"key"`
+	ID           string    `json:"id"`
+	UUID         string    `json:"uuid"`
+	Variant      string    `json:"variant"`
+	URL          string    `json:"url"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag"`
+}
+
+func TestListAllFiles(t *testing.T) {
+	urls := upload(t, "list-all", testFile{name: "a.png", data: pngBytes})
+	key := strings.TrimPrefix(urls[0], "http://cdn.test/")
+	fileUUID := strings.TrimSuffix(strings.TrimPrefix(key, "photos/list-all/"), ".png")
+
+	var resp struct {
+		Files []listedFile `json:"files"`
+	}
+	rec := serve(t, httptest.NewRequest(http.MethodGet, "/files/objects?prefix=photos/list-all/", nil), &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	byKey := make(map[string]listedFile)
+	for _, f := range resp.Files {
+		byKey[f.Key] = f
+	}
+	original, thumb := byKey[key], byKey["photos/list-all/"+fileUUID+"_thumb.png"]
+	info, err := testContainer.Storage.HeadFile(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if original.ID != "list-all" || original.UUID != fileUUID || original.Variant != "" || original.URL != urls[0] ||
+		original.Size != info.Size || original.ContentType != "image/png" || original.ETag != info.ETag ||
+		original.LastModified.IsZero() {
+		t.Fatalf("unexpected original %+v", original)
+	}
+	if thumb.UUID != fileUUID || thumb.Variant != "thumb" {
+		t.Fatalf("unexpected thumbnail %+v", thumb)
+	}
+
+	// Прежний формат — массив URL
+	var legacy struct {
+		Files []string `json:"files"`
+	}
+	rec = serve(t, httptest.NewRequest(http.MethodGet, "/files/objects?prefix=photos/list-all/&format=urls", nil), &legacy)
+	if rec.Code != http.StatusOK || !contains(legacy.Files, urls[0]) {
+		t.Fatalf("expected %q in %s", urls[0], rec.Body.String())
+	}
+	if rec := serve(t, httptest.NewRequest(http.MethodGet, "/files/objects?format=xml", nil), nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format: expected 400, got %d", rec.Code)
+	}
+}
+
+func TestListFilesPagination(t *testing.T) {
+	ctx := context.Background()
+	var keys []string
+	for i := range 25 {
+		ext := ".png"
+		if i%5 == 0 {
+			ext = ".JPG"
+		}
+		key := fmt.Sprintf("photos/list-page/%02d%s", i, ext)
+		data := bytes.Repeat([]byte{1}, 100+i)
+		if _, err := testContainer.Storage.UploadFile(ctx, key, "image/png", bytes.NewReader(data), repository.VisibilityPublic); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	type page struct {
+		Files      []listedFile `json:"files"`
+		NextCursor string       `json:"next_cursor"`
+		Total      *int         `json:"total"`
+		TotalPages *int         `json:"total_pages"`
+	}
+	list := func(query string) (page, int) {
+		var resp page
+		rec := serve(t, httptest.NewRequest(http.MethodGet, "/files/objects?prefix=photos/list-page/&"+query, nil), &resp)
+		return resp, rec.Code
+	}
+
+	// Проход по курсорам возвращает все файлы по одному разу и в порядке ключей
+	var listed []string
+	cursor, pages := "", 0
+	for {
+		resp, code := list("limit=10&cursor=" + url.QueryEscape(cursor))
+		if code != http.StatusOK || len(resp.Files) > 10 {
+			t.Fatalf("page %d: unexpected response %d %+v", pages, code, resp)
+		}
+		for _, f := range resp.Files {
+			listed = append(listed, f.Key)
+		}
+		pages++
+		if cursor = resp.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if !slices.Equal(listed, keys) || pages != 3 {
+		t.Fatalf("expected %d keys in 3 pages, got %d in %d: %v", len(keys), len(listed), pages, listed)
+	}
+
+	// Фильтры
+	resp, _ := list("ext=jpg&limit=3")
+	if len(resp.Files) != 3 || resp.NextCursor == "" || resp.Files[0].Key != keys[0] || resp.Files[1].Key != keys[5] {
+		t.Fatalf("ext filter: unexpected page %+v", resp)
+	}
+	resp, _ = list("ext=jpg&limit=3&cursor=" + url.QueryEscape(resp.NextCursor))
+	if len(resp.Files) != 2 || resp.NextCursor != "" || resp.Files[0].Key != keys[15] {
+		t.Fatalf("ext filter, second page: unexpected page %+v", resp)
+	}
+	resp, _ = list("min_size=110&max_size=114")
+	if len(resp.Files) != 5 || resp.Files[0].Size != 110 || resp.Files[4].Size != 114 {
+		t.Fatalf("size filter: unexpected page %+v", resp)
+	}
+	resp, _ = list("modified_since=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))
+	if len(resp.Files) != 0 || resp.NextCursor != "" {
+		t.Fatalf("modified_since filter: unexpected page %+v", resp)
+	}
+
+	// Сортировка внутри страницы и подсчёт
+	resp, _ = list("limit=10&sort=size&order=desc&count=true")
+	if len(resp.Files) != 10 || resp.Files[0].Size != 109 || resp.Files[9].Size != 100 {
+		t.Fatalf("sort: unexpected page %+v", resp)
+	}
+	if resp.Total == nil || *resp.Total != 25 || *resp.TotalPages != 3 {
+		t.Fatalf("count: expected 25 files in 3 pages, got %v %v", resp.Total, resp.TotalPages)
+	}
+
+	// Некорректные параметры
+	first, _ := list("limit=10")
+	for _, query := range []string{
+		"limit=5000",
+		"sort=name",
+		"min_size=-1",
+		"min_size=10&max_size=5",
+		"modified_since=yesterday",
+		"cursor=not-a-cursor",
+	} {
+		if _, code := list(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/files/objects?prefix=photos/other/&cursor="+url.QueryEscape(first.NextCursor), nil)
+	if rec := serve(t, req, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("cursor of another prefix: expected 400, got %d", rec.Code)
+	}
+}
+
+type browseResponse struct {
+	Prefix  string `json:"prefix"`
+	Folders []struct {
+		Prefix string `json:"prefix"`
+		Name   string `json:"name"`
+		Files  int    `json:"files"`
+		Bytes  int64  `json:"bytes"`
+	} `json:"folders"`
+	Files      []listedFile `json:"files"`
+	NextCursor string       `json:"next_cursor"`
+}
+
+func TestBrowseFolders(t *testing.T) {
+	ctx := context.Background()
+	for key, size := range map[string]int{
+		"browse-root/a/1.png":      10,
+		"browse-root/a/2.png":      20,
+		"browse-root/a/deep/3.png": 30,
+		"browse-root/b/4.png":      40,
+		"browse-root/c.png":        5,
+		"browse-root/d.png":        6,
+	} {
+		if _, err := testContainer.Storage.UploadFile(ctx, key, "image/png", bytes.NewReader(make([]byte, size)), repository.VisibilityPublic); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var resp browseResponse
+	rec := serve(t, httptest.NewRequest(http.MethodGet, "/files/objects/browse?prefix=browse-root", nil), &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if resp.Prefix != "browse-root/" || len(resp.Folders) != 2 || len(resp.Files) != 2 || resp.NextCursor != "" {
+		t.Fatalf("unexpected listing %s", rec.Body.String())
+	}
+	a, b := resp.Folders[0], resp.Folders[1]
+	if a.Prefix != "browse-root/a/" || a.Name != "a" || a.Files != 3 || a.Bytes != 60 ||
+		b.Name != "b" || b.Files != 1 || b.Bytes != 40 {
+		t.Fatalf("unexpected folders %+v", resp.Folders)
+	}
+	if resp.Files[0].Key != "browse-root/c.png" || resp.Files[0].Size != 5 {
+		t.Fatalf("unexpected files %+v", resp.Files)
+	}
+
+	// Подпапки и файлы делят одну страницу
+	rec = serve(t, httptest.NewRequest(http.MethodGet, "/files/objects/browse?prefix=browse-root/&limit=3", nil), &resp)
+	if rec.Code != http.StatusOK || len(resp.Folders) != 2 || len(resp.Files) != 1 || resp.NextCursor == "" {
+		t.Fatalf("first page: unexpected listing %s", rec.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/files/objects/browse?prefix=browse-root/&limit=3&cursor="+url.QueryEscape(resp.NextCursor), nil)
+	resp = browseResponse{}
+	rec = serve(t, req, &resp)
+	if rec.Code != http.StatusOK || len(resp.Folders) != 0 || len(resp.Files) != 1 ||
+		resp.Files[0].Key != "browse-root/d.png" || resp.NextCursor != "" {
+		t.Fatalf("second page: unexpected listing %s", rec.Body.String())
+	}
+
+	rec = serve(t, httptest.NewRequest(http.MethodGet, "/files/objects/browse?prefix=browse-root/a/", nil), &resp)
+	if rec.Code != http.StatusOK || len(resp.Folders) != 1 || resp.Folders[0].Name != "deep" || len(resp.Files) != 2 {
+		t.Fatalf("nested folder: unexpected listing %s", rec.Body.String())
+	}
+	if rec := serve(t, httptest.NewRequest(http.MethodGet, "/files/objects/browse?cursor=broken", nil), nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("broken cursor: expected 400, got %d", rec.Code)
+	}
+}
+
+func TestFolderExists(t *testing.T) {
+	urls := upload(t, "folder-exists", testFile{name: "a.png", data: pngBytes})
+
+	var resp struct {
+		Folder string       `json:"folder"`
+		Exists bool         `json:"exists"`
+		Files  []listedFile `json:"files"`
+	}
+	req := httptest.NewRequest(http.MethodGet, "/files/objects/exists?folder=photos/folder-exists", nil)
+	rec := serve(t, req, &resp)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !resp.Exists || len(resp.Files) != 2 || resp.Files[0].URL != urls[0] ||
+		resp.Files[0].ID != "folder-exists" || resp.Files[0].Size == 0 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	// Файлы отдаются страницами, как в /objects
+	var page struct {
+		Files      []listedFile `json:"files"`
+		NextCursor string       `json:"next_cursor"`
+	}
+	req = httptest.NewRequest(http.MethodGet, "/files/objects/exists?folder=photos/folder-exists&limit=1", nil)
+	if rec := serve(t, req, &page); rec.Code != http.StatusOK || len(page.Files) != 1 || page.NextCursor == "" {
+		t.Fatalf("unexpected first page: %s", rec.Body.String())
+	}
+	first := page.Files[0].Key
+	req = httptest.NewRequest(http.MethodGet, "/files/objects/exists?folder=photos/folder-exists&limit=1&cursor="+page.NextCursor, nil)
+	page.NextCursor = ""
+	if rec := serve(t, req, &page); rec.Code != http.StatusOK || len(page.Files) != 1 ||
+		page.Files[0].Key == first || page.NextCursor != "" {
+		t.Fatalf("unexpected second page: %s", rec.Body.String())
+	}
+
+	var legacy struct {
+		Files []string `json:"files"`
+	}
+	req = httptest.NewRequest(http.MethodGet, "/files/objects/exists?folder=photos/folder-exists&format=urls", nil)
+	if rec := serve(t, req, &legacy); rec.Code != http.StatusOK || len(legacy.Files) != 2 || legacy.Files[0] != urls[0] {
+		t.Fatalf("unexpected legacy response: %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/files/objects/exists?folder=photos/folder-missing", nil)
+	if rec := serve(t, req, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/files/objects/exists", nil)
+	if rec := serve(t, req, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestTransformImage(t *testing.T) {
+	urls := upload(t, "transform", testFile{name: "a.png", data: encodePNG(16, 8)})
+	key := strings.TrimPrefix(urls[0], "http://cdn.test/")
+	fileUUID := strings.TrimSuffix(strings.TrimPrefix(key, "photos/transform/"), ".png")
+	base := "/files/img/transform/" + fileUUID
+
+	// Пресет
+	rec := serve(t, httptest.NewRequest(http.MethodGet, base+"?preset=square", nil), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("preset: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	cfg, err := png.DecodeConfig(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 6 || cfg.Height != 6 {
+		t.Fatalf("expected 6x6, got %dx%d", cfg.Width, cfg.Height)
+	}
+	if keys := listKeys(t, "photos/transform/"+fileUUID+"_tr-"); len(keys) != 1 {
+		t.Fatalf("expected the result to be cached, got %v", keys)
+	}
+
+	// Произвольные параметры без подписи запрещены
+	rec = serve(t, httptest.NewRequest(http.MethodGet, base+"?w=5&fmt=jpeg", nil), nil)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("unsigned: expected 403, got %d", rec.Code)
+	}
+
+	// С подписью — разрешены
+	transform, err := imaging.ParseTransform(url.Values{"w": {"5"}, "fmt": {"jpeg"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := testContainer.ImageService.Sign("transform", fileUUID, transform)
+	rec = serve(t, httptest.NewRequest(http.MethodGet, base+"?w=5&fmt=jpeg&sig="+sig, nil), nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("signed: expected 200 image/jpeg, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	rec = serve(t, httptest.NewRequest(http.MethodGet, base+"?preset=unknown", nil), nil)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("unknown preset: expected 403, got %d", rec.Code)
+	}
+	rec = serve(t, httptest.NewRequest(http.MethodGet, base+"?w=100000&sig="+sig, nil), nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid params: expected 400, got %d", rec.Code)
+	}
+	rec = serve(t, httptest.NewRequest(http.MethodGet, "/files/img/transform/"+missingUUID+"?preset=square", nil), nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("missing file: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestTransformCacheControl(t *testing.T) {
+	urls := upload(t, "9001", testFile{name: "a.png", data: encodePNG(16, 8)})
+	fileUUID := strings.TrimSuffix(path.Base(urls[0]), ".png")
+	target := "/files/img/9001/" + fileUUID + "?preset=square"
+	cacheControl := func(router *gin.Engine, token string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return rec.Header().Get("Cache-Control")
+	}
+
+	if got := cacheControl(testRouter, ""); got != "public, max-age=31536000, immutable" {
+		t.Fatalf("public original without auth: unexpected Cache-Control %q", got)
+	}
+
+	// Ответ на запрос с токеном общие кэши хранить не должны
+	jwtService := newJWT(t, services.JWTConfig{Secret: testJWTSecret, Leeway: 30 * time.Second})
+	token, err := jwtService.GenerateAccessToken(9001, []string{services.ScopeRead}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cacheControl(authRouter(jwtService), token); got != "private, max-age=31536000, immutable" {
+		t.Fatalf("with auth: unexpected Cache-Control %q", got)
+	}
+
+	t.Setenv("UPLOAD_VISIBILITY", "private")
+	if got := cacheControl(setupRouter(testContainer), ""); got != "private, max-age=31536000, immutable" {
+		t.Fatalf("private originals: unexpected Cache-Control %q", got)
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	urls := upload(t, "download-file", testFile{name: "a.png", data: pngBytes})
+	key := strings.TrimPrefix(urls[0], "http://cdn.test/")
+	fileUUID := strings.TrimSuffix(strings.TrimPrefix(key, "photos/download-file/"), ".png")
+	target := "/files/download-file/" + fileUUID
+
+	rec := serve(t, httptest.NewRequest(http.MethodGet, target, nil), nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), pngBytes) {
+		t.Fatalf("expected the original file, got %d", rec.Code)
+	}
+	etag, lastModified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if rec.Header().Get("Content-Type") != "image/png" || etag == "" || lastModified == "" ||
+		rec.Header().Get("Content-Length") != strconv.Itoa(len(pngBytes)) {
+		t.Fatalf("unexpected headers: %v", rec.Header())
+	}
+
+	// HEAD — те же заголовки без тела
+	rec = serve(t, httptest.NewRequest(http.MethodHead, target, nil), nil)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+		t.Fatalf("HEAD: unexpected response %d %v", rec.Code, rec.Header())
+	}
+
+	// Один диапазон
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Range", "bytes=1-3")
+	rec = serve(t, req, nil)
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), pngBytes[1:4]) ||
+		rec.Header().Get("Content-Range") != fmt.Sprintf("bytes 1-3/%d", len(pngBytes)) {
+		t.Fatalf("range: unexpected response %d %v %q", rec.Code, rec.Header(), rec.Body.Bytes())
+	}
+
+	// Несколько диапазонов — multipart/byteranges
+	req = httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Range", "bytes=0-1,-2")
+	rec = serve(t, req, nil)
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if rec.Code != http.StatusPartialContent || err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("multi-range: unexpected response %d %v", rec.Code, rec.Header())
+	}
+	reader := multipart.NewReader(rec.Body, params["boundary"])
+	for _, want := range [][]byte{pngBytes[:2], pngBytes[len(pngBytes)-2:]} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(part)
+		if !bytes.Equal(got, want) || part.Header.Get("Content-Type") != "image/png" {
+			t.Fatalf("multi-range: expected %q, got %q (%v)", want, got, part.Header)
+		}
+	}
+
+	// Недопустимый диапазон
+	req = httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(pngBytes)+10))
+	if rec = serve(t, req, nil); rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("invalid range: expected 416, got %d", rec.Code)
+	}
+
+	// Условные запросы
+	req = httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("If-None-Match", etag)
+	if rec = serve(t, req, nil); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("If-None-Match: expected 304, got %d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	if rec = serve(t, req, nil); rec.Code != http.StatusNotModified {
+		t.Fatalf("If-Modified-Since: expected 304, got %d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	if rec = serve(t, req, nil); rec.Code != http.StatusOK {
+		t.Fatalf("stale If-None-Match: expected 200, got %d", rec.Code)
+	}
+
+	// Варианты и несуществующие файлы через этот маршрут не отдаются
+	for _, missing := range []string{"/files/download-file/" + missingUUID, "/files/download-file/" + fileUUID + "_thumb"} {
+		if rec = serve(t, httptest.NewRequest(http.MethodGet, missing, nil), nil); rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", missing, rec.Code)
+		}
+	}
+
+	// Тот же файл отдаётся и через /download/:id/:uuid
+	if rec = serve(t, httptest.NewRequest(http.MethodGet, "/files/download/download-file/"+fileUUID, nil), nil); rec.Code != http.StatusOK ||
+		!bytes.Equal(rec.Body.Bytes(), pngBytes) {
+		t.Fatalf("/download: expected the original file, got %d", rec.Code)
+	}
+
+	// В папки с :id, совпадающим со статическими маршрутами, загружать нельзя,
+	// а файлы, загруженные раньше, скачиваются через /download/:id/:uuid
+	for _, id := range []string{"objects", "presign", "img", "tus", "download", "upload"} {
+		if rec = serve(t, newUploadRequest(t, id, testFile{name: "a.png", data: pngBytes}), nil); rec.Code != http.StatusBadRequest {
+			t.Fatalf("upload to %s: expected 400, got %d", id, rec.Code)
+		}
+		key := "photos/" + id + "/" + missingUUID + ".png"
+		if _, err := testContainer.Storage.UploadFile(context.Background(), key, "image/png", bytes.NewReader(pngBytes), repository.VisibilityPublic); err != nil {
+			t.Fatal(err)
+		}
+		target := "/files/download/" + id + "/" + missingUUID
+		if rec = serve(t, httptest.NewRequest(http.MethodGet, target, nil), nil); rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", target, rec.Code, rec.Body.String())
+		}
+		if err := testContainer.Storage.DeleteFile(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPrivateUploadAndPresign(t *testing.T) {
+	t.Setenv("UPLOAD_VISIBILITY", "private")
+	t.Setenv("PRESIGN_MAX_EXPIRY", "1h")
+	router := setupRouter(testContainer)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, newUploadRequest(t, "private", testFile{name: "a.png", data: pngBytes}))
+	var resp struct {
+		Files []struct {
+			Key     string `json:"key"`
+			Private bool   `json:"private"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK || !resp.Files[0].Private {
+		t.Fatalf("expected a private upload, got %d: %s", rec.Code, rec.Body.String())
+	}
+	memory := testContainer.Storage.(*presignMemory)
+	for _, key := range listKeys(t, "photos/private/") {
+		if visibility, _ := memory.Visibility(key); visibility != repository.VisibilityPrivate {
+			t.Fatalf("%s: expected private object, got %q", key, visibility)
+		}
+	}
+	fileUUID := strings.TrimSuffix(strings.TrimPrefix(resp.Files[0].Key, "photos/private/"), ".png")
+	base := "/files/presign/private/" + fileUUID
+
+	var presigned struct {
+		Key       string    `json:"key"`
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, base+"?expires_in=600&filename=photo.png", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &presigned); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("presign: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	signed, err := url.Parse(presigned.URL)
+	if err != nil || presigned.Key != resp.Files[0].Key || signed.Query().Get("signature") == "" ||
+		signed.Query().Get("disposition") != `attachment; filename=photo.png` {
+		t.Fatalf("unexpected presigned URL: %+v", presigned)
+	}
+	if left := time.Until(presigned.ExpiresAt); left < 9*time.Minute || left > 11*time.Minute {
+		t.Fatalf("expected the link to expire in 10 minutes, got %s", left)
+	}
+
+	// Ссылка на вариант
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, base+"?variant=thumb", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &presigned); err != nil || rec.Code != http.StatusOK ||
+		!strings.Contains(presigned.Key, "_thumb.") {
+		t.Fatalf("variant: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for query, want := range map[string]int{
+		"?expires_in=7200":      http.StatusBadRequest,
+		"?expires_in=abc":       http.StatusBadRequest,
+		"?disposition=download": http.StatusBadRequest,
+		"?variant=missing":      http.StatusNotFound,
+	} {
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, base+query, nil))
+		if rec.Code != want {
+			t.Fatalf("%s: expected %d, got %d", query, want, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/presign/private/"+missingUUID, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("missing file: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestLocalStorageServesPrivateFilesBySignature(t *testing.T) {
+	fsRepo, err := repository.NewFSRepository(t.TempDir(), "/storage", "test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for key, visibility := range map[string]repository.Visibility{
+		"photos/local/public.png":  repository.VisibilityPublic,
+		"photos/local/private.png": repository.VisibilityPrivate,
+	} {
+		if _, err := fsRepo.UploadFile(ctx, key, "image/png", bytes.NewReader(pngBytes), visibility); err != nil {
+			t.Fatal(err)
+		}
+	}
+	container := *testContainer
+	container.Storage = fsRepo
+	router := setupRouter(&container)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	if rec := get("/storage/photos/local/public.png"); rec.Code != http.StatusOK {
+		t.Fatalf("public: expected 200, got %d", rec.Code)
+	}
+	if rec := get("/storage/photos/local/private.png"); rec.Code != http.StatusForbidden {
+		t.Fatalf("private without signature: expected 403, got %d", rec.Code)
+	}
+	presigned, err := fsRepo.PresignGetURL(ctx, "photos/local/private.png", repository.PresignOptions{
+		Expires:            time.Minute,
+		ContentDisposition: "attachment",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := get(presigned)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), pngBytes) ||
+		rec.Header().Get("Content-Disposition") != "attachment" {
+		t.Fatalf("private with signature: expected 200, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := get(strings.Replace(presigned, "attachment", "inline", 1)); rec.Code != http.StatusForbidden {
+		t.Fatalf("tampered link: expected 403, got %d", rec.Code)
+	}
+	if rec := get("/storage/photos/local/"); rec.Code != http.StatusNotFound {
+		t.Fatalf("directory listing: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestDirectUpload(t *testing.T) {
+	presign := func(body string) (*httptest.ResponseRecorder, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/files/upload/direct/presign", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		var resp map[string]any
+		return serve(t, req, &resp), resp
+	}
+	confirm := func(key string) (*httptest.ResponseRecorder, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/files/upload/direct/confirm", strings.NewReader(`{"key":"`+key+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		var resp map[string]any
+		return serve(t, req, &resp), resp
+	}
+
+	rec, resp := presign(fmt.Sprintf(`{"file_name":"a.png","size":%d}`, len(pngBytes)))
+	key, _ := resp["key"].(string)
+	if rec.Code != http.StatusOK || resp["method"] != "PUT" || !strings.HasPrefix(key, "uploads/direct/direct/") ||
+		!strings.HasSuffix(key, ".png") || resp["headers"].(map[string]any)["Content-Type"] != "image/png" {
+		t.Fatalf("presign: unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Клиент загружает файл по ссылке — здесь кладём его в хранилище сами
+	ctx := context.Background()
+	if _, err := testContainer.Storage.UploadFile(ctx, key, "image/png", bytes.NewReader(pngBytes), repository.VisibilityPublic); err != nil {
+		t.Fatal(err)
+	}
+	rec, resp = confirm(key)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirm: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	file := resp["files"].([]any)[0].(map[string]any)
+	if file["key"] != "photos/direct/"+path.Base(key) || file["variants"].(map[string]any)["thumb"] == nil {
+		t.Fatalf("confirm: unexpected response %s", rec.Body.String())
+	}
+	if keys := listKeys(t, "photos/direct/"); len(keys) != 2 {
+		t.Fatalf("expected the original and its thumb, got %v", keys)
+	}
+	if keys := listKeys(t, "uploads/direct/"); len(keys) != 0 {
+		t.Fatalf("confirmed upload must be deleted, got %v", keys)
+	}
+
+	// POST-политика
+	if rec, resp = presign(`{"file_name":"b.jpg","method":"post"}`); rec.Code != http.StatusOK ||
+		resp["method"] != "POST" || resp["fields"].(map[string]any)["Content-Type"] != "image/jpeg" {
+		t.Fatalf("presign post: unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Подмена содержимого: файл удаляется
+	_, resp = presign(fmt.Sprintf(`{"file_name":"c.png","size":%d}`, len(pngBytes)))
+	spoofed := resp["key"].(string)
+	if _, err := testContainer.Storage.UploadFile(ctx, spoofed, "image/png", strings.NewReader("#!/bin/sh\necho"), repository.VisibilityPublic); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ = confirm(spoofed); rec.Code != http.StatusBadRequest {
+		t.Fatalf("spoofed: expected 400, got %d", rec.Code)
+	}
+	if _, err := testContainer.Storage.HeadFile(ctx, spoofed); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("rejected file must be deleted, got %v", err)
+	}
+
+	for body, want := range map[string]int{
+		`{"file_name":"a.png"}`:                          http.StatusBadRequest, // PUT без размера
+		`{"file_name":"a.png","size":104857600}`:         http.StatusBadRequest,
+		`{"file_name":"script.sh","size":10}`:            http.StatusBadRequest,
+		`{"file_name":"a.png","size":10,"method":"get"}`: http.StatusBadRequest,
+		`not json`: http.StatusBadRequest,
+	} {
+		if rec, _ = presign(body); rec.Code != want {
+			t.Fatalf("presign %s: expected %d, got %d", body, want, rec.Code)
+		}
+	}
+	for key, want := range map[string]int{
+		"uploads/direct/direct/" + missingUUID + ".png": http.StatusNotFound,
+		"uploads/direct/other/" + missingUUID + ".png":  http.StatusBadRequest,
+		"photos/direct/" + missingUUID + ".png":         http.StatusBadRequest,
+		"uploads/direct/direct/not-a-uuid.png":          http.StatusBadRequest,
+	} {
+		if rec, _ = confirm(key); rec.Code != want {
+			t.Fatalf("confirm %s: expected %d, got %d", key, want, rec.Code)
+		}
+	}
+}
+
+func TestMemoryStorageDoesNotPresign(t *testing.T) {
+	memRepo := repository.NewMemoryRepository("http://cdn.test")
+	key := "photos/memory-presign/" + missingUUID + ".png"
+	if _, err := memRepo.UploadFile(context.Background(), key, "image/png", bytes.NewReader(pngBytes), repository.VisibilityPublic); err != nil {
+		t.Fatal(err)
+	}
+	container := *testContainer
+	container.Storage = memRepo
+	container.S3Handler = handlers.NewS3Handler(services.NewS3Service(memRepo))
+	router := setupRouter(&container)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/presign/memory-presign/"+missingUUID, nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Fatalf("presign download: expected 501, got %d: %s", rec.Code, rec.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodPost, "/files/upload/memory-presign/presign", strings.NewReader(`{"file_name":"a.png","size":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotImplemented {
+		t.Fatalf("presign upload: expected 501, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// noisePNG кодирует изображение из случайных пикселей: такой PNG почти не сжимается
+// и весит около 4*width*height байт.
+func noisePNG(width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for i := range img.Pix {
+		img.Pix[i] = byte(rnd.Uint32())
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// tusRequest собирает запрос протокола tus с заголовком Tus-Resumable.
+func tusRequest(method, target string, body []byte, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return req
+}
+
+// tusUpload создаёт загрузку файла name и отправляет его кусками по chunk байт.
+// Возвращает адрес загрузки и ответ на последний PATCH.
+func tusUpload(t *testing.T, router *gin.Engine, id, name string, data []byte, chunk int) (string, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, tusRequest(http.MethodPost, "/files/tus/"+id, nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(name)) + ",is_confidential",
+	}))
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusCreated || !strings.HasPrefix(location, "/files/tus/"+id+"/") ||
+		rec.Header().Get("Upload-Expires") == "" || rec.Header().Get("Tus-Resumable") != "1.0.0" {
+		t.Fatalf("create: unexpected response %d %v: %s", rec.Code, rec.Header(), rec.Body.String())
+	}
+
+	for offset := 0; offset < len(data); offset += chunk {
+		end := min(offset+chunk, len(data))
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, tusRequest(http.MethodPatch, location, data[offset:end], map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		}))
+		if rec.Code != http.StatusNoContent {
+			return location, rec
+		}
+		if got := rec.Header().Get("Upload-Offset"); got != strconv.Itoa(end) {
+			t.Fatalf("patch: expected offset %d, got %s", end, got)
+		}
+	}
+	return location, rec
+}
+
+func TestTusUpload(t *testing.T) {
+	rec := serve(t, httptest.NewRequest(http.MethodOptions, "/files/tus/tus-upload", nil), nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Tus-Version") != "1.0.0" ||
+		rec.Header().Get("Tus-Extension") != "creation,termination,expiration" ||
+		rec.Header().Get("Tus-Max-Size") != strconv.Itoa(50<<20) {
+		t.Fatalf("options: unexpected response %d %v", rec.Code, rec.Header())
+	}
+
+	req := tusRequest(http.MethodPost, "/files/tus/tus-upload", nil, map[string]string{"Upload-Length": "10"})
+	req.Header.Set("Tus-Resumable", "0.2.2")
+	if rec := serve(t, req, nil); rec.Code != http.StatusPreconditionFailed || rec.Header().Get("Tus-Version") != "1.0.0" {
+		t.Fatalf("old protocol version: expected 412, got %d", rec.Code)
+	}
+	for headers, want := range map[[2]string]int{
+		{"", "filename YS5wbmc="}:                     http.StatusBadRequest,            // нет Upload-Length
+		{"100", ""}:                                   http.StatusBadRequest,            // нет имени файла
+		{"100", "filename YS5leGU="}:                  http.StatusBadRequest,            // a.exe
+		{strconv.Itoa(51 << 20), "filename YS5wbmc="}: http.StatusRequestEntityTooLarge, // больше Tus-Max-Size
+	} {
+		req := tusRequest(http.MethodPost, "/files/tus/tus-upload", nil, map[string]string{
+			"Upload-Length": headers[0], "Upload-Metadata": headers[1],
+		})
+		if rec := serve(t, req, nil); rec.Code != want {
+			t.Errorf("create %v: expected %d, got %d: %s", headers, want, rec.Code, rec.Body.String())
+		}
+	}
+
+	// Файл больше части multipart-загрузки, куски меньше части: остаток копится между PATCH
+	data := noisePNG(1300, 1300)
+	if len(data) <= repository.MinPartSize {
+		t.Fatalf("test image must be larger than one part, got %d bytes", len(data))
+	}
+	location, rec := tusUpload(t, testRouter, "tus-upload", "big.png", data, 2<<20)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("patch: expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = serve(t, tusRequest(http.MethodHead, location, nil, nil), nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != strconv.Itoa(len(data)) ||
+		rec.Header().Get("Cache-Control") != "no-store" || rec.Header().Get("Upload-Metadata") == "" {
+		t.Fatalf("head: unexpected response %d %v", rec.Code, rec.Header())
+	}
+
+	var resp struct {
+		Files []struct {
+			Key      string            `json:"key"`
+			Variants map[string]string `json:"variants"`
+		} `json:"files"`
+	}
+	if rec := serve(t, tusRequest(http.MethodGet, location, nil, nil), &resp); rec.Code != http.StatusOK || len(resp.Files) != 1 {
+		t.Fatalf("get: unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	key := resp.Files[0].Key
+	if !strings.HasPrefix(key, "photos/tus-upload/") || !strings.HasSuffix(key, ".png") || resp.Files[0].Variants["thumb"] == "" {
+		t.Fatalf("unexpected file %+v", resp.Files[0])
+	}
+	body, _, err := testContainer.Storage.GetFile(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if img, err := png.Decode(body); err != nil || img.Bounds().Dx() != 1300 {
+		t.Fatalf("assembled file must be the uploaded image: %v", err)
+	}
+	memory := testContainer.Storage.(*presignMemory)
+	if visibility, _ := memory.Visibility(key); visibility != repository.VisibilityPublic {
+		t.Fatalf("confirmed file must get the route visibility, got %q", visibility)
+	}
+	if _, _, err := memory.GetFile(context.Background(), "uploads/tus/"+path.Base(location)+".bin"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("staged object must be deleted after confirmation: %v", err)
+	}
+	if n := memory.MultipartUploads(); n != 0 {
+		t.Fatalf("multipart uploads must be completed, %d left", n)
+	}
+}
+
+func TestTusPatchErrors(t *testing.T) {
+	data := pngBytes
+	rec := serve(t, tusRequest(http.MethodPost, "/files/tus/tus-errors", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": "filename YS5wbmc=",
+	}), nil)
+	location := rec.Header().Get("Location")
+	patch := func(offset int, body []byte, contentType string) *httptest.ResponseRecorder {
+		return serve(t, tusRequest(http.MethodPatch, location, body, map[string]string{
+			"Content-Type": contentType, "Upload-Offset": strconv.Itoa(offset),
+		}), nil)
+	}
+	if rec := patch(0, data[:10], "application/octet-stream"); rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("wrong content type: expected 415, got %d", rec.Code)
+	}
+	if rec := patch(0, data[:10], "application/offset+octet-stream"); rec.Code != http.StatusNoContent {
+		t.Fatalf("patch: expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := patch(0, data[:10], "application/offset+octet-stream"); rec.Code != http.StatusConflict ||
+		rec.Header().Get("Upload-Offset") != "10" {
+		t.Fatalf("stale offset: expected 409 with current offset, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := patch(10, append(bytes.Clone(data[10:]), 0), "application/offset+octet-stream"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("more data than Upload-Length: expected 413, got %d", rec.Code)
+	}
+	if rec := serve(t, tusRequest(http.MethodGet, location, nil, nil), nil); rec.Code != http.StatusConflict {
+		t.Fatalf("get of unfinished upload: expected 409, got %d", rec.Code)
+	}
+	if rec := serve(t, tusRequest(http.MethodHead, "/files/tus/other/"+path.Base(location), nil, nil), nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("upload of another id: expected 404, got %d", rec.Code)
+	}
+
+	// Содержимое не соответствует расширению: после последнего байта файл и загрузка удаляются
+	script := []byte("#!/bin/sh\necho pwned\n")
+	location, rec = tusUpload(t, testRouter, "tus-spoofed", "s.png", script, 8)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("spoofed: expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if keys := listKeys(t, "photos/tus-spoofed/"); len(keys) != 0 {
+		t.Fatalf("rejected file must be deleted, got %v", keys)
+	}
+	if rec := serve(t, tusRequest(http.MethodHead, location, nil, nil), nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("rejected upload must be removed, got %d", rec.Code)
+	}
+}
+
+func TestTusTerminateAndCleanup(t *testing.T) {
+	create := func() string {
+		rec := serve(t, tusRequest(http.MethodPost, "/files/tus/tus-cleanup", nil, map[string]string{
+			"Upload-Length":   strconv.Itoa(len(pngBytes)),
+			"Upload-Metadata": "filename YS5wbmc=",
+		}), nil)
+		location := rec.Header().Get("Location")
+		rec = serve(t, tusRequest(http.MethodPatch, location, pngBytes[:20], map[string]string{
+			"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0",
+		}), nil)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("patch: expected 204, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return location
+	}
+	location := create()
+	if rec := serve(t, tusRequest(http.MethodDelete, location, nil, nil), nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("terminate: expected 204, got %d", rec.Code)
+	}
+	if rec := serve(t, tusRequest(http.MethodHead, location, nil, nil), nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("terminated upload: expected 404, got %d", rec.Code)
+	}
+
+	// Брошенная загрузка удаляется очисткой после истечения срока
+	location = create()
+	uploadID := path.Base(location)
+	if removed, err := testContainer.TusService.CleanupExpired(context.Background(), time.Now()); err != nil || removed != 0 {
+		t.Fatalf("fresh uploads must survive cleanup, removed %d: %v", removed, err)
+	}
+	if _, err := testContainer.TusService.CleanupExpired(context.Background(), time.Now().Add(25*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(t, tusRequest(http.MethodHead, location, nil, nil), nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expired upload: expected 404, got %d", rec.Code)
+	}
+	for _, key := range listKeys(t, "uploads/tus/") {
+		if strings.Contains(key, uploadID) {
+			t.Fatalf("expired upload data must be deleted, got %s", key)
+		}
+	}
+	// Очистка в будущем удаляет все брошенные загрузки, в том числе оставленные другими тестами
+	if n := testContainer.Storage.(*presignMemory).MultipartUploads(); n != 0 {
+		t.Fatalf("multipart uploads must be aborted, %d left", n)
+	}
+}
+
+func TestTusUploadLocalStorage(t *testing.T) {
+	fsRepo, err := repository.NewFSRepository(t.TempDir(), "/storage", "test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := *testContainer
+	container.Storage = fsRepo
+	container.TusHandler = handlers.NewTusHandler(
+		services.NewTusService(fsRepo, services.NewS3Service(fsRepo), time.Hour, 0),
+	)
+	router := setupRouter(&container)
+
+	location, rec := tusUpload(t, router, "local", "a.png", pngBytes, 16)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("patch: expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, tusRequest(http.MethodGet, location, nil, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var photos []string
+	err = fsRepo.ListPages(context.Background(), "", func(page []repository.ObjectInfo) error {
+		for _, obj := range page {
+			if strings.Contains(obj.Key, ".tmp-") {
+				t.Fatalf("multipart parts must not be listed, got %s", obj.Key)
+			}
+			if strings.HasPrefix(obj.Key, "photos/local/") {
+				photos = append(photos, obj.Key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(photos) != 2 {
+		t.Fatalf("expected the original and its thumb, got %v", photos)
+	}
+}
+
+// sessionRequest собирает JSON-запрос к API сессий загрузки.
+func sessionRequest(method, target string, body any) *http.Request {
+	data, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// chunkRequest собирает PUT куска с контрольной суммой SHA-256.
+func chunkRequest(target string, data []byte, checksum string) *http.Request {
+	req := httptest.NewRequest(http.MethodPut, target, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Checksum-SHA256", checksum)
+	return req
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type sessionResponse struct {
+	SessionID string `json:"session_id"`
+	Key       string `json:"key"`
+	Chunks    int    `json:"chunks"`
+	Received  []int  `json:"received"`
+	Missing   []int  `json:"missing"`
+}
+
+func TestUploadSessions(t *testing.T) {
+	for body, want := range map[string]int{
+		`{"file_name":"a.png","size":100,"chunk_size":40}`:           http.StatusBadRequest,            // куски меньше 5 МБ
+		`{"file_name":"a.exe","size":100,"chunk_size":100}`:          http.StatusBadRequest,            // расширение
+		`{"file_name":"a.png","size":0,"chunk_size":100}`:            http.StatusBadRequest,            // пустой файл
+		`{"file_name":"a.png","size":60000000,"chunk_size":6000000}`: http.StatusRequestEntityTooLarge, // больше 50 МБ
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/files/upload/sessions/sessions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if rec := serve(t, req, nil); rec.Code != want {
+			t.Errorf("create %s: expected %d, got %d: %s", body, want, rec.Code, rec.Body.String())
+		}
+	}
+
+	data := noisePNG(1300, 1300)
+	chunkSize := repository.MinPartSize
+	var session sessionResponse
+	rec := serve(t, sessionRequest(http.MethodPost, "/files/upload/sessions/sessions", map[string]any{
+		"file_name": "big.png", "size": len(data), "chunk_size": chunkSize,
+	}), &session)
+	if rec.Code != http.StatusCreated || session.Chunks != 2 || !strings.HasPrefix(session.Key, "photos/sessions/") ||
+		len(session.Missing) != 2 {
+		t.Fatalf("create: unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	base := "/files/upload/sessions/sessions/" + session.SessionID
+	first, second := data[:chunkSize], data[chunkSize:]
+
+	// Куски принимаются в любом порядке
+	if rec := serve(t, chunkRequest(base+"/chunks/2", second, sha256Hex(second)), nil); rec.Code != http.StatusOK {
+		t.Fatalf("chunk 2: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for name, req := range map[string]*http.Request{
+		"bad checksum":  chunkRequest(base+"/chunks/1", first, sha256Hex(second)),
+		"no checksum":   chunkRequest(base+"/chunks/1", first, ""),
+		"short chunk":   chunkRequest(base+"/chunks/1", first[:100], sha256Hex(first[:100])),
+		"unknown chunk": chunkRequest(base+"/chunks/3", second, sha256Hex(second)),
+	} {
+		if rec := serve(t, req, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := serve(t, httptest.NewRequest(http.MethodGet, base, nil), &session); rec.Code != http.StatusOK ||
+		fmt.Sprint(session.Received) != "[2]" || fmt.Sprint(session.Missing) != "[1]" {
+		t.Fatalf("status: unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(t, httptest.NewRequest(http.MethodPost, base+"/complete", nil), nil); rec.Code != http.StatusConflict {
+		t.Fatalf("complete with missing chunks: expected 409, got %d", rec.Code)
+	}
+	if rec := serve(t, chunkRequest(base+"/chunks/1", first, sha256Hex(first)), nil); rec.Code != http.StatusOK {
+		t.Fatalf("chunk 1: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Состояние хранится в хранилище: сессию завершает новый экземпляр сервиса (как после перезапуска)
+	container := *testContainer
+	container.UploadSessionHandler = handlers.NewUploadSessionHandler(services.NewUploadSessionService(
+		testContainer.Storage, testContainer.S3Service, time.Hour, 0,
+	))
+	router := setupRouter(&container)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, base+"/complete", nil))
+	var resp struct {
+		Files []struct {
+			Key      string            `json:"key"`
+			Variants map[string]string `json:"variants"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK ||
+		len(resp.Files) != 1 || resp.Files[0].Key != session.Key || resp.Files[0].Variants["thumb"] == "" {
+		t.Fatalf("complete: unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	info, err := testContainer.Storage.HeadFile(context.Background(), session.Key)
+	if err != nil || info.Size != int64(len(data)) {
+		t.Fatalf("assembled file must have the uploaded size: %+v, %v", info, err)
+	}
+	memory := testContainer.Storage.(*presignMemory)
+	if visibility, _ := memory.Visibility(session.Key); visibility != repository.VisibilityPublic {
+		t.Fatalf("confirmed file must get the route visibility, got %q", visibility)
+	}
+	if _, err := memory.HeadFile(context.Background(), "uploads/sessions/"+session.SessionID+"/object.bin"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("staged object must be deleted after confirmation: %v", err)
+	}
+
+	// Повторное завершение возвращает тот же файл, новые куски не принимаются
+	if rec := serve(t, httptest.NewRequest(http.MethodPost, base+"/complete", nil), nil); rec.Code != http.StatusOK {
+		t.Fatalf("repeated complete: expected 200, got %d", rec.Code)
+	}
+	if rec := serve(t, chunkRequest(base+"/chunks/1", first, sha256Hex(first)), nil); rec.Code != http.StatusConflict {
+		t.Fatalf("chunk after complete: expected 409, got %d", rec.Code)
+	}
+}
+
+func TestUploadSessionAbortAndCleanup(t *testing.T) {
+	create := func() string {
+		var session sessionResponse
+		rec := serve(t, sessionRequest(http.MethodPost, "/files/upload/sessions-abort/sessions", map[string]any{
+			"file_name": "a.png", "size": len(pngBytes), "chunk_size": len(pngBytes),
+		}), &session)
+		if rec.Code != http.StatusCreated || session.Chunks != 1 {
+			t.Fatalf("create: unexpected response %d: %s", rec.Code, rec.Body.String())
+		}
+		return session.SessionID
+	}
+	base := "/files/upload/sessions-abort/sessions/"
+
+	sessionID := create()
+	if rec := serve(t, chunkRequest(base+sessionID+"/chunks/1", pngBytes, sha256Hex(pngBytes)), nil); rec.Code != http.StatusOK {
+		t.Fatalf("chunk: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(t, httptest.NewRequest(http.MethodDelete, base+sessionID, nil), nil); rec.Code != http.StatusOK {
+		t.Fatalf("abort: expected 200, got %d", rec.Code)
+	}
+	if rec := serve(t, httptest.NewRequest(http.MethodGet, base+sessionID, nil), nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("aborted session: expected 404, got %d", rec.Code)
+	}
+	if keys := listKeys(t, "uploads/sessions/"+sessionID+"/"); len(keys) != 0 {
+		t.Fatalf("aborted session data must be deleted, got %v", keys)
+	}
+
+	// Отклонённый при проверке файл удаляется вместе с сессией и не попадает в photos
+	sessionID = create()
+	garbage := bytes.Repeat([]byte("x"), len(pngBytes))
+	if rec := serve(t, chunkRequest(base+sessionID+"/chunks/1", garbage, sha256Hex(garbage)), nil); rec.Code != http.StatusOK {
+		t.Fatalf("chunk: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(t, httptest.NewRequest(http.MethodPost, base+sessionID+"/complete", nil), nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("complete with a fake image: expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if keys := listKeys(t, "uploads/sessions/"+sessionID+"/"); len(keys) != 0 {
+		t.Fatalf("rejected session data must be deleted, got %v", keys)
+	}
+
+	sessionID = create()
+	if _, err := testContainer.UploadSessionService.CleanupExpired(context.Background(), time.Now().Add(25*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(t, httptest.NewRequest(http.MethodGet, base+sessionID, nil), nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expired session: expected 404, got %d", rec.Code)
+	}
+	if keys := listKeys(t, "photos/sessions-abort/"); len(keys) != 0 {
+		t.Fatalf("aborted sessions must not leave files, got %v", keys)
+	}
+}
+
+// testJWTSecret — секрет HS256 достаточной длины для тестов авторизации.
+const testJWTSecret = "test-jwt-secret-0123456789abcdef"
+
+// newJWT создаёт сервис токенов для тестов.
+func newJWT(t *testing.T, config services.JWTConfig) services.JWTServiceInterface {
+	t.Helper()
+
+	jwtService, err := services.NewJWTService(config, testContainer.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwtService
+}
+
+// authRouter собирает роутер с включённой проверкой JWT.
+func authRouter(jwtService services.JWTServiceInterface) *gin.Engine {
+	container := *testContainer
+	container.AuthEnabled = true
+	container.JwtService = jwtService
+	return setupRouter(&container)
+}
+
+// TestPrivateVisibilityRequiresAuth запускает тесты в дочернем процессе с UPLOAD_VISIBILITY=private:
+// TestMain собирает там контейнер с AUTH_ENABLED=false, и сервис должен отказаться стартовать.
+func TestPrivateVisibilityRequiresAuth(t *testing.T) {
+	if os.Getenv("TEST_PRIVATE_WITHOUT_AUTH") != "" {
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPrivateVisibilityRequiresAuth$")
+	cmd.Env = append(os.Environ(), "TEST_PRIVATE_WITHOUT_AUTH=1", "UPLOAD_VISIBILITY=private")
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || !strings.Contains(string(output), "UPLOAD_VISIBILITY=private requires authentication") {
+		t.Fatalf("a private container without auth must refuse to start, got %v: %s", err, output)
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	config := services.JWTConfig{Secret: testJWTSecret, Issuer: "auth.test", Audience: "files", Leeway: 30 * time.Second}
+	jwtService := newJWT(t, config)
+	router := authRouter(jwtService)
+
+	sign := func(svc services.JWTServiceInterface, expiresIn time.Duration) string {
+		token, err := svc.GenerateAccessToken(7, []string{services.ScopeRead}, expiresIn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	// Тот же секрет и claims, но другой алгоритм
+	hs512, err := jwt.NewWithClaims(jwt.SigningMethodHS512, services.Claims{
+		UserId: 7,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "auth.test",
+			Audience:  jwt.ClaimStrings{"files"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherIssuer := config
+	otherIssuer.Issuer = "evil.test"
+	otherAudience := config
+	otherAudience.Audience = "billing"
+	otherSecret := config
+	otherSecret.Secret = strings.Repeat("x", services.MinJWTSecretLength)
+
+	for name, tc := range map[string]struct {
+		header string
+		want   int
+	}{
+		"valid token":         {"Bearer " + sign(jwtService, time.Hour), http.StatusOK},
+		"lowercase scheme":    {"bearer " + sign(jwtService, time.Hour), http.StatusOK},
+		"expired within skew": {"Bearer " + sign(jwtService, -10*time.Second), http.StatusOK},
+		"missing header":      {"", http.StatusUnauthorized},
+		"basic scheme":        {"Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		"empty token":         {"Bearer ", http.StatusUnauthorized},
+		"expired":             {"Bearer " + sign(jwtService, -time.Minute), http.StatusUnauthorized},
+		"other algorithm":     {"Bearer " + hs512, http.StatusUnauthorized},
+		"other issuer":        {"Bearer " + sign(newJWT(t, otherIssuer), time.Hour), http.StatusUnauthorized},
+		"other audience":      {"Bearer " + sign(newJWT(t, otherAudience), time.Hour), http.StatusUnauthorized},
+		"other secret":        {"Bearer " + sign(newJWT(t, otherSecret), time.Hour), http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/files/objects?prefix=photos/7/", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", name, tc.want, rec.Code, rec.Body.String())
+			continue
+		}
+		if tc.want != http.StatusUnauthorized {
+			continue
+		}
+		var resp struct {
+			Error   string `json:"error"`
+			Details []struct {
+				Field string `json:"field"`
+				Error string `json:"error"`
+			} `json:"details"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error == "" ||
+			len(resp.Details) != 1 || resp.Details[0].Field != "authorization" ||
+			rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: unexpected error response %s", name, rec.Body.String())
+		}
+	}
+
+	// Без надёжного секрета сервис не создаётся
+	for _, secret := range []string{"", "short-secret"} {
+		if _, err := services.NewJWTService(services.JWTConfig{Secret: secret}, testContainer.Logger); err == nil {
+			t.Errorf("secret %q must be rejected", secret)
+		}
+	}
+}
+
+func TestOwnershipAuthorization(t *testing.T) {
+	router := authRouter(newJWT(t, services.JWTConfig{Secret: testJWTSecret, Issuer: "auth.test", Audience: "files"}))
+	sign := func(userId int, roles, folders []string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, services.Claims{
+			UserId:  userId,
+			Roles:   roles,
+			Folders: folders,
+			Scopes:  []string{services.ScopeRead, services.ScopeWrite, services.ScopeDelete},
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "auth.test",
+				Audience:  jwt.ClaimStrings{"files"},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}).SignedString([]byte(testJWTSecret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	owner := sign(41, nil, nil)
+	shared := sign(43, nil, []string{"42"})
+	admin := sign(1, []string{services.RoleAdmin}, nil)
+
+	upload(t, "41", testFile{name: "a.png", data: pngBytes})
+	uuid42 := strings.TrimSuffix(path.Base(upload(t, "42", testFile{name: "b.png", data: pngBytes})[0]), ".png")
+
+	for _, tc := range []struct {
+		method, target, token string
+		want                  int
+		field                 string // поле в ответе 403: id (чужая папка) или scope (нужен files:admin)
+	}{
+		{http.MethodGet, "/files/objects?prefix=photos/41/", owner, http.StatusOK, ""},
+		{http.MethodGet, "/files/objects?prefix=photos/42/", owner, http.StatusForbidden, "id"},
+		// Префикс photos/4 захватил бы и чужие папки photos/42/ — это листинг не одной папки
+		{http.MethodGet, "/files/objects?prefix=photos/4", owner, http.StatusForbidden, "scope"},
+		{http.MethodGet, "/files/objects", owner, http.StatusForbidden, "scope"},
+		{http.MethodGet, "/files/objects", admin, http.StatusOK, ""},
+		{http.MethodGet, "/files/objects/browse?prefix=photos/41", owner, http.StatusOK, ""},
+		{http.MethodGet, "/files/objects/browse?prefix=photos/", owner, http.StatusForbidden, "scope"},
+		{http.MethodGet, "/files/objects/exists?folder=photos/42", shared, http.StatusOK, ""},
+		{http.MethodGet, "/files/objects/exists?folder=photos/42/../41", shared, http.StatusForbidden, "scope"},
+		{http.MethodGet, "/files/42/" + uuid42, owner, http.StatusForbidden, "id"},
+		{http.MethodGet, "/files/42/" + uuid42, shared, http.StatusOK, ""},
+		{http.MethodGet, "/files/download/42/" + uuid42, owner, http.StatusForbidden, "id"},
+		{http.MethodGet, "/files/img/42/" + uuid42 + "?preset=square", owner, http.StatusForbidden, "id"},
+		{http.MethodGet, "/files/presign/42/" + uuid42, owner, http.StatusForbidden, "id"},
+		{http.MethodPost, "/files/upload/42", owner, http.StatusForbidden, "id"},
+		{http.MethodPost, "/files/upload/42/sessions", owner, http.StatusForbidden, "id"},
+		{http.MethodPost, "/files/tus/42", owner, http.StatusForbidden, "id"},
+		{http.MethodDelete, "/files/upload/42/" + uuid42, owner, http.StatusForbidden, "id"},
+		{http.MethodDelete, "/files/upload/42", owner, http.StatusForbidden, "id"},
+		{http.MethodDelete, "/files/upload/41", owner, http.StatusOK, ""},
+		{http.MethodDelete, "/files/upload/42", admin, http.StatusOK, ""},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		req.Header.Set("Tus-Resumable", services.TusVersion)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d: %s", tc.method, tc.target, tc.want, rec.Code, rec.Body.String())
+			continue
+		}
+		if tc.want == http.StatusForbidden && !strings.Contains(rec.Body.String(), `"field":"`+tc.field+`"`) {
+			t.Errorf("%s %s: unexpected error response %s", tc.method, tc.target, rec.Body.String())
+		}
+	}
+
+	// Чужую папку удалил только администратор
+	if keys := listKeys(t, "photos/42/"); len(keys) != 0 {
+		t.Errorf("admin delete must remove photos/42/, left %v", keys)
+	}
+}
+
+func TestScopeAuthorization(t *testing.T) {
+	jwtService := newJWT(t, services.JWTConfig{Secret: testJWTSecret})
+	router := authRouter(jwtService)
+	sign := func(userId int, scopes ...string) string {
+		token, err := jwtService.GenerateAccessToken(userId, scopes, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	reader := sign(51, services.ScopeRead)
+	writer := sign(51, services.ScopeWrite)
+	deleter := sign(51, services.ScopeDelete)
+	admin := sign(2, services.ScopeAdmin)
+
+	for _, tc := range []struct {
+		name  string
+		req   *http.Request
+		token string
+		want  int
+		scope string // недостающее разрешение в ответе 403
+	}{
+		{"upload without write", newUploadRequest(t, "51", testFile{name: "a.png", data: pngBytes}), reader, http.StatusForbidden, services.ScopeWrite},
+		{"upload", newUploadRequest(t, "51", testFile{name: "a.png", data: pngBytes}), writer, http.StatusOK, ""},
+		{"list own folder", httptest.NewRequest(http.MethodGet, "/files/objects?prefix=photos/51/", nil), reader, http.StatusOK, ""},
+		{"list without read", httptest.NewRequest(http.MethodGet, "/files/objects?prefix=photos/51/", nil), writer, http.StatusForbidden, services.ScopeRead},
+		{"list bucket without admin", httptest.NewRequest(http.MethodGet, "/files/objects", nil), reader, http.StatusForbidden, services.ScopeAdmin},
+		{"list bucket", httptest.NewRequest(http.MethodGet, "/files/objects", nil), admin, http.StatusOK, ""},
+		{"delete without delete", httptest.NewRequest(http.MethodDelete, "/files/upload/51", nil), writer, http.StatusForbidden, services.ScopeDelete},
+		{"delete", httptest.NewRequest(http.MethodDelete, "/files/upload/51", nil), deleter, http.StatusOK, ""},
+	} {
+		tc.req.Header.Set("Authorization", "Bearer "+tc.token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, tc.req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.want, rec.Code, rec.Body.String())
+			continue
+		}
+		if tc.scope == "" {
+			continue
+		}
+		if !strings.Contains(rec.Body.String(), `{"field":"scope","error":"`+tc.scope+`"}`) ||
+			!strings.Contains(rec.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`) {
+			t.Errorf("%s: expected missing scope %s, got %s", tc.name, tc.scope, rec.Body.String())
+		}
+	}
+}
+
+// signWithKey подписывает токен пользователя 7 с files:read асимметричным ключом kid.
+func signWithKey(t *testing.T, method jwt.SigningMethod, kid string, key crypto.Signer) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, services.Claims{
+		UserId:           7,
+		Scopes:           []string{services.ScopeRead},
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// authStatus возвращает статус запроса к папке пользователя 7 с токеном token.
+func authStatus(router *gin.Engine, token string) int {
+	req := httptest.NewRequest(http.MethodGet, "/files/objects?prefix=photos/7/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// testJWK описывает открытый ключ ECDSA P-256 или Ed25519 в формате JWK.
+func testJWK(kid string, pub crypto.PublicKey) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "crv": "P-256", "kid": kid,
+			"x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "crv": "Ed25519", "kid": kid, "x": b64(pub)}
+	}
+	panic(fmt.Sprintf("unsupported key %T", pub))
+}
+
+func jwksDocument(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestJWTPublicKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSAKey, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(cryptorand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writePEM := func(name string, pub crypto.PublicKey) string {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := dir + "/" + name
+		if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	keys, err := services.LoadPEMKeys(writePEM("rsa.pem", rsaKey.Public()) + "," +
+		writePEM("ec.pem", ecKey.Public()) + ",ed-2024=" + writePEM("ed.pem", edKey.Public()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwtService := newJWT(t, services.JWTConfig{Keys: keys})
+	router := authRouter(jwtService)
+	hs256, err := jwt.NewWithClaims(jwt.SigningMethodHS256, services.Claims{
+		UserId:           7,
+		Scopes:           []string{services.ScopeRead},
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		token string
+		want  int
+	}{
+		"RS256":                {signWithKey(t, jwt.SigningMethodRS256, "rsa", rsaKey), http.StatusOK},
+		"ES256":                {signWithKey(t, jwt.SigningMethodES256, "ec", ecKey), http.StatusOK},
+		"EdDSA":                {signWithKey(t, jwt.SigningMethodEdDSA, "ed-2024", edKey), http.StatusOK},
+		"unknown kid":          {signWithKey(t, jwt.SigningMethodRS256, "rsa-old", rsaKey), http.StatusUnauthorized},
+		"no kid":               {signWithKey(t, jwt.SigningMethodRS256, "", rsaKey), http.StatusUnauthorized},
+		"alg of another key":   {signWithKey(t, jwt.SigningMethodRS256, "ec", rsaKey), http.StatusUnauthorized},
+		"other key same kid":   {signWithKey(t, jwt.SigningMethodRS256, "rsa", otherRSAKey), http.StatusUnauthorized},
+		"HS256 without secret": {hs256, http.StatusUnauthorized},
+	} {
+		if got := authStatus(router, tc.token); got != tc.want {
+			t.Errorf("%s: expected %d, got %d", name, tc.want, got)
+		}
+	}
+
+	// Без секрета сервис только проверяет токены
+	if _, err := jwtService.GenerateAccessToken(7, nil, time.Hour); err == nil {
+		t.Error("tokens must not be issued without a secret")
+	}
+}
+
+func TestJWTJWKS(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(cryptorand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Сервис ключей: документ можно подменить (ротация)
+	var document atomic.Value
+	document.Store(jwksDocument(t, testJWK("k1", edKey.Public())))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document.Load().([]byte))
+	}))
+	defer server.Close()
+
+	keys, err := services.NewJWKSKeys(services.JWKSConfig{Source: server.URL, Refresh: time.Hour}, testContainer.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtService := newJWT(t, services.JWTConfig{Secret: testJWTSecret, Keys: keys})
+	router := authRouter(jwtService)
+
+	if got := authStatus(router, signWithKey(t, jwt.SigningMethodEdDSA, "k1", edKey)); got != http.StatusOK {
+		t.Errorf("k1: expected 200, got %d", got)
+	}
+	hs256, err := jwtService.GenerateAccessToken(7, []string{services.ScopeRead}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := authStatus(router, hs256); got != http.StatusOK {
+		t.Errorf("HS256 alongside JWKS: expected 200, got %d", got)
+	}
+
+	// Ротация: токен с новым kid принимается без перезапуска, старый ключ — нет
+	document.Store(jwksDocument(t, testJWK("k2", ecKey.Public())))
+	if got := authStatus(router, signWithKey(t, jwt.SigningMethodES256, "k2", ecKey)); got != http.StatusOK {
+		t.Errorf("k2 after rotation: expected 200, got %d", got)
+	}
+	if got := authStatus(router, signWithKey(t, jwt.SigningMethodEdDSA, "k1", edKey)); got != http.StatusUnauthorized {
+		t.Errorf("k1 after rotation: expected 401, got %d", got)
+	}
+}
+
+func TestMemoryRepositoryIsUsed(t *testing.T) {
+	if _, ok := testContainer.Storage.(*presignMemory); !ok {
+		t.Fatalf("tests must run against the in-memory backend, got %T", testContainer.Storage)
+	}
+}
+
+func contains(list []string, val string) bool {
+	for _, x := range list {
+		if x == val {
+			return true
+		}
+	}
+	return false
+}
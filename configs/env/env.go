@@ -4,6 +4,8 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 // LoadEnv загружает переменные из файла .env
@@ -22,3 +24,45 @@ func GetEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// GetEnvBool получает булеву переменную окружения ("true", "1", "false", "0" и т.п.)
+func GetEnvBool(key string, defaultValue bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean value %q for %s, using default %t", value, key, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// GetEnvInt получает целочисленную переменную окружения
+func GetEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer value %q for %s, using default %d", value, key, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// GetEnvDuration получает длительность из переменной окружения ("15m", "24h")
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration value %q for %s, using default %s", value, key, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
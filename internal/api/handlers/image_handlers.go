@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"files/internal/api/middlewares"
+	"files/internal/api/middlewares/auth"
+	"files/internal/repository"
+	"files/internal/services"
+	"files/pkg/http_error"
+	"files/pkg/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+type ImageHandlers struct {
+	ImageService *services.ImageService
+}
+
+func NewImageHandler(svc *services.ImageService) *ImageHandlers {
+	return &ImageHandlers{ImageService: svc}
+}
+
+// TransformHandler — GET /img/:id/:uuid?preset=name или ?w=&h=&fit=&q=&fmt=&sig=
+// Отдаёт уменьшенную/обрезанную копию изображения, закэшированную в хранилище.
+func (h *ImageHandlers) TransformHandler(c *gin.Context) {
+	idParam := c.Param("id")
+	uuidParam := c.Param("uuid")
+
+	transform, err := h.ImageService.ResolveTransform(idParam, uuidParam, c.Request.URL.Query())
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, services.ErrTransformForbidden) {
+			status = http.StatusForbidden
+		}
+		http_error.NewHTTPError(
+			status,
+			err.Error(),
+			[]http_error.ErrorItem{
+				{Field: "query", Error: err.Error()},
+			},
+		).Send(c)
+		return
+	}
+
+	body, info, err := h.ImageService.Transform(c.Request.Context(), idParam, uuidParam, transform)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, imaging.ErrInvalidImage), errors.Is(err, imaging.ErrImageTooLarge),
+			errors.Is(err, imaging.ErrCropTooSmall):
+			status = http.StatusUnprocessableEntity
+		}
+		http_error.NewHTTPError(status, err.Error(), nil).Send(c)
+		return
+	}
+	defer body.Close()
+
+	// Результат для одних и тех же параметров не меняется, поэтому кэшируем его надолго
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, body, map[string]string{
+		"Cache-Control": transformCacheControl(c),
+		"ETag":          info.ETag,
+	})
+}
+
+// transformCacheControl разрешает хранить результат общим кэшам (CDN, прокси) только для публичных
+// оригиналов, запрошенных без токена. Ответ на запрос с Authorization или копия приватного файла
+// кэшируется лишь браузером: иначе прокси отдаст её другому пользователю.
+func transformCacheControl(c *gin.Context) string {
+	_, authenticated := auth.GetClaims(c)
+	if authenticated || c.GetHeader("Authorization") != "" ||
+		middlewares.GetUploadPolicy(c).Visibility == repository.VisibilityPrivate {
+		return "private, max-age=31536000, immutable"
+	}
+	return "public, max-age=31536000, immutable"
+}
@@ -1,9 +1,16 @@
 package handlers
 
 import (
-	"context"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
+	"time"
 
+	"files/internal/api/middlewares"
+	"files/internal/repository"
 	"files/internal/services"
 	"files/pkg/http_error" // <-- Импортируем ваш модуль с ошибками
 	"github.com/gin-gonic/gin"
@@ -43,20 +50,93 @@ func (h *S3Handlers) UploadMultipleHandler(c *gin.Context) {
 		return
 	}
 
-	urls, err := h.S3Service.UploadMultiple(context.Background(), idParam, multipartReader)
+	policy := middlewares.GetUploadPolicy(c)
+	files, err := h.S3Service.UploadMultiple(c.Request.Context(), idParam, multipartReader, policy)
 	if err != nil {
+		uploadError(err).Send(c)
+		return
+	}
+
+	urls := make([]string, 0, len(files))
+	for _, f := range files {
+		urls = append(urls, f.URL)
+	}
+	c.JSON(http.StatusOK, gin.H{"urls": urls, "files": files})
+}
+
+// PresignUploadHandler — POST /upload/:id/presign
+// Принимает {"file_name", "content_type", "size", "method": "put"|"post"} и выдаёт ключ
+// photos/:id/uuid.ext с подписанным PUT или POST-политикой для загрузки напрямую в хранилище.
+func (h *S3Handlers) PresignUploadHandler(c *gin.Context) {
+	var req services.DirectUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		http_error.NewHTTPError(
 			http.StatusBadRequest,
-			err.Error(),
-			nil,
+			"Некорректное тело запроса",
+			[]http_error.ErrorItem{
+				{Field: "body", Error: err.Error()},
+			},
 		).Send(c)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"urls": urls})
+	upload, err := h.S3Service.PresignDirectUpload(
+		c.Request.Context(),
+		c.Param("id"),
+		req,
+		middlewares.GetUploadPolicy(c),
+		middlewares.GetPresignPolicy(c).DefaultExpiry,
+	)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotSupported) {
+			http_error.NewHTTPError(http.StatusNotImplemented, err.Error(), nil).Send(c)
+			return
+		}
+		uploadError(err).Send(c)
+		return
+	}
+	c.JSON(http.StatusOK, upload)
+}
+
+// ConfirmUploadHandler — POST /upload/:id/confirm
+// Принимает {"key"} файла, загруженного по ссылке из /presign, проверяет его и создаёт копии.
+// Ответ такой же, как у загрузки через сервис.
+func (h *S3Handlers) ConfirmUploadHandler(c *gin.Context) {
+	var req struct {
+		Key string `json:"key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		http_error.NewHTTPError(
+			http.StatusBadRequest,
+			"Некорректное тело запроса",
+			[]http_error.ErrorItem{
+				{Field: "key", Error: err.Error()},
+			},
+		).Send(c)
+		return
+	}
+
+	policy := middlewares.GetUploadPolicy(c)
+	file, err := h.S3Service.ConfirmDirectUpload(c.Request.Context(), c.Param("id"), req.Key, policy)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			http_error.NewHTTPError(
+				http.StatusNotFound,
+				err.Error(),
+				[]http_error.ErrorItem{
+					{Field: "key", Error: "not found"},
+				},
+			).Send(c)
+			return
+		}
+		uploadError(err).Send(c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"urls": []string{file.URL}, "files": []services.UploadedFile{file}})
 }
 
 // DeleteAllByIDHandler — DELETE /upload/:id
+// Возвращает списки удалённых ключей (deleted) и неудалённых с причиной (failed).
 func (h *S3Handlers) DeleteAllByIDHandler(c *gin.Context) {
 	idParam := c.Param("id")
 	if idParam == "" {
@@ -70,20 +150,34 @@ func (h *S3Handlers) DeleteAllByIDHandler(c *gin.Context) {
 		return
 	}
 
-	err := h.S3Service.DeleteAllByID(context.Background(), idParam)
+	result, err := h.S3Service.DeleteAllByID(c.Request.Context(), idParam)
 	if err != nil {
-		http_error.NewHTTPError(
-			http.StatusNotFound,
-			err.Error(),
-			nil,
-		).Send(c)
+		status := http.StatusInternalServerError
+		if errors.Is(err, repository.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http_error.NewHTTPError(status, err.Error(), nil).Send(c)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Все файлы удалены"})
+	// Часть файлов не удалилась — 207 со списками удалённых и неудалённых ключей
+	if len(result.Failed) > 0 {
+		c.JSON(http.StatusMultiStatus, gin.H{
+			"message": "Удалены не все файлы",
+			"deleted": result.Deleted,
+			"failed":  result.Failed,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Все файлы удалены",
+		"deleted": result.Deleted,
+		"failed":  result.Failed,
+	})
 }
 
 // DeleteOneByUUIDHandler — DELETE /upload/:id/:uuid
+// Возвращает удалённые ключи (keys) и неудалённые с причиной (failed).
 func (h *S3Handlers) DeleteOneByUUIDHandler(c *gin.Context) {
 	idParam := c.Param("id")
 	if idParam == "" {
@@ -109,43 +203,202 @@ func (h *S3Handlers) DeleteOneByUUIDHandler(c *gin.Context) {
 		return
 	}
 
-	keys, err := h.S3Service.DeleteOneByUUID(context.Background(), idParam, uuidParam)
+	result, err := h.S3Service.DeleteOneByUUID(c.Request.Context(), idParam, uuidParam)
 	if err != nil {
-		http_error.NewHTTPError(
-			http.StatusNotFound,
-			err.Error(),
-			nil,
-		).Send(c)
+		status := http.StatusInternalServerError
+		if errors.Is(err, repository.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http_error.NewHTTPError(status, err.Error(), nil).Send(c)
 		return
 	}
 
+	// Часть копий не удалилась — 207, как при удалении папки
+	if len(result.Failed) > 0 {
+		c.JSON(http.StatusMultiStatus, gin.H{
+			"message": "Удалены не все файлы по UUID",
+			"keys":    result.Deleted,
+			"failed":  result.Failed,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Удалён файл(ы) по UUID",
-		"keys":    keys,
+		"keys":    result.Deleted,
+		"failed":  result.Failed,
 	})
 }
 
-// ListAllFilesHandler — GET /files
-// Возвращает список URL всех файлов из S3 бакета.
+// DownloadHandler — GET и HEAD /:id/:uuid и /download/:id/:uuid
+// Отдаёт оригинал из хранилища. Range (в том числе несколько диапазонов), If-None-Match,
+// If-Modified-Since и If-Range обрабатывает http.ServeContent.
+func (h *S3Handlers) DownloadHandler(c *gin.Context) {
+	file, err := h.S3Service.OpenFile(c.Request.Context(), c.Param("id"), c.Param("uuid"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, repository.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http_error.NewHTTPError(status, err.Error(), nil).Send(c)
+		return
+	}
+	defer file.Close()
+
+	// Content-Type и ETag выставляем заранее: ServeContent не будет угадывать тип по содержимому
+	// и сверит ETag с If-None-Match / If-Range.
+	c.Header("Content-Type", file.Info.ContentType)
+	if file.Info.ETag != "" {
+		c.Header("ETag", file.Info.ETag)
+	}
+	http.ServeContent(c.Writer, c.Request, path.Base(file.Info.Key), file.Info.LastModified, file)
+}
+
+// PresignHandler — GET /presign/:id/:uuid?variant=&expires_in=&disposition=&filename=
+// Выдаёт временную ссылку на файл (в том числе приватный). expires_in — срок действия в секундах,
+// disposition (inline или attachment) и filename переопределяют Content-Disposition ответа.
+func (h *S3Handlers) PresignHandler(c *gin.Context) {
+	policy := middlewares.GetPresignPolicy(c)
+	opts := repository.PresignOptions{Expires: policy.DefaultExpiry}
+
+	if value := c.Query("expires_in"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		expires := time.Duration(seconds) * time.Second
+		if err != nil || seconds <= 0 || (policy.MaxExpiry > 0 && expires > policy.MaxExpiry) {
+			http_error.NewHTTPError(
+				http.StatusBadRequest,
+				"Некорректный срок действия ссылки",
+				[]http_error.ErrorItem{
+					{Field: "expires_in", Error: fmt.Sprintf("ожидается от 1 до %d секунд", int(policy.MaxExpiry.Seconds()))},
+				},
+			).Send(c)
+			return
+		}
+		opts.Expires = expires
+	}
+
+	disposition, filename := c.Query("disposition"), c.Query("filename")
+	if disposition != "" || filename != "" {
+		if disposition == "" {
+			disposition = "attachment"
+		}
+		params := map[string]string{}
+		if filename != "" {
+			params["filename"] = filename
+		}
+		if disposition == "inline" || disposition == "attachment" {
+			opts.ContentDisposition = mime.FormatMediaType(disposition, params)
+		}
+		if opts.ContentDisposition == "" {
+			http_error.NewHTTPError(
+				http.StatusBadRequest,
+				"Некорректный Content-Disposition",
+				[]http_error.ErrorItem{
+					{Field: "disposition", Error: "ожидается inline или attachment с корректным filename"},
+				},
+			).Send(c)
+			return
+		}
+	}
+
+	presigned, err := h.S3Service.PresignDownload(c.Request.Context(), c.Param("id"), c.Param("uuid"), c.Query("variant"), opts)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, repository.ErrNotSupported):
+			status = http.StatusNotImplemented
+		}
+		http_error.NewHTTPError(status, err.Error(), nil).Send(c)
+		return
+	}
+	c.JSON(http.StatusOK, presigned)
+}
+
+// ListAllFilesHandler — GET /objects
+// Постранично возвращает файлы хранилища: ключ, :id, :uuid, URL, размер, тип, дату изменения и ETag.
+// Параметры: limit (до 1000, по умолчанию 100), cursor (next_cursor предыдущей страницы), prefix,
+// ext (png,jpg), modified_since (RFC 3339), min_size, max_size, sort (key, size, last_modified),
+// order (asc, desc) и count=true для подсчёта total и total_pages. format=urls — прежний массив URL.
 func (h *S3Handlers) ListAllFilesHandler(c *gin.Context) {
-	files, err := h.S3Service.ListAllFiles(context.Background())
+	var query services.ListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		http_error.NewHTTPError(
+			http.StatusBadRequest,
+			"Некорректные параметры списка",
+			[]http_error.ErrorItem{
+				{Field: "query", Error: err.Error()},
+			},
+		).Send(c)
+		return
+	}
+
+	page, err := h.S3Service.ListFiles(c.Request.Context(), query)
 	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidCursor) || errors.Is(err, services.ErrInvalidListQuery) {
+			status = http.StatusBadRequest
+		}
+		http_error.NewHTTPError(status, err.Error(), nil).Send(c)
+		return
+	}
+
+	response := gin.H{"files": listResponse(c, page.Files)}
+	if page.NextCursor != "" {
+		response["next_cursor"] = page.NextCursor
+	}
+	if page.Total != nil {
+		response["total"] = *page.Total
+		response["total_pages"] = *page.TotalPages
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// BrowseHandler — GET /objects/browse?prefix=photos/&limit=&cursor=
+// Возвращает подпапки первого уровня с числом файлов и байтами в каждой и файлы самой папки.
+// Подпапки и файлы делят одну страницу размером limit; следующая — по next_cursor.
+func (h *S3Handlers) BrowseHandler(c *gin.Context) {
+	var query services.BrowseQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
 		http_error.NewHTTPError(
-			http.StatusInternalServerError,
-			err.Error(),
-			nil,
+			http.StatusBadRequest,
+			"Некорректные параметры запроса",
+			[]http_error.ErrorItem{
+				{Field: "query", Error: err.Error()},
+			},
 		).Send(c)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"files": files})
+
+	listing, err := h.S3Service.Browse(c.Request.Context(), query)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidCursor) {
+			status = http.StatusBadRequest
+		}
+		http_error.NewHTTPError(status, err.Error(), nil).Send(c)
+		return
+	}
+	c.JSON(http.StatusOK, listing)
 }
 
-// FolderExistsHandler — GET /folder_exists?folder=<folderName>
-// Возвращает информацию о папке: существует ли она и список файлов по заданному пути.
+// FolderExistsHandler — GET /objects/exists?folder=<folderName>&limit=&cursor=&format=objects|urls
+// Возвращает информацию о папке: существует ли она и страницу файлов по заданному пути
+// (в том же формате, что и ListAllFilesHandler; следующая страница — по next_cursor).
 // Если файлов нет, возвращается ошибка 404.
 func (h *S3Handlers) FolderExistsHandler(c *gin.Context) {
-	folderName := c.Query("folder")
-	if folderName == "" {
+	var query services.FolderInfoQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		http_error.NewHTTPError(
+			http.StatusBadRequest,
+			"Некорректные параметры запроса",
+			[]http_error.ErrorItem{
+				{Field: "query", Error: err.Error()},
+			},
+		).Send(c)
+		return
+	}
+	if query.Folder == "" {
 		http_error.NewHTTPError(
 			http.StatusBadRequest,
 			"Не указан параметр folder",
@@ -156,17 +409,17 @@ func (h *S3Handlers) FolderExistsHandler(c *gin.Context) {
 		return
 	}
 
-	exists, files, err := h.S3Service.GetFolderInfo(context.Background(), folderName)
+	info, err := h.S3Service.GetFolderInfo(c.Request.Context(), query)
 	if err != nil {
-		http_error.NewHTTPError(
-			http.StatusInternalServerError,
-			err.Error(),
-			nil,
-		).Send(c)
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidCursor) {
+			status = http.StatusBadRequest
+		}
+		http_error.NewHTTPError(status, err.Error(), nil).Send(c)
 		return
 	}
 
-	if !exists {
+	if !info.Exists {
 		http_error.NewHTTPError(
 			http.StatusNotFound,
 			"Папка не найдена",
@@ -177,9 +430,66 @@ func (h *S3Handlers) FolderExistsHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"folder": folderName,
-		"exists": exists,
-		"files":  files,
-	})
+	response := gin.H{
+		"folder": info.Folder,
+		"exists": info.Exists,
+		"files":  listResponse(c, info.Files),
+	}
+	if info.NextCursor != "" {
+		response["next_cursor"] = info.NextCursor
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// listResponse приводит список файлов к формату, выбранному ListFormatMiddleware.
+func listResponse(c *gin.Context, files []services.FileObject) any {
+	if middlewares.GetListFormat(c) == middlewares.ListFormatURLs {
+		return services.URLs(files)
+	}
+	return files
+}
+
+// uploadError переводит ошибку загрузки в HTTP-ответ. 4xx получают только ошибки клиента:
+// отклонённый файл, превышение размера и некорректный multipart. Сбои хранилища, отката
+// и отменённый запрос — 500, чтобы мониторинг видел их как ошибки сервиса.
+func uploadError(err error) *http_error.HTTPError {
+	var rejected *services.FileRejectedError
+	if errors.As(err, &rejected) {
+		return http_error.NewHTTPError(
+			http.StatusBadRequest,
+			rejected.Reason,
+			[]http_error.ErrorItem{
+				{Field: "file", Error: rejected.Reason, File: rejected.FileName},
+			},
+		)
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http_error.NewHTTPError(
+			http.StatusRequestEntityTooLarge,
+			"Превышен допустимый размер запроса",
+			[]http_error.ErrorItem{
+				{Field: "body", Error: err.Error()},
+			},
+		)
+	}
+	if errors.Is(err, services.ErrUploadTooLarge) {
+		return http_error.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error(), nil)
+	}
+	if errors.Is(err, services.ErrMalformedUpload) {
+		return http_error.NewHTTPError(
+			http.StatusBadRequest,
+			err.Error(),
+			[]http_error.ErrorItem{
+				{Field: "multipart_data", Error: err.Error()},
+			},
+		)
+	}
+
+	return http_error.NewHTTPError(
+		http.StatusInternalServerError,
+		err.Error(),
+		nil,
+	)
 }
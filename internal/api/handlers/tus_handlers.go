@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"files/internal/api/middlewares"
+	"files/internal/repository"
+	"files/internal/services"
+	"files/pkg/http_error"
+	"github.com/gin-gonic/gin"
+)
+
+// tusContentType — обязательный Content-Type тела PATCH.
+const tusContentType = "application/offset+octet-stream"
+
+type TusHandlers struct {
+	TusService *services.TusService
+}
+
+func NewTusHandler(svc *services.TusService) *TusHandlers {
+	return &TusHandlers{TusService: svc}
+}
+
+// OptionsHandler — OPTIONS /tus и /tus/:id
+// Сообщает версию протокола, расширения и наибольший размер файла.
+func (h *TusHandlers) OptionsHandler(c *gin.Context) {
+	c.Header("Tus-Resumable", services.TusVersion)
+	c.Header("Tus-Version", services.TusVersion)
+	c.Header("Tus-Extension", services.TusExtensions)
+	if maxSize := middlewares.GetUploadPolicy(c).MaxFileSize; maxSize > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// CreateHandler — POST /tus/:id
+// Создаёт загрузку размером Upload-Length; имя и тип файла берутся из Upload-Metadata
+// (filename, filetype). Адрес загрузки возвращается в Location.
+func (h *TusHandlers) CreateHandler(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http_error.NewHTTPError(
+			http.StatusBadRequest,
+			"Некорректный Upload-Length",
+			[]http_error.ErrorItem{
+				{Field: "Upload-Length", Error: "ожидается неотрицательное целое число"},
+			},
+		).Send(c)
+		return
+	}
+
+	upload, err := h.TusService.Create(
+		c.Request.Context(),
+		c.Param("id"),
+		length,
+		c.GetHeader("Upload-Metadata"),
+		middlewares.GetUploadPolicy(c),
+	)
+	if err != nil {
+		resumableError(err).Send(c)
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+upload.ID)
+	setUploadExpires(c, upload)
+	c.Status(http.StatusCreated)
+}
+
+// HeadHandler — HEAD /tus/:id/:upload
+// Возвращает текущее смещение загрузки, с которого клиент продолжает отправку.
+func (h *TusHandlers) HeadHandler(c *gin.Context) {
+	upload, err := h.TusService.Get(c.Request.Context(), c.Param("id"), c.Param("upload"))
+	if err != nil {
+		c.Status(resumableError(err).StatusCode)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	setUploadExpires(c, upload)
+	c.Status(http.StatusOK)
+}
+
+// PatchHandler — PATCH /tus/:id/:upload
+// Дописывает тело запроса с позиции Upload-Offset. После последнего байта файл проверяется
+// и обрабатывается так же, как при обычной загрузке; результат отдаёт GetHandler.
+func (h *TusHandlers) PatchHandler(c *gin.Context) {
+	if c.ContentType() != tusContentType {
+		http_error.NewHTTPError(
+			http.StatusUnsupportedMediaType,
+			"Некорректный Content-Type",
+			[]http_error.ErrorItem{
+				{Field: "Content-Type", Error: "ожидается " + tusContentType},
+			},
+		).Send(c)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http_error.NewHTTPError(
+			http.StatusBadRequest,
+			"Некорректный Upload-Offset",
+			[]http_error.ErrorItem{
+				{Field: "Upload-Offset", Error: "ожидается неотрицательное целое число"},
+			},
+		).Send(c)
+		return
+	}
+
+	upload, err := h.TusService.Write(
+		c.Request.Context(),
+		c.Param("id"),
+		c.Param("upload"),
+		offset,
+		c.Request.Body,
+		c.Request.ContentLength,
+		middlewares.GetUploadPolicy(c),
+	)
+	if upload != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+	if err != nil {
+		resumableError(err).Send(c)
+		return
+	}
+	setUploadExpires(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+// GetHandler — GET /tus/:id/:upload
+// Для завершённой загрузки возвращает файл в том же виде, что и POST /upload/:id.
+func (h *TusHandlers) GetHandler(c *gin.Context) {
+	upload, err := h.TusService.Get(c.Request.Context(), c.Param("id"), c.Param("upload"))
+	if err != nil {
+		resumableError(err).Send(c)
+		return
+	}
+	if upload.Result == nil {
+		http_error.NewHTTPError(
+			http.StatusConflict,
+			"Загрузка ещё не завершена",
+			[]http_error.ErrorItem{
+				{Field: "Upload-Offset", Error: strconv.FormatInt(upload.Offset, 10)},
+			},
+		).Send(c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"urls": []string{upload.Result.URL}, "files": []services.UploadedFile{*upload.Result}})
+}
+
+// DeleteHandler — DELETE /tus/:id/:upload
+// Расширение termination: отменяет загрузку и удаляет принятые данные.
+func (h *TusHandlers) DeleteHandler(c *gin.Context) {
+	if err := h.TusService.Terminate(c.Request.Context(), c.Param("id"), c.Param("upload")); err != nil {
+		resumableError(err).Send(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// setUploadExpires добавляет заголовок Upload-Expires (расширение expiration) для незавершённой загрузки.
+func setUploadExpires(c *gin.Context, upload *services.TusUpload) {
+	if upload.Result == nil {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// resumableError переводит ошибку возобновляемой загрузки (tus, сессии) в HTTP-ответ.
+func resumableError(err error) *http_error.HTTPError {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, services.ErrUploadExpired):
+		status = http.StatusGone
+	case errors.Is(err, services.ErrOffsetMismatch),
+		errors.Is(err, services.ErrChunksMissing),
+		errors.Is(err, services.ErrUploadCompleted):
+		status = http.StatusConflict
+	case errors.Is(err, services.ErrUploadLocked):
+		status = http.StatusLocked
+	case errors.Is(err, services.ErrUploadTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrChecksumMismatch):
+		status = http.StatusBadRequest
+	default:
+		var rejected *services.FileRejectedError
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &rejected) || errors.As(err, &maxBytesErr) {
+			return uploadError(err)
+		}
+	}
+	return http_error.NewHTTPError(status, err.Error(), nil)
+}
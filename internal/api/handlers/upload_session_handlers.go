@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"files/internal/api/middlewares"
+	"files/internal/services"
+	"files/pkg/http_error"
+	"github.com/gin-gonic/gin"
+)
+
+// chunkChecksumHeader — заголовок с SHA-256 куска в hex.
+const chunkChecksumHeader = "X-Checksum-SHA256"
+
+type UploadSessionHandlers struct {
+	SessionService *services.UploadSessionService
+}
+
+func NewUploadSessionHandler(svc *services.UploadSessionService) *UploadSessionHandlers {
+	return &UploadSessionHandlers{SessionService: svc}
+}
+
+// CreateHandler — POST /upload/:id/sessions
+// Принимает {"file_name", "content_type", "size", "chunk_size"} и открывает сессию загрузки кусками.
+func (h *UploadSessionHandlers) CreateHandler(c *gin.Context) {
+	var req services.UploadSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		http_error.NewHTTPError(
+			http.StatusBadRequest,
+			"Некорректное тело запроса",
+			[]http_error.ErrorItem{
+				{Field: "body", Error: err.Error()},
+			},
+		).Send(c)
+		return
+	}
+
+	session, err := h.SessionService.Create(c.Request.Context(), c.Param("id"), req, middlewares.GetUploadPolicy(c))
+	if err != nil {
+		resumableError(err).Send(c)
+		return
+	}
+	c.JSON(http.StatusCreated, session)
+}
+
+// GetHandler — GET /upload/:id/sessions/:session
+// Возвращает сессию со списками принятых (received) и недостающих (missing) кусков.
+func (h *UploadSessionHandlers) GetHandler(c *gin.Context) {
+	session, err := h.SessionService.Get(c.Request.Context(), c.Param("id"), c.Param("session"))
+	if err != nil {
+		resumableError(err).Send(c)
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// PutChunkHandler — PUT /upload/:id/sessions/:session/chunks/:number
+// Тело — содержимое куска, заголовок X-Checksum-SHA256 — его SHA-256 в hex.
+func (h *UploadSessionHandlers) PutChunkHandler(c *gin.Context) {
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil {
+		http_error.NewHTTPError(
+			http.StatusBadRequest,
+			"Некорректный номер куска",
+			[]http_error.ErrorItem{
+				{Field: "number", Error: "ожидается целое число"},
+			},
+		).Send(c)
+		return
+	}
+	checksum := c.GetHeader(chunkChecksumHeader)
+	if checksum == "" {
+		http_error.NewHTTPError(
+			http.StatusBadRequest,
+			"Не указана контрольная сумма куска",
+			[]http_error.ErrorItem{
+				{Field: chunkChecksumHeader, Error: "missing"},
+			},
+		).Send(c)
+		return
+	}
+
+	receipt, err := h.SessionService.PutChunk(
+		c.Request.Context(),
+		c.Param("id"),
+		c.Param("session"),
+		number,
+		c.Request.Body,
+		checksum,
+	)
+	if err != nil {
+		resumableError(err).Send(c)
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// CompleteHandler — POST /upload/:id/sessions/:session/complete
+// Собирает файл из кусков; ответ такой же, как у загрузки через сервис.
+func (h *UploadSessionHandlers) CompleteHandler(c *gin.Context) {
+	file, err := h.SessionService.Complete(
+		c.Request.Context(),
+		c.Param("id"),
+		c.Param("session"),
+		middlewares.GetUploadPolicy(c),
+	)
+	if err != nil {
+		resumableError(err).Send(c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"urls": []string{file.URL}, "files": []services.UploadedFile{file}})
+}
+
+// AbortHandler — DELETE /upload/:id/sessions/:session
+// Отменяет сессию и удаляет принятые куски.
+func (h *UploadSessionHandlers) AbortHandler(c *gin.Context) {
+	if err := h.SessionService.Abort(c.Request.Context(), c.Param("id"), c.Param("session")); err != nil {
+		resumableError(err).Send(c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Сессия загрузки отменена"})
+}
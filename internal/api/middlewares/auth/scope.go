@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"files/internal/services"
+	"files/pkg/http_error"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// RequireScope пропускает запрос, только если в токене есть разрешение scope (или files:admin).
+// Отказ — 403 с названием недостающего разрешения и запись в журнал аудита.
+// Без claims в контексте (AUTH_ENABLED=false) проверка не выполняется.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaims(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		requireScope(c, claims, scope)
+	}
+}
+
+// requireScope продолжает цепочку, если у claims есть разрешение scope, иначе отвечает 403
+// с названием разрешения и прерывает цепочку.
+func requireScope(c *gin.Context, claims *services.Claims, scope string) {
+	if claims.HasScope(scope) {
+		c.Next()
+		return
+	}
+
+	auditDenied(c, claims, "missing scope", zap.String("scope", scope), zap.Strings("scopes", claims.Scopes))
+	// RFC 6750: недостаточно прав у действительного токена
+	c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="files", error="insufficient_scope", scope=%q`, scope))
+	http_error.NewHTTPError(
+		http.StatusForbidden,
+		"Недостаточно прав: требуется разрешение "+scope,
+		[]http_error.ErrorItem{
+			{Field: "scope", Error: scope},
+		},
+	).Send(c)
+	c.Abort()
+}
@@ -1,42 +1,43 @@
 package auth
 
 import (
+	"errors"
+	"net/http"
+	"strings"
+
 	"files/internal/services"
 	"files/pkg/http_error"
 	"github.com/gin-gonic/gin"
-	"net/http"
-	"strings"
+	"github.com/golang-jwt/jwt/v5"
 )
 
-// JwtAuthMiddleware проверяет токен и добавляет userId в контекст Gin.
+// JwtAuthMiddleware проверяет токен и добавляет userId и claims в контекст Gin.
+// Все отказы — 401 в формате http_error с заголовком WWW-Authenticate.
 func JwtAuthMiddleware(jwtService services.JWTServiceInterface) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Получаем заголовок Authorization
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			httpErr := http_error.NewHTTPError(http.StatusUnauthorized, "Authorization header is missing", nil)
-			c.JSON(httpErr.StatusCode, httpErr)
-			c.Abort()
+			unauthorized(c, "Не передан заголовок Authorization", "missing")
 			return
 		}
 
-		// Проверяем, что заголовок начинается с "Bearer "
-		if !strings.HasPrefix(authHeader, "Bearer ") {
-			httpErr := http_error.NewHTTPError(http.StatusUnauthorized, "Invalid authorization format", nil)
-			c.JSON(httpErr.StatusCode, httpErr)
-			c.Abort()
+		// Проверяем схему Bearer (регистр схемы не важен, RFC 7235)
+		scheme, tokenStr, ok := strings.Cut(authHeader, " ")
+		tokenStr = strings.TrimSpace(tokenStr)
+		if !ok || !strings.EqualFold(scheme, "Bearer") || tokenStr == "" {
+			unauthorized(c, "Ожидается заголовок Authorization: Bearer <token>", "invalid format")
 			return
 		}
 
-		// Извлекаем сам токен
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-
 		// Валидируем токен
 		token, err := jwtService.ValidateToken(tokenStr)
 		if err != nil || !token.Valid {
-			httpErr := http_error.NewHTTPError(http.StatusUnauthorized, "Invalid or expired token", nil)
-			c.JSON(httpErr.StatusCode, httpErr)
-			c.Abort()
+			reason := "invalid"
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				reason = "expired"
+			}
+			unauthorized(c, "Недействительный или просроченный токен", reason)
 			return
 		}
 
@@ -44,20 +45,32 @@ func JwtAuthMiddleware(jwtService services.JWTServiceInterface) gin.HandlerFunc
 		claims, ok := token.Claims.(*services.Claims)
 		if !ok || claims.UserId <= 0 {
 			// Здесь вы сами решаете, что считать "валидным" идентификатором
-			httpErr := http_error.NewHTTPError(http.StatusUnauthorized, "Invalid token claims", nil)
-			c.JSON(httpErr.StatusCode, httpErr)
-			c.Abort()
+			unauthorized(c, "Некорректные данные токена", "invalid claims")
 			return
 		}
 
-		// Сохраняем userId (число) в контекст Gin
+		// Сохраняем userId (число) и claims (роли, папки) в контекст Gin
 		c.Set("userId", claims.UserId)
+		c.Set("claims", claims)
 
 		// Двигаемся дальше
 		c.Next()
 	}
 }
 
+// unauthorized отвечает 401 и прерывает цепочку обработчиков.
+func unauthorized(c *gin.Context, message, reason string) {
+	c.Header("WWW-Authenticate", `Bearer realm="files"`)
+	http_error.NewHTTPError(
+		http.StatusUnauthorized,
+		message,
+		[]http_error.ErrorItem{
+			{Field: "Authorization", Error: reason},
+		},
+	).Send(c)
+	c.Abort()
+}
+
 func GetUserId(c *gin.Context) (int, bool) {
 	userID, exists := c.Get("userId")
 	if !exists {
@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"files/internal/services"
+	"files/pkg/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+// ValidateImagesMiddleware включает для маршрута загрузки декодирование каждого файла как изображения
+// с ограничениями на ширину, высоту и общее число пикселей.
+// Проверка выполняется в S3Service.UploadMultiple во время записи файла в хранилище.
+func ValidateImagesMiddleware(limits imaging.Limits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uploadPolicy(c).ImageLimits = &limits
+		c.Next()
+	}
+}
+
+// ImageVariantsMiddleware задаёт уменьшенные копии, которые создаются для каждого загруженного
+// изображения и сохраняются рядом с оригиналом (photos/:id/uuid_thumb.jpg и т.п.).
+func ImageVariantsMiddleware(variants []imaging.Variant) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uploadPolicy(c).Variants = variants
+		c.Next()
+	}
+}
+
+// ConvertToWebPMiddleware включает для маршрута конвертацию JPEG и PNG в WebP.
+// nil отключает конвертацию.
+func ConvertToWebPMiddleware(conversion *services.WebPConversion) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uploadPolicy(c).WebP = conversion
+		c.Next()
+	}
+}
+
+// StripMetadataMiddleware включает для маршрута удаление EXIF, XMP и IPTC из JPEG, PNG и WebP
+// с поворотом пикселей по EXIF Orientation. nil отключает очистку.
+func StripMetadataMiddleware(privacy *services.PrivacyMode) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uploadPolicy(c).Privacy = privacy
+		c.Next()
+	}
+}
@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"files/pkg/http_error"
+	"github.com/gin-gonic/gin"
+)
+
+// Форматы ответа списков файлов: объекты с метаданными или, для старых клиентов, массив URL.
+const (
+	ListFormatObjects = "objects"
+	ListFormatURLs    = "urls"
+)
+
+// listFormatKey — ключ, под которым формат ответа списка хранится в gin.Context.
+const listFormatKey = "listFormat"
+
+// ListFormatMiddleware выбирает формат ответа списков файлов: параметр ?format= запроса,
+// а без него — defaultFormat маршрута.
+func ListFormatMiddleware(defaultFormat string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", defaultFormat)
+		if format != ListFormatObjects && format != ListFormatURLs {
+			http_error.NewHTTPError(
+				http.StatusBadRequest,
+				"Некорректный формат списка",
+				[]http_error.ErrorItem{
+					{Field: "format", Error: "ожидается " + ListFormatObjects + " или " + ListFormatURLs},
+				},
+			).Send(c)
+			c.Abort()
+			return
+		}
+		c.Set(listFormatKey, format)
+		c.Next()
+	}
+}
+
+// GetListFormat возвращает формат, выбранный middleware маршрута (по умолчанию — объекты).
+func GetListFormat(c *gin.Context) string {
+	if format := c.GetString(listFormatKey); format != "" {
+		return format
+	}
+	return ListFormatObjects
+}
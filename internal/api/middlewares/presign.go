@@ -0,0 +1,27 @@
+package middlewares
+
+import (
+	"files/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// presignPolicyKey — ключ, под которым ограничения подписанных ссылок хранятся в gin.Context.
+const presignPolicyKey = "presignPolicy"
+
+// PresignPolicyMiddleware задаёт для маршрута срок действия выдаваемых ссылок по умолчанию и наибольший.
+func PresignPolicyMiddleware(policy services.PresignPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(presignPolicyKey, policy)
+		c.Next()
+	}
+}
+
+// GetPresignPolicy возвращает ограничения, заданные middleware маршрута.
+func GetPresignPolicy(c *gin.Context) services.PresignPolicy {
+	if value, exists := c.Get(presignPolicyKey); exists {
+		if policy, ok := value.(services.PresignPolicy); ok {
+			return policy
+		}
+	}
+	return services.PresignPolicy{}
+}
@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"net/http"
+	"slices"
+
+	"files/pkg/http_error"
+	"github.com/gin-gonic/gin"
+)
+
+// ReservedIDsMiddleware отклоняет загрузку в папку photos/:id, если :id совпадает с первым сегментом
+// статического маршрута (objects, presign, ...): такие пути GET /files/:id/:uuid не достанутся.
+func ReservedIDsMiddleware(reserved []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id := c.Param("id"); slices.Contains(reserved, id) {
+			http_error.NewHTTPError(
+				http.StatusBadRequest,
+				"Зарезервированный :id папки",
+				[]http_error.ErrorItem{
+					{Field: "id", Error: "имя " + id + " занято маршрутом /files/" + id},
+				},
+			).Send(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -14,3 +14,13 @@ func LimitRequestSizeMiddleware(maxSize int64) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// MaxFileSizeMiddleware ограничивает размер файла, который клиент загружает напрямую в хранилище
+// по подписанной ссылке. Ограничение входит в подпись (Content-Length или content-length-range)
+// и повторно проверяется при подтверждении загрузки.
+func MaxFileSizeMiddleware(maxSize int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uploadPolicy(c).MaxFileSize = maxSize
+		c.Next()
+	}
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"files/pkg/http_error"
+	"github.com/gin-gonic/gin"
+)
+
+// TusResumableMiddleware проверяет, что клиент говорит на поддерживаемой версии протокола tus
+// (заголовок Tus-Resumable), и добавляет этот заголовок в ответ. OPTIONS по протоколу
+// отправляется без версии и пропускается.
+func TusResumableMiddleware(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		c.Header("Tus-Resumable", version)
+		if c.GetHeader("Tus-Resumable") != version {
+			c.Header("Tus-Version", version)
+			http_error.NewHTTPError(
+				http.StatusPreconditionFailed,
+				"Неподдерживаемая версия протокола tus",
+				[]http_error.ErrorItem{
+					{Field: "Tus-Resumable", Error: "ожидается " + version},
+				},
+			).Send(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
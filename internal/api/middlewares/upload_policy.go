@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"files/internal/repository"
+	"files/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// uploadPolicyKey — ключ, под которым политика загрузки маршрута хранится в gin.Context.
+const uploadPolicyKey = "uploadPolicy"
+
+// uploadPolicy возвращает политику загрузки из контекста, создавая её при первом обращении.
+// Middleware маршрута дополняют одну и ту же политику.
+func uploadPolicy(c *gin.Context) *services.UploadPolicy {
+	if value, exists := c.Get(uploadPolicyKey); exists {
+		if policy, ok := value.(*services.UploadPolicy); ok {
+			return policy
+		}
+	}
+	policy := &services.UploadPolicy{}
+	c.Set(uploadPolicyKey, policy)
+	return policy
+}
+
+// GetUploadPolicy возвращает политику загрузки, заданную middleware маршрута.
+func GetUploadPolicy(c *gin.Context) services.UploadPolicy {
+	return *uploadPolicy(c)
+}
+
+// VisibilityMiddleware задаёт видимость файлов, сохраняемых маршрутом: public (ACL public-read)
+// или private (только по подписанной ссылке).
+func VisibilityMiddleware(visibility repository.Visibility) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uploadPolicy(c).Visibility = visibility
+		c.Next()
+	}
+}
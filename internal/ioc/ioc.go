@@ -5,16 +5,33 @@ import (
 	"files/internal/api/handlers"
 	"files/internal/repository"
 	"files/internal/services"
+	"files/pkg/imaging"
 	"files/pkg/log"
 	"go.uber.org/zap"
+	"time"
 )
 
 type Container struct {
 	Logger     *zap.Logger
-	S3Repo     *repository.S3Repository
+	Storage    repository.Storage
 	S3Service  *services.S3Service
 	JwtService services.JWTServiceInterface
 	S3Handler  *handlers.S3Handlers
+
+	// AuthEnabled включает проверку JWT на маршрутах /files (AUTH_ENABLED, по умолчанию включена).
+	AuthEnabled bool
+
+	// ImageLimits — допустимые размеры изображений: общие для загрузок и трансформаций.
+	ImageLimits imaging.Limits
+
+	ImageService *services.ImageService
+	ImageHandler *handlers.ImageHandlers
+
+	TusService *services.TusService
+	TusHandler *handlers.TusHandlers
+
+	UploadSessionService *services.UploadSessionService
+	UploadSessionHandler *handlers.UploadSessionHandlers
 }
 
 // NewContainer - создаем контейнер с зависимостями.
@@ -26,26 +43,179 @@ func NewContainer() *Container {
 	// Get global logger
 	logger := log.GetLogger()
 
-	bucketName := env.GetEnv("BUCKET_NAME", "")
-	S3AccessKey := env.GetEnv("S3_ACCESS_KEY", "")
-	S3SecretAccessKey := env.GetEnv("S3_SECRET_ACCESS_KEY", "")
-	if bucketName == "" || S3AccessKey == "" || S3SecretAccessKey == "" {
-		log.Fatal("S3 credentials or bucket name are not provided")
-	}
-
 	// Create repositories
-	s3Repo := repository.NewS3Repository(bucketName, S3AccessKey, S3SecretAccessKey)
+	return NewContainerWithStorage(logger, newStorage(env.GetEnv("STORAGE_BACKEND", "s3")))
+}
+
+// NewContainerWithStorage создаёт контейнер поверх готового хранилища; окружение и логгер
+// должны быть уже инициализированы. Тесты передают сюда repository.MemoryRepository.
+func NewContainerWithStorage(logger *zap.Logger, storage repository.Storage) *Container {
 	// Create services
-	s3Service := services.NewS3Service(s3Repo)
-	jwtService := services.NewJWTService(env.GetEnv("JWT_KEY", ""), logger)
+	s3Service := services.NewS3Service(storage)
+	authEnabled := env.GetEnvBool("AUTH_ENABLED", true)
+	// Без авторизации приватные файлы отдаются любому по /files/:id/:uuid, /download и /presign,
+	// а /objects показывает незавершённые загрузки — приватный режим теряет смысл
+	if !authEnabled && env.GetEnv("UPLOAD_VISIBILITY", "") == string(repository.VisibilityPrivate) {
+		log.Fatal("UPLOAD_VISIBILITY=private requires authentication: remove AUTH_ENABLED=false")
+	}
+	jwtService := newJWTService(authEnabled, logger)
+	imageLimits := newImageLimits()
+	imageService := newImageService(storage, imageLimits)
+	tusService := services.NewTusService(
+		storage,
+		s3Service,
+		env.GetEnvDuration("TUS_EXPIRATION", 24*time.Hour),
+		env.GetEnvDuration("TUS_CLEANUP_INTERVAL", time.Hour),
+	)
+	sessionService := services.NewUploadSessionService(
+		storage,
+		s3Service,
+		env.GetEnvDuration("UPLOAD_SESSION_EXPIRATION", 24*time.Hour),
+		env.GetEnvDuration("UPLOAD_SESSION_CLEANUP_INTERVAL", time.Hour),
+	)
 
 	// Create handlers
 	s3Handler := handlers.NewS3Handler(s3Service)
+	imageHandler := handlers.NewImageHandler(imageService)
+	tusHandler := handlers.NewTusHandler(tusService)
+	sessionHandler := handlers.NewUploadSessionHandler(sessionService)
 	// Return the container with all dependencies
 	return &Container{
 		Logger:     logger,
-		S3Repo:     s3Repo,
+		Storage:    storage,
+		S3Service:  s3Service,
 		JwtService: jwtService,
 		S3Handler:  s3Handler,
+
+		AuthEnabled: authEnabled,
+		ImageLimits: imageLimits,
+
+		ImageService: imageService,
+		ImageHandler: imageHandler,
+
+		TusService: tusService,
+		TusHandler: tusHandler,
+
+		UploadSessionService: sessionService,
+		UploadSessionHandler: sessionHandler,
+	}
+}
+
+// newJWTService создаёт сервис токенов: JWT_KEY — секрет HS256 (не короче 32 байт),
+// открытые ключи RS256/ES256/EdDSA — из newVerificationKeys,
+// JWT_ISSUER и JWT_AUDIENCE — обязательные iss и aud, JWT_LEEWAY — допуск расхождения часов.
+// При выключенной авторизации сервис не нужен; при включённой без секрета и ключей сервис не стартует.
+func newJWTService(authEnabled bool, logger *zap.Logger) services.JWTServiceInterface {
+	if !authEnabled {
+		log.Warn("Authentication is disabled (AUTH_ENABLED=false): all /files routes are open to anyone")
+		return nil
+	}
+	jwtService, err := services.NewJWTService(services.JWTConfig{
+		Secret:   env.GetEnv("JWT_KEY", ""),
+		Keys:     newVerificationKeys(logger),
+		Issuer:   env.GetEnv("JWT_ISSUER", ""),
+		Audience: env.GetEnv("JWT_AUDIENCE", ""),
+		Leeway:   env.GetEnvDuration("JWT_LEEWAY", 30*time.Second),
+	}, logger)
+	if err != nil {
+		log.Fatal("Invalid JWT configuration", zap.Error(err))
+	}
+	return jwtService
+}
+
+// newVerificationKeys читает открытые ключи для асимметричных токенов: JWT_PUBLIC_KEYS — PEM-файлы
+// ("kid=/keys/a.pem,kid2=/keys/b.pem") или JWT_JWKS — путь или URL документа JWKS, который перечитывается
+// раз в JWT_JWKS_REFRESH и при неизвестном kid, но не чаще JWT_JWKS_MIN_REFRESH. Без обоих — nil.
+func newVerificationKeys(logger *zap.Logger) services.VerificationKeys {
+	pemKeys, jwks := env.GetEnv("JWT_PUBLIC_KEYS", ""), env.GetEnv("JWT_JWKS", "")
+	switch {
+	case pemKeys != "" && jwks != "":
+		log.Fatal("JWT_PUBLIC_KEYS and JWT_JWKS are mutually exclusive")
+	case pemKeys != "":
+		keys, err := services.LoadPEMKeys(pemKeys)
+		if err != nil {
+			log.Fatal("Invalid JWT_PUBLIC_KEYS", zap.Error(err))
+		}
+		return keys
+	case jwks != "":
+		keys, err := services.NewJWKSKeys(services.JWKSConfig{
+			Source:     jwks,
+			Refresh:    env.GetEnvDuration("JWT_JWKS_REFRESH", 10*time.Minute),
+			MinRefresh: env.GetEnvDuration("JWT_JWKS_MIN_REFRESH", 30*time.Second),
+		}, logger)
+		if err != nil {
+			log.Fatal("Invalid JWT_JWKS", zap.Error(err))
+		}
+		return keys
+	}
+	return nil
+}
+
+// newImageLimits читает допустимые размеры изображений: IMAGE_MAX_WIDTH, IMAGE_MAX_HEIGHT
+// и IMAGE_MAX_PIXELS (width*height — защита от «decompression bomb»).
+func newImageLimits() imaging.Limits {
+	limits := imaging.Limits{
+		MaxWidth:  env.GetEnvInt("IMAGE_MAX_WIDTH", 10000),
+		MaxHeight: env.GetEnvInt("IMAGE_MAX_HEIGHT", 10000),
+		MaxPixels: int64(env.GetEnvInt("IMAGE_MAX_PIXELS", 50_000_000)),
+	}
+	if limits.MaxWidth <= 0 || limits.MaxHeight <= 0 || limits.MaxPixels <= 0 {
+		log.Fatal("Invalid IMAGE_MAX_WIDTH, IMAGE_MAX_HEIGHT or IMAGE_MAX_PIXELS",
+			zap.Int("width", limits.MaxWidth), zap.Int("height", limits.MaxHeight), zap.Int64("pixels", limits.MaxPixels))
+	}
+	return limits
+}
+
+// newImageService создаёт сервис трансформации изображений.
+// IMAGE_PRESETS — разрешённые пресеты ("avatar=128x128:cover,card=400x300:contain:80:jpeg"),
+// IMAGE_SIGNING_KEY — ключ HMAC для произвольных параметров (пусто — только пресеты).
+// limits ограничивают декодируемые оригиналы так же, как загрузки.
+func newImageService(storage repository.Storage, limits imaging.Limits) *services.ImageService {
+	presets, err := imaging.ParsePresets(env.GetEnv("IMAGE_PRESETS", "thumb=200x200:cover,preview=800x800:contain"))
+	if err != nil {
+		log.Fatal("Invalid IMAGE_PRESETS", zap.Error(err))
+	}
+	return services.NewImageService(
+		storage,
+		presets,
+		env.GetEnv("IMAGE_SIGNING_KEY", ""),
+		limits,
+	)
+}
+
+// newStorage создаёт бэкенд хранилища по его имени из конфигурации (STORAGE_BACKEND).
+func newStorage(backend string) repository.Storage {
+	switch backend {
+	case "s3":
+		bucketName := env.GetEnv("BUCKET_NAME", "")
+		S3AccessKey := env.GetEnv("S3_ACCESS_KEY", "")
+		S3SecretAccessKey := env.GetEnv("S3_SECRET_ACCESS_KEY", "")
+		if bucketName == "" || S3AccessKey == "" || S3SecretAccessKey == "" {
+			log.Fatal("S3 credentials or bucket name are not provided")
+		}
+		return repository.NewS3Repository(repository.S3Config{
+			BucketName:         bucketName,
+			AccessKey:          S3AccessKey,
+			SecretKey:          S3SecretAccessKey,
+			Endpoint:           env.GetEnv("S3_ENDPOINT", "https://s3.timeweb.cloud"),
+			Region:             env.GetEnv("S3_REGION", "ru-1"),
+			UsePathStyle:       env.GetEnvBool("S3_USE_PATH_STYLE", false),
+			InsecureSkipVerify: env.GetEnvBool("S3_INSECURE_SKIP_VERIFY", false),
+			PublicBaseURL:      env.GetEnv("PUBLIC_BASE_URL", ""),
+			DeleteConcurrency:  env.GetEnvInt("S3_DELETE_CONCURRENCY", 4),
+		})
+	case "local":
+		fsRepo, err := repository.NewFSRepository(
+			env.GetEnv("STORAGE_ROOT", "./data"),
+			env.GetEnv("PUBLIC_BASE_URL", "/storage"),
+			env.GetEnv("STORAGE_SIGNING_KEY", ""),
+		)
+		if err != nil {
+			log.Fatal("Failed to init local storage", zap.Error(err))
+		}
+		return fsRepo
+	default:
+		log.Fatal("Unknown storage backend", zap.String("backend", backend))
+		return nil
 	}
 }
@@ -0,0 +1,606 @@
+package repository
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidKey возвращается, если ключ не может быть безопасно отображён на путь в файловой системе.
+var ErrInvalidKey = errors.New("недопустимый ключ объекта")
+
+// tmpFilePrefix — префикс временных файлов, которые создаются при атомарной записи.
+// Такие файлы не попадают в листинг.
+const tmpFilePrefix = ".tmp-"
+
+// multipartDir — каталог внутри Root с незавершёнными multipart-загрузками:
+// .tmp-multipart/<uploadID>/upload (ключ и видимость) и файлы частей part-<номер>.
+const multipartDir = tmpFilePrefix + "multipart"
+
+// errStopWalk — служебная ошибка для досрочного завершения обхода каталога.
+var errStopWalk = errors.New("stop walk")
+
+// FSRepository — хранилище объектов на локальном диске.
+// Объект с ключом "photos/123/uuid.png" лежит в файле Root/photos/123/uuid.png.
+// Видимость хранится в правах файла: публичные — 0644, приватные — 0600.
+type FSRepository struct {
+	Root   string
+	URLs   URLBuilder
+	Signer URLSigner
+}
+
+// Проверяем на этапе компиляции, что FSRepository реализует Storage.
+var _ Storage = (*FSRepository)(nil)
+
+// NewFSRepository создаёт корневой каталог (если его нет) и возвращает репозиторий.
+// publicURL — базовый URL, от которого строятся ссылки на файлы (например, "/storage").
+// signingKey подписывает ссылки на приватные файлы (см. NewURLSigner).
+func NewFSRepository(root, publicURL, signingKey string) (*FSRepository, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось определить путь %q: %w", root, err)
+	}
+	if err := os.MkdirAll(absRoot, 0o755); err != nil {
+		return nil, fmt.Errorf("не удалось создать каталог %q: %w", absRoot, err)
+	}
+	return &FSRepository{
+		Root:   absRoot,
+		URLs:   NewURLBuilder(publicURL),
+		Signer: NewURLSigner(signingKey),
+	}, nil
+}
+
+// UploadFile атомарно записывает объект: сначала во временный файл в том же каталоге, затем rename.
+func (r *FSRepository) UploadFile(
+	ctx context.Context,
+	key, contentType string,
+	body io.Reader,
+	visibility Visibility,
+) (string, error) {
+	fullPath, err := r.resolve(key)
+	if err != nil {
+		return "", err
+	}
+	if err := r.writeAtomic(ctx, fullPath, body, fileMode(visibility)); err != nil {
+		return "", err
+	}
+	return r.FileURL(key), nil
+}
+
+// GetFile открывает файл на чтение.
+func (r *FSRepository) GetFile(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	fullPath, err := r.resolve(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, nil, mapFSError(err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, mapFSError(err)
+	}
+	if stat.IsDir() {
+		_ = f.Close()
+		return nil, nil, ErrNotFound
+	}
+	info := fileInfoToObject(key, stat)
+	return f, &info, nil
+}
+
+// GetFileRange открывает файл и переходит к offset; при length >= 0 чтение ограничивается length байтами.
+func (r *FSRepository) GetFileRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	f, _, err := r.GetFile(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	file := f.(*os.File)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return limitedReadCloser{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
+
+// HeadFile возвращает метаданные файла.
+func (r *FSRepository) HeadFile(ctx context.Context, key string) (*ObjectInfo, error) {
+	fullPath, err := r.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, mapFSError(err)
+	}
+	if stat.IsDir() {
+		return nil, ErrNotFound
+	}
+	info := fileInfoToObject(key, stat)
+	return &info, nil
+}
+
+// ListPages отдаёт файлы с префиксом prefix страницами. Каталог обходится целиком до первого
+// вызова fn, поэтому fn может удалять файлы.
+func (r *FSRepository) ListPages(ctx context.Context, prefix string, fn func(page []ObjectInfo) error) error {
+	objects, err := r.list(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	return pageObjects(ctx, objects, fn)
+}
+
+// ListPage возвращает страницу файлов после ключа opts.Token.
+func (r *FSRepository) ListPage(ctx context.Context, opts ListPageOptions) (*ObjectPage, error) {
+	objects, err := r.list(ctx, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return pageAfter(objects, opts), nil
+}
+
+// list возвращает файлы, ключи которых начинаются с prefix, отсортированные по ключу.
+func (r *FSRepository) list(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := r.walk(ctx, prefix, func(info ObjectInfo) error {
+		objects = append(objects, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// FolderExists проверяет, есть ли хотя бы один файл с префиксом folderName.
+func (r *FSRepository) FolderExists(ctx context.Context, folderName string) (bool, error) {
+	found := false
+	err := r.walk(ctx, folderName, func(ObjectInfo) error {
+		found = true
+		return errStopWalk
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// DeleteFile удаляет файл и пустые родительские каталоги. Отсутствие файла ошибкой не считается,
+// как и в S3.
+func (r *FSRepository) DeleteFile(ctx context.Context, key string) error {
+	fullPath, err := r.resolve(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fullPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	r.pruneEmptyDirs(filepath.Dir(fullPath))
+	return nil
+}
+
+// DeleteFilesBatch удаляет группу файлов по одному; неудачные ключи собираются в DeleteFilesError.
+func (r *FSRepository) DeleteFilesBatch(ctx context.Context, keys []string) error {
+	var failed []DeleteFailure
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := r.DeleteFile(ctx, k); err != nil {
+			failed = append(failed, DeleteFailure{Key: k, Reason: err.Error()})
+		}
+	}
+	return deleteFilesError(failed)
+}
+
+// CopyFile копирует файл srcKey в dstKey (запись dstKey также атомарная).
+func (r *FSRepository) CopyFile(ctx context.Context, srcKey, dstKey string, visibility Visibility) error {
+	src, _, err := r.GetFile(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dstPath, err := r.resolve(dstKey)
+	if err != nil {
+		return err
+	}
+	return r.writeAtomic(ctx, dstPath, src, fileMode(visibility))
+}
+
+// FileURL возвращает URL файла относительно публичного адреса хранилища.
+func (r *FSRepository) FileURL(key string) string {
+	return r.URLs.FileURL(key)
+}
+
+// PresignGetURL возвращает ссылку на файл с подписью URLSigner; её проверяет ServeHTTP.
+func (r *FSRepository) PresignGetURL(ctx context.Context, key string, opts PresignOptions) (string, error) {
+	if _, err := r.HeadFile(ctx, key); err != nil {
+		return "", err
+	}
+	return r.Signer.Sign(r.FileURL(key), key, opts, time.Now()), nil
+}
+
+// PresignUpload не поддерживается: клиенты локального хранилища загружают файлы через сервис.
+func (r *FSRepository) PresignUpload(ctx context.Context, key string, opts PresignUploadOptions) (*PresignedUpload, error) {
+	return nil, ErrNotSupported
+}
+
+// CreateMultipartUpload создаёт каталог для частей и запоминает ключ и видимость объекта.
+// Content-Type локальное хранилище определяет по расширению ключа, поэтому он не сохраняется.
+func (r *FSRepository) CreateMultipartUpload(
+	ctx context.Context,
+	key, contentType string,
+	visibility Visibility,
+) (string, error) {
+	if _, err := r.resolve(key); err != nil {
+		return "", err
+	}
+	uploadID := newUploadID()
+	dir := filepath.Join(r.Root, multipartDir, uploadID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	meta := key + "\n" + string(visibility)
+	if err := os.WriteFile(filepath.Join(dir, "upload"), []byte(meta), 0o600); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// UploadPart атомарно записывает часть; ETag строится так же, как для обычных файлов.
+func (r *FSRepository) UploadPart(
+	ctx context.Context,
+	key, uploadID string,
+	number int32,
+	body io.Reader,
+	size int64,
+) (string, error) {
+	dir, _, err := r.multipartUpload(key, uploadID)
+	if err != nil {
+		return "", err
+	}
+	partPath := filepath.Join(dir, fmt.Sprintf("part-%d", number))
+	if err := r.writeAtomic(ctx, partPath, body, 0o600); err != nil {
+		return "", err
+	}
+	stat, err := os.Stat(partPath)
+	if err != nil {
+		return "", err
+	}
+	if stat.Size() != size {
+		return "", fmt.Errorf("размер части %d не совпадает с заявленным %d", stat.Size(), size)
+	}
+	return fileInfoToObject(key, stat).ETag, nil
+}
+
+// CompleteMultipartUpload склеивает части в итоговый файл (запись атомарная) и удаляет каталог загрузки.
+func (r *FSRepository) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	if err := checkParts(parts); err != nil {
+		return err
+	}
+	dir, visibility, err := r.multipartUpload(key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	for _, part := range parts {
+		f, err := os.Open(filepath.Join(dir, fmt.Sprintf("part-%d", part.Number)))
+		if err != nil {
+			return fmt.Errorf("часть %d не загружена: %w", part.Number, err)
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if fileInfoToObject(key, stat).ETag != part.ETag {
+			return fmt.Errorf("ETag части %d не совпадает", part.Number)
+		}
+		readers = append(readers, f)
+	}
+
+	fullPath, err := r.resolve(key)
+	if err != nil {
+		return err
+	}
+	if err := r.writeAtomic(ctx, fullPath, io.MultiReader(readers...), fileMode(visibility)); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// AbortMultipartUpload удаляет каталог загрузки вместе с частями.
+func (r *FSRepository) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	dir, _, err := r.multipartUpload(key, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// multipartUpload находит каталог загрузки и проверяет, что она начата для того же ключа.
+func (r *FSRepository) multipartUpload(key, uploadID string) (string, Visibility, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", "", fmt.Errorf("%w: multipart-загрузка %q", ErrNotFound, uploadID)
+	}
+	dir := filepath.Join(r.Root, multipartDir, uploadID)
+	meta, err := os.ReadFile(filepath.Join(dir, "upload"))
+	if err != nil {
+		return "", "", mapFSError(err)
+	}
+	storedKey, visibility, _ := strings.Cut(string(meta), "\n")
+	if storedKey != key {
+		return "", "", fmt.Errorf("%w: multipart-загрузка %s", ErrNotFound, uploadID)
+	}
+	return dir, Visibility(visibility), nil
+}
+
+// ServeHTTP раздаёт файлы по пути, равному ключу ("/photos/123/uuid.png"); монтируется под
+// префиксом публичного URL через http.StripPrefix. Приватные файлы отдаются только по ссылке
+// из PresignGetURL, каталоги не листятся.
+func (r *FSRepository) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	key := strings.TrimPrefix(req.URL.Path, "/")
+	f, info, err := r.GetFile(req.Context(), key)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer f.Close()
+
+	file := f.(*os.File)
+	stat, err := file.Stat()
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	if stat.Mode().Perm()&0o004 == 0 {
+		query := req.URL.Query()
+		if err := r.Signer.Verify(key, query, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if disposition := query.Get("disposition"); disposition != "" {
+			w.Header().Set("Content-Disposition", disposition)
+		}
+	}
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("ETag", info.ETag)
+	http.ServeContent(w, req, path.Base(key), info.LastModified, file)
+}
+
+// fileMode возвращает права файла для видимости.
+func fileMode(visibility Visibility) os.FileMode {
+	if visibility == VisibilityPrivate {
+		return 0o600
+	}
+	return 0o644
+}
+
+// resolve переводит ключ в абсолютный путь внутри Root.
+// Отклоняет абсолютные пути, "..", обратные слэши и NUL, чтобы ключ не мог выйти за пределы Root.
+func (r *FSRepository) resolve(key string) (string, error) {
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	if err := validateKeyPath(key); err != nil {
+		return "", err
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if strings.HasPrefix(segment, tmpFilePrefix) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return r.join(key)
+}
+
+// join склеивает Root и путь из ключа и проверяет, что результат не вышел за пределы Root.
+func (r *FSRepository) join(key string) (string, error) {
+	fullPath := filepath.Join(r.Root, filepath.FromSlash(key))
+	rel, err := filepath.Rel(r.Root, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return fullPath, nil
+}
+
+// validateKeyPath проверяет ключ или префикс: только относительные пути без "." и "..".
+func validateKeyPath(key string) error {
+	if strings.ContainsAny(key, "\\\x00") || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, segment := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+// writeAtomic пишет body во временный файл рядом с fullPath и переименовывает его.
+// При любой ошибке временный файл удаляется, а существующий файл остаётся нетронутым.
+func (r *FSRepository) writeAtomic(ctx context.Context, fullPath string, body io.Reader, mode os.FileMode) (err error) {
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, tmpFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = io.Copy(tmp, contextReader{ctx: ctx, r: body}); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fullPath)
+}
+
+// walk обходит файлы с ключами, начинающимися с prefix, в порядке возрастания ключей.
+// Если fn возвращает errStopWalk, обход завершается без ошибки.
+func (r *FSRepository) walk(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	if prefix != "" {
+		if err := validateKeyPath(prefix); err != nil {
+			return err
+		}
+	}
+
+	// Начинаем обход с «каталога» префикса: для "photos/1/abc" это photos/1.
+	startKey := prefix
+	if !strings.HasSuffix(startKey, "/") {
+		startKey = path.Dir(startKey)
+	}
+	startDir := r.Root
+	if startKey != "." && startKey != "" {
+		dir, err := r.join(startKey)
+		if err != nil {
+			return err
+		}
+		startDir = dir
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(startDir, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if errors.Is(walkErr, fs.ErrNotExist) {
+				return nil
+			}
+			return walkErr
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), tmpFilePrefix) {
+			// Временные файлы и каталог multipart-загрузок в листинг не попадают
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(r.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		objects = append(objects, fileInfoToObject(key, stat))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Порядок WalkDir отличается от побайтового порядка ключей S3, поэтому сортируем.
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	for _, obj := range objects {
+		if err := fn(obj); err != nil {
+			if errors.Is(err, errStopWalk) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneEmptyDirs удаляет пустые каталоги от dir вверх до Root (не включая Root),
+// чтобы «папка» исчезала вместе с последним файлом, как в S3.
+func (r *FSRepository) pruneEmptyDirs(dir string) {
+	for dir != r.Root && strings.HasPrefix(dir, r.Root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// fileInfoToObject строит ObjectInfo по данным файловой системы.
+// ETag формируется из размера и времени изменения, как это делают веб-серверы для статики.
+func fileInfoToObject(key string, stat fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ContentType:  contentTypeByKey(key),
+		LastModified: stat.ModTime().UTC(),
+		ETag:         fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size()),
+	}
+}
+
+// contentTypeByKey определяет Content-Type по расширению ключа.
+func contentTypeByKey(key string) string {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// mapFSError приводит «файл не найден» к ErrNotFound.
+func mapFSError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
+
+// limitedReadCloser — ограниченный reader, закрывающий исходный файл.
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// contextReader прерывает чтение, если контекст отменён.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
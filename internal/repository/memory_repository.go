@@ -0,0 +1,326 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryObject — объект, хранящийся в памяти.
+type memoryObject struct {
+	data       []byte
+	info       ObjectInfo
+	visibility Visibility
+}
+
+// memoryMultipart — незавершённая multipart-загрузка: части хранятся по номерам.
+type memoryMultipart struct {
+	key         string
+	contentType string
+	visibility  Visibility
+	parts       map[int32][]byte
+}
+
+// MemoryRepository — хранилище объектов в памяти процесса. Используется только в тестах:
+// через STORAGE_BACKEND его выбрать нельзя (см. ioc.NewContainerWithStorage).
+type MemoryRepository struct {
+	mu        sync.RWMutex
+	objects   map[string]memoryObject
+	multipart map[string]*memoryMultipart
+	URLs      URLBuilder
+}
+
+// Проверяем на этапе компиляции, что MemoryRepository реализует Storage.
+var _ Storage = (*MemoryRepository)(nil)
+
+// NewMemoryRepository создаёт пустое хранилище в памяти.
+func NewMemoryRepository(publicURL string) *MemoryRepository {
+	return &MemoryRepository{
+		objects:   make(map[string]memoryObject),
+		multipart: make(map[string]*memoryMultipart),
+		URLs:      NewURLBuilder(publicURL),
+	}
+}
+
+// UploadFile целиком читает body и сохраняет его под ключом key.
+func (r *MemoryRepository) UploadFile(
+	ctx context.Context,
+	key, contentType string,
+	body io.Reader,
+	visibility Visibility,
+) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	data, err := io.ReadAll(contextReader{ctx: ctx, r: body})
+	if err != nil {
+		return "", err
+	}
+	r.put(key, contentType, data, visibility)
+	return r.FileURL(key), nil
+}
+
+// GetFile возвращает копию содержимого объекта.
+func (r *MemoryRepository) GetFile(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	r.mu.RLock()
+	obj, ok := r.objects[key]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	info := obj.info
+	return io.NopCloser(bytes.NewReader(obj.data)), &info, nil
+}
+
+// GetFileRange возвращает копию части содержимого объекта.
+func (r *MemoryRepository) GetFileRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	r.mu.RLock()
+	obj, ok := r.objects[key]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	data := obj.data[min(offset, int64(len(obj.data))):]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// HeadFile возвращает метаданные объекта.
+func (r *MemoryRepository) HeadFile(ctx context.Context, key string) (*ObjectInfo, error) {
+	r.mu.RLock()
+	obj, ok := r.objects[key]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	info := obj.info
+	return &info, nil
+}
+
+// ListPages отдаёт снимок объектов с префиксом prefix страницами; блокировка на время fn
+// не удерживается, поэтому fn может удалять объекты.
+func (r *MemoryRepository) ListPages(ctx context.Context, prefix string, fn func(page []ObjectInfo) error) error {
+	objects, err := r.list(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	return pageObjects(ctx, objects, fn)
+}
+
+// ListPage возвращает страницу объектов после ключа opts.Token.
+func (r *MemoryRepository) ListPage(ctx context.Context, opts ListPageOptions) (*ObjectPage, error) {
+	objects, err := r.list(ctx, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	return pageAfter(objects, opts), nil
+}
+
+// list возвращает объекты с префиксом prefix, отсортированные по ключу.
+func (r *MemoryRepository) list(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	objects := make([]ObjectInfo, 0)
+	for key, obj := range r.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, obj.info)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// FolderExists проверяет, есть ли хотя бы один объект с префиксом folderName.
+func (r *MemoryRepository) FolderExists(ctx context.Context, folderName string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for key := range r.objects {
+		if strings.HasPrefix(key, folderName) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeleteFile удаляет объект. Отсутствие объекта ошибкой не считается, как и в S3.
+func (r *MemoryRepository) DeleteFile(ctx context.Context, key string) error {
+	r.mu.Lock()
+	delete(r.objects, key)
+	r.mu.Unlock()
+	return nil
+}
+
+// DeleteFilesBatch удаляет группу объектов.
+func (r *MemoryRepository) DeleteFilesBatch(ctx context.Context, keys []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range keys {
+		delete(r.objects, k)
+	}
+	return nil
+}
+
+// CopyFile копирует объект srcKey в dstKey.
+func (r *MemoryRepository) CopyFile(ctx context.Context, srcKey, dstKey string, visibility Visibility) error {
+	r.mu.RLock()
+	src, ok := r.objects[srcKey]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, srcKey)
+	}
+	r.put(dstKey, src.info.ContentType, bytes.Clone(src.data), visibility)
+	return nil
+}
+
+// FileURL возвращает URL объекта относительно публичного адреса хранилища.
+func (r *MemoryRepository) FileURL(key string) string {
+	return r.URLs.FileURL(key)
+}
+
+// PresignGetURL не поддерживается: хранилище в памяти файлы не раздаёт, и подписанную ссылку
+// некому обслужить.
+func (r *MemoryRepository) PresignGetURL(ctx context.Context, key string, opts PresignOptions) (string, error) {
+	return "", ErrNotSupported
+}
+
+// PresignUpload не поддерживается по той же причине, что и PresignGetURL.
+func (r *MemoryRepository) PresignUpload(ctx context.Context, key string, opts PresignUploadOptions) (*PresignedUpload, error) {
+	return nil, ErrNotSupported
+}
+
+// CreateMultipartUpload регистрирует загрузку по частям.
+func (r *MemoryRepository) CreateMultipartUpload(
+	ctx context.Context,
+	key, contentType string,
+	visibility Visibility,
+) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	uploadID := newUploadID()
+	r.mu.Lock()
+	r.multipart[uploadID] = &memoryMultipart{
+		key:         key,
+		contentType: contentType,
+		visibility:  visibility,
+		parts:       make(map[int32][]byte),
+	}
+	r.mu.Unlock()
+	return uploadID, nil
+}
+
+// UploadPart читает часть целиком; ETag — MD5 её содержимого, как в S3.
+func (r *MemoryRepository) UploadPart(
+	ctx context.Context,
+	key, uploadID string,
+	number int32,
+	body io.Reader,
+	size int64,
+) (string, error) {
+	data, err := io.ReadAll(contextReader{ctx: ctx, r: body})
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) != size {
+		return "", fmt.Errorf("размер части %d не совпадает с заявленным %d", len(data), size)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	upload, ok := r.multipart[uploadID]
+	if !ok || upload.key != key {
+		return "", fmt.Errorf("%w: multipart-загрузка %s", ErrNotFound, uploadID)
+	}
+	upload.parts[number] = data
+	sum := md5.Sum(data)
+	return "\"" + hex.EncodeToString(sum[:]) + "\"", nil
+}
+
+// CompleteMultipartUpload склеивает части и сохраняет объект.
+func (r *MemoryRepository) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	if err := checkParts(parts); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	upload, ok := r.multipart[uploadID]
+	if !ok || upload.key != key {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: multipart-загрузка %s", ErrNotFound, uploadID)
+	}
+	var data []byte
+	for _, part := range parts {
+		chunk, ok := upload.parts[part.Number]
+		sum := md5.Sum(chunk)
+		if !ok || part.ETag != "\""+hex.EncodeToString(sum[:])+"\"" {
+			r.mu.Unlock()
+			return fmt.Errorf("часть %d не загружена или её ETag не совпадает", part.Number)
+		}
+		data = append(data, chunk...)
+	}
+	delete(r.multipart, uploadID)
+	r.mu.Unlock()
+
+	r.put(key, upload.contentType, data, upload.visibility)
+	return nil
+}
+
+// AbortMultipartUpload удаляет загрузку вместе с частями.
+func (r *MemoryRepository) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	upload, ok := r.multipart[uploadID]
+	if !ok || upload.key != key {
+		return fmt.Errorf("%w: multipart-загрузка %s", ErrNotFound, uploadID)
+	}
+	delete(r.multipart, uploadID)
+	return nil
+}
+
+// MultipartUploads возвращает число незавершённых multipart-загрузок.
+func (r *MemoryRepository) MultipartUploads() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.multipart)
+}
+
+// Visibility возвращает видимость объекта.
+func (r *MemoryRepository) Visibility(key string) (Visibility, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	obj, ok := r.objects[key]
+	return obj.visibility, ok
+}
+
+// put сохраняет данные и вычисляет метаданные так же, как это делает S3 (ETag — MD5 содержимого).
+func (r *MemoryRepository) put(key, contentType string, data []byte, visibility Visibility) {
+	sum := md5.Sum(data)
+	obj := memoryObject{
+		data:       data,
+		visibility: visibility,
+		info: ObjectInfo{
+			Key:          key,
+			Size:         int64(len(data)),
+			ContentType:  contentType,
+			LastModified: time.Now().UTC(),
+			ETag:         "\"" + hex.EncodeToString(sum[:]) + "\"",
+			StorageClass: "STANDARD",
+		},
+	}
+
+	r.mu.Lock()
+	r.objects[key] = obj
+	r.mu.Unlock()
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// Ограничения multipart-загрузки S3.
+const (
+	// MinPartSize — наименьший размер части, кроме последней.
+	MinPartSize = 5 << 20
+	// MaxParts — наибольшее число частей.
+	MaxParts = 10000
+)
+
+// CompletedPart — сохранённая часть multipart-загрузки.
+type CompletedPart struct {
+	Number int32  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// checkParts проверяет, что номера частей идут по возрастанию, как того требует S3.
+func checkParts(parts []CompletedPart) error {
+	if len(parts) == 0 {
+		return fmt.Errorf("multipart-загрузка без частей")
+	}
+	for i := 1; i < len(parts); i++ {
+		if parts[i].Number <= parts[i-1].Number {
+			return fmt.Errorf("части multipart-загрузки должны идти по возрастанию номеров")
+		}
+	}
+	return nil
+}
+
+// newUploadID генерирует идентификатор multipart-загрузки для бэкендов без собственного.
+func newUploadID() string {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(random)
+}
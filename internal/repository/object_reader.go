@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ObjectReader — io.ReadSeekCloser поверх Storage. Чтение после Seek открывает объект с нового
+// смещения (ranged GET), поэтому читаются только запрошенные части. Подходит для http.ServeContent,
+// который сам разбирает Range и условные заголовки.
+type ObjectReader struct {
+	Info ObjectInfo
+
+	ctx    context.Context
+	repo   Storage
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewObjectReader — конструктор. info должен быть получен через HeadFile: размер из него
+// используется для Seek относительно конца объекта.
+func NewObjectReader(ctx context.Context, repo Storage, info ObjectInfo) *ObjectReader {
+	return &ObjectReader{Info: info, ctx: ctx, repo: repo}
+}
+
+// Read читает объект с текущего смещения.
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.Info.Size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.repo.GetFileRange(r.ctx, r.Info.Key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.Info.Size {
+		// Объект оказался короче, чем в метаданных (перезаписан во время чтения)
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Seek меняет смещение; открытое тело закрывается и при следующем чтении запрашивается заново.
+func (r *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.Info.Size
+	default:
+		return 0, errors.New("некорректный whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("отрицательное смещение")
+	}
+	if offset != r.offset {
+		r.closeBody()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+// Close закрывает открытое тело объекта.
+func (r *ObjectReader) Close() error {
+	r.closeBody()
+	return nil
+}
+
+func (r *ObjectReader) closeBody() {
+	if r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+}
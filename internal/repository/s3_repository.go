@@ -2,111 +2,485 @@ package repository
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
+// S3Config — параметры подключения к S3-совместимому хранилищу.
+type S3Config struct {
+	BucketName string
+	AccessKey  string
+	SecretKey  string
+	// Endpoint — адрес S3 API, например https://s3.timeweb.cloud или http://localhost:9000 (MinIO).
+	// Пустая строка — стандартный endpoint AWS для Region.
+	Endpoint string
+	Region   string
+	// UsePathStyle включает адресацию вида endpoint/bucket/key (нужна для MinIO).
+	UsePathStyle bool
+	// InsecureSkipVerify отключает проверку TLS-сертификата endpoint (только для разработки).
+	InsecureSkipVerify bool
+	// PublicBaseURL — адрес, от которого строятся ссылки на файлы (домен бакета или CDN).
+	// Если пусто, вычисляется из Endpoint и BucketName.
+	PublicBaseURL string
+	// DeleteConcurrency — сколько запросов DeleteObjects выполняется одновременно (по умолчанию 4).
+	DeleteConcurrency int
+}
+
 type S3Repository struct {
 	Client     *s3.Client
 	Uploader   *manager.Uploader
+	Presigner  *s3.PresignClient
 	BucketName string
+	URLs       URLBuilder
+	// DeleteConcurrency — наибольшее число одновременных запросов DeleteObjects.
+	DeleteConcurrency int
 }
 
-func NewS3Repository(bucketName, accessKey, secretKey string) *S3Repository {
-	cfg, err := config.LoadDefaultConfig(
-		context.TODO(),
-		config.WithRegion("ru-1"),
+// Проверяем на этапе компиляции, что S3Repository реализует Storage.
+var _ Storage = (*S3Repository)(nil)
+
+func NewS3Repository(cfg S3Config) *S3Repository {
+	publicBaseURL := cfg.PublicBaseURL
+	if publicBaseURL == "" {
+		var err error
+		publicBaseURL, err = DefaultS3PublicBaseURL(cfg.Endpoint, cfg.Region, cfg.BucketName, cfg.UsePathStyle)
+		if err != nil {
+			log.Fatalf("Ошибка конфигурации S3: %v", err)
+		}
+	}
+
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(cfg.Region),
 		config.WithCredentialsProvider(
-			credentials.NewStaticCredentialsProvider(accessKey, secretKey, ""),
-		),
-		config.WithEndpointResolverWithOptions(
-			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-				if service == s3.ServiceID {
-					return aws.Endpoint{
-						URL:           "https://s3.timeweb.cloud",
-						SigningRegion: "ru-1",
-					}, nil
-				}
-				return aws.Endpoint{}, &aws.EndpointNotFoundError{}
-			}),
+			credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, ""),
 		),
-	)
+	}
+	if cfg.InsecureSkipVerify {
+		httpClient := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{}
+			}
+			tr.TLSClientConfig.InsecureSkipVerify = true
+		})
+		opts = append(opts, config.WithHTTPClient(httpClient))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
 	if err != nil {
 		log.Fatalf("Ошибка загрузки AWS конфигурации: %v", err)
 	}
 
-	client := s3.NewFromConfig(cfg)
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.UsePathStyle
+	})
 	uploader := manager.NewUploader(client)
+	if cfg.DeleteConcurrency <= 0 {
+		cfg.DeleteConcurrency = 4
+	}
 
 	return &S3Repository{
-		Client:     client,
-		Uploader:   uploader,
-		BucketName: bucketName,
+		Client:            client,
+		Uploader:          uploader,
+		Presigner:         s3.NewPresignClient(client),
+		BucketName:        cfg.BucketName,
+		URLs:              NewURLBuilder(publicBaseURL),
+		DeleteConcurrency: cfg.DeleteConcurrency,
 	}
 }
 
-func (r *S3Repository) UploadFile(ctx context.Context, key, contentType string, body io.Reader) (string, error) {
+func (r *S3Repository) UploadFile(
+	ctx context.Context,
+	key, contentType string,
+	body io.Reader,
+	visibility Visibility,
+) (string, error) {
 	_, err := r.Uploader.Upload(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(r.BucketName),
 		Key:         aws.String(key),
-		ACL:         "public-read",
+		ACL:         objectACL(visibility),
 		ContentType: aws.String(contentType),
 		Body:        body,
 	})
 	if err != nil {
 		return "", err
 	}
-	finalURL := fmt.Sprintf("https://%s.s3.timeweb.cloud/%s", r.BucketName, key)
-	return finalURL, nil
+	return r.FileURL(key), nil
 }
 
-func (r *S3Repository) ListFilesByPrefix(ctx context.Context, prefix string) ([]types.Object, error) {
-	listResp, err := r.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+// GetFile открывает объект на чтение.
+func (r *S3Repository) GetFile(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	resp, err := r.Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(r.BucketName),
-		Prefix: aws.String(prefix),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, mapS3Error(err)
+	}
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(resp.ContentLength),
+		ContentType:  aws.ToString(resp.ContentType),
+		LastModified: aws.ToTime(resp.LastModified),
+		ETag:         aws.ToString(resp.ETag),
+		StorageClass: string(resp.StorageClass),
+	}
+	return resp.Body, info, nil
+}
+
+// GetFileRange читает часть объекта через заголовок Range.
+func (r *S3Repository) GetFileRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+	resp, err := r.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.BucketName),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange),
+	})
+	if err != nil {
+		return nil, mapS3Error(err)
+	}
+	return resp.Body, nil
+}
+
+// HeadFile возвращает метаданные объекта.
+func (r *S3Repository) HeadFile(ctx context.Context, key string) (*ObjectInfo, error) {
+	resp, err := r.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(r.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, mapS3Error(err)
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(resp.ContentLength),
+		ContentType:  aws.ToString(resp.ContentType),
+		LastModified: aws.ToTime(resp.LastModified),
+		ETag:         aws.ToString(resp.ETag),
+		StorageClass: string(resp.StorageClass),
+	}, nil
+}
+
+// ListPages запрашивает ListObjectsV2 страницу за страницей: в памяти одновременно только
+// одна страница. Токен продолжения не зависит от удаления уже полученных ключей.
+func (r *S3Repository) ListPages(ctx context.Context, prefix string, fn func(page []ObjectInfo) error) error {
+	paginator := s3.NewListObjectsV2Paginator(r.Client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(r.BucketName),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(ListPageSize),
 	})
+	for paginator.HasMorePages() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("ошибка при получении страницы: %w", err)
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		if err := fn(toObjectInfos(page.Contents)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListPage выполняет один запрос ListObjectsV2; токен — ContinuationToken S3.
+// С Delimiter «подпапки» возвращает сам S3 (CommonPrefixes).
+func (r *S3Repository) ListPage(ctx context.Context, opts ListPageOptions) (*ObjectPage, error) {
+	limit := opts.Limit
+	if limit <= 0 || limit > ListPageSize {
+		limit = ListPageSize
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(r.BucketName),
+		Prefix:  aws.String(opts.Prefix),
+		MaxKeys: aws.Int32(int32(limit)),
+	}
+	if opts.Token != "" {
+		input.ContinuationToken = aws.String(opts.Token)
+	}
+	if opts.Delimiter != "" {
+		input.Delimiter = aws.String(opts.Delimiter)
+	}
+	resp, err := r.Client.ListObjectsV2(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("ошибка при получении страницы: %w", err)
+	}
+	page := &ObjectPage{Objects: toObjectInfos(resp.Contents)}
+	for _, common := range resp.CommonPrefixes {
+		if common.Prefix != nil {
+			page.CommonPrefixes = append(page.CommonPrefixes, *common.Prefix)
+		}
 	}
-	return listResp.Contents, nil
+	if aws.ToBool(resp.IsTruncated) {
+		page.NextToken = aws.ToString(resp.NextContinuationToken)
+	}
+	return page, nil
 }
 
+// DeleteFile удаляет один объект. S3-совместимые хранилища, которые отвечают NoSuchKey
+// на удаление отсутствующего объекта, дают ErrNotFound.
 func (r *S3Repository) DeleteFile(ctx context.Context, key string) error {
 	_, err := r.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(r.BucketName),
 		Key:    aws.String(key),
 	})
-	return err
+	return mapS3Error(err)
 }
 
-// Удаление группы файлов (по одному в цикле).
+// DeleteFilesBatch удаляет ключи запросами DeleteObjects по MaxDeleteKeys, выполняя
+// не больше DeleteConcurrency запросов одновременно. Ключи, которые S3 не удалил, и ключи
+// из неудавшихся запросов возвращаются в DeleteFilesError.
 func (r *S3Repository) DeleteFilesBatch(ctx context.Context, keys []string) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []DeleteFailure
+	)
+	sem := make(chan struct{}, max(r.DeleteConcurrency, 1))
+	for batch := range slices.Chunk(keys, MaxDeleteKeys) {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			batchFailed := r.deleteObjects(ctx, batch)
+			mu.Lock()
+			failed = append(failed, batchFailed...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return deleteFilesError(failed)
+}
+
+// deleteObjects выполняет один запрос DeleteObjects в режиме Quiet: в ответе только ошибки.
+func (r *S3Repository) deleteObjects(ctx context.Context, keys []string) []DeleteFailure {
+	objects := make([]types.ObjectIdentifier, 0, len(keys))
 	for _, k := range keys {
-		if err := r.DeleteFile(ctx, k); err != nil {
-			return err
+		objects = append(objects, types.ObjectIdentifier{Key: aws.String(k)})
+	}
+	resp, err := r.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(r.BucketName),
+		Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		failed := make([]DeleteFailure, 0, len(keys))
+		for _, k := range keys {
+			failed = append(failed, DeleteFailure{Key: k, Reason: err.Error()})
 		}
+		return failed
 	}
-	return nil
+	failed := make([]DeleteFailure, 0, len(resp.Errors))
+	for _, e := range resp.Errors {
+		failed = append(failed, DeleteFailure{
+			Key:    aws.ToString(e.Key),
+			Reason: fmt.Sprintf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message)),
+		})
+	}
+	return failed
+}
+
+// CopyFile копирует объект внутри бакета. ACL копии задаёт visibility (public-read или private),
+// а не ACL исходного объекта.
+func (r *S3Repository) CopyFile(ctx context.Context, srcKey, dstKey string, visibility Visibility) error {
+	_, err := r.Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(r.BucketName),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(copySource(r.BucketName, srcKey)),
+		ACL:        objectACL(visibility),
+	})
+	return mapS3Error(err)
+}
+
+// PresignGetURL подписывает GetObject через presign-клиент SDK.
+// Срок действия ограничен 7 днями (ограничение SigV4).
+func (r *S3Repository) PresignGetURL(ctx context.Context, key string, opts PresignOptions) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(r.BucketName),
+		Key:    aws.String(key),
+	}
+	if opts.ContentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	req, err := r.Presigner.PresignGetObject(ctx, input, s3.WithPresignExpires(opts.Expires))
+	if err != nil {
+		return "", fmt.Errorf("ошибка подписи ссылки: %w", err)
+	}
+	return req.URL, nil
+}
+
+// PresignUpload подписывает PutObject (с Content-Type, Content-Length и ACL в подписи) или
+// POST-политику с условиями на Content-Type, размер и ACL.
+func (r *S3Repository) PresignUpload(ctx context.Context, key string, opts PresignUploadOptions) (*PresignedUpload, error) {
+	acl := objectACL(opts.Visibility)
+	switch opts.Method {
+	case UploadMethodPut:
+		input := &s3.PutObjectInput{
+			Bucket:        aws.String(r.BucketName),
+			Key:           aws.String(key),
+			ContentType:   aws.String(opts.ContentType),
+			ContentLength: aws.Int64(opts.Size),
+			ACL:           acl,
+		}
+		req, err := r.Presigner.PresignPutObject(ctx, input, s3.WithPresignExpires(opts.Expires))
+		if err != nil {
+			return nil, fmt.Errorf("ошибка подписи загрузки: %w", err)
+		}
+		headers := map[string]string{"Content-Type": opts.ContentType}
+		if acl != "" {
+			headers["x-amz-acl"] = string(acl)
+		}
+		return &PresignedUpload{Method: UploadMethodPut, URL: req.URL, Headers: headers}, nil
+
+	case UploadMethodPost:
+		fields := map[string]string{"Content-Type": opts.ContentType}
+		conditions := []interface{}{
+			map[string]string{"Content-Type": opts.ContentType},
+			[]interface{}{"content-length-range", 1, opts.MaxSize},
+		}
+		if acl != "" {
+			fields["acl"] = string(acl)
+			conditions = append(conditions, map[string]string{"acl": string(acl)})
+		}
+		input := &s3.PutObjectInput{
+			Bucket: aws.String(r.BucketName),
+			Key:    aws.String(key),
+		}
+		req, err := r.Presigner.PresignPostObject(ctx, input, func(o *s3.PresignPostOptions) {
+			o.Expires = opts.Expires
+			o.Conditions = conditions
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ошибка подписи POST-политики: %w", err)
+		}
+		for name, value := range req.Values {
+			fields[name] = value
+		}
+		return &PresignedUpload{Method: UploadMethodPost, URL: req.URL, Fields: fields}, nil
+
+	default:
+		return nil, fmt.Errorf("неизвестный способ загрузки %q", opts.Method)
+	}
+}
+
+// CreateMultipartUpload начинает multipart-загрузку; видимость задаётся сразу, как и в UploadFile.
+func (r *S3Repository) CreateMultipartUpload(
+	ctx context.Context,
+	key, contentType string,
+	visibility Visibility,
+) (string, error) {
+	resp, err := r.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(r.BucketName),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		ACL:         objectACL(visibility),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(resp.UploadId), nil
+}
+
+// UploadPart загружает часть. Тело лучше передавать как io.ReadSeeker (например, bytes.Reader):
+// тогда SDK может подписать содержимое и повторить запрос при сбое.
+func (r *S3Repository) UploadPart(
+	ctx context.Context,
+	key, uploadID string,
+	number int32,
+	body io.Reader,
+	size int64,
+) (string, error) {
+	resp, err := r.Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(r.BucketName),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(number),
+		ContentLength: aws.Int64(size),
+		Body:          body,
+	})
+	if err != nil {
+		return "", mapS3Error(err)
+	}
+	return aws.ToString(resp.ETag), nil
+}
+
+// CompleteMultipartUpload собирает объект из частей.
+func (r *S3Repository) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	if err := checkParts(parts); err != nil {
+		return err
+	}
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{
+			PartNumber: aws.Int32(part.Number),
+			ETag:       aws.String(part.ETag),
+		})
+	}
+	_, err := r.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(r.BucketName),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return mapS3Error(err)
+}
+
+// AbortMultipartUpload отменяет загрузку; S3 удаляет загруженные части.
+func (r *S3Repository) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	_, err := r.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(r.BucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return mapS3Error(err)
+}
+
+// objectACL возвращает canned ACL для видимости: приватные объекты загружаются без ACL
+// и наследуют приватный доступ бакета.
+func objectACL(visibility Visibility) types.ObjectCannedACL {
+	if visibility == VisibilityPrivate {
+		return ""
+	}
+	return types.ObjectCannedACLPublicRead
 }
 
 // FolderExists проверяет, существует ли указанный префикс (папка) в S3.
 // Например, folderName = "photos/".
 func (r *S3Repository) FolderExists(ctx context.Context, folderName string) (bool, error) {
-	// Можно сделать ListObjects или HeadObject с таким префиксом и проверить результат.
-	// Простейший подход — ListObjects с префиксом "photos/" и MaxKeys=1.
-
+	// Достаточно одного ключа: ListObjectsV2 с MaxKeys=1.
 	resp, err := r.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-		Bucket: aws.String(r.BucketName),
-		Prefix: aws.String(folderName),
-		//MaxKeys: 1,
+		Bucket:  aws.String(r.BucketName),
+		Prefix:  aws.String(folderName),
+		MaxKeys: aws.Int32(1),
 	})
 	if err != nil {
 		return false, err
@@ -116,20 +490,49 @@ func (r *S3Repository) FolderExists(ctx context.Context, folderName string) (boo
 	return len(resp.Contents) > 0, nil
 }
 
-// ListAllFiles возвращает все объекты из S3 бакета, проходя по всем страницам.
-func (r *S3Repository) ListAllFiles(ctx context.Context) ([]types.Object, error) {
-	var objects []types.Object
-	paginator := s3.NewListObjectsV2Paginator(r.Client, &s3.ListObjectsV2Input{
-		Bucket: aws.String(r.BucketName),
-	})
+// FileURL возвращает публичный URL объекта в бакете (или на CDN).
+func (r *S3Repository) FileURL(key string) string {
+	return r.URLs.FileURL(key)
+}
 
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("ошибка при получении страницы: %w", err)
+// toObjectInfos переводит объекты SDK в ObjectInfo.
+func toObjectInfos(objects []types.Object) []ObjectInfo {
+	infos := make([]ObjectInfo, 0, len(objects))
+	for _, obj := range objects {
+		if obj.Key == nil {
+			continue
 		}
-		objects = append(objects, page.Contents...)
+		infos = append(infos, ObjectInfo{
+			Key:          *obj.Key,
+			Size:         aws.ToInt64(obj.Size),
+			LastModified: aws.ToTime(obj.LastModified),
+			ETag:         aws.ToString(obj.ETag),
+			StorageClass: string(obj.StorageClass),
+		})
+	}
+	return infos
+}
+
+// mapS3Error приводит ошибки «объект не найден» и «загрузка не найдена» к ErrNotFound.
+func mapS3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NotFound", "NoSuchKey", "NoSuchUpload":
+			return fmt.Errorf("%w: %s", ErrNotFound, apiErr.ErrorMessage())
+		}
+	}
+	return err
+}
+
+// copySource формирует значение CopySource: "bucket/key" с экранированием сегментов ключа.
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
 	}
-	fmt.Println(objects)
-	return objects, nil
+	return bucket + "/" + strings.Join(segments, "/")
 }
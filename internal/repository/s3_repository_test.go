@@ -0,0 +1,394 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeS3 — минимальный S3 API (path-style) для проверки листинга и удаления: ListObjectsV2
+// с max-keys и continuation-token, DeleteObject и DeleteObjects. Запоминает max-keys каждого
+// запроса листинга, размеры запросов DeleteObjects и наибольшее число одновременных удалений.
+// Ключи из denied не удаляются (AccessDenied); удаление отсутствующего ключа отвечает NoSuchKey,
+// как некоторые S3-совместимые хранилища.
+type fakeS3 struct {
+	mu          sync.Mutex
+	keys        []string
+	denied      map[string]bool
+	maxKeys     []int
+	deleteSizes []int
+	requests    int
+
+	inflight, peak atomic.Int32
+}
+
+type fakeDeleteRequest struct {
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type fakeDeleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type fakeDeleteResult struct {
+	XMLName xml.Name          `xml:"DeleteResult"`
+	Errors  []fakeDeleteError `xml:"Error"`
+}
+
+type fakeListResult struct {
+	XMLName               xml.Name     `xml:"ListBucketResult"`
+	Name                  string       `xml:"Name"`
+	Prefix                string       `xml:"Prefix"`
+	KeyCount              int          `xml:"KeyCount"`
+	MaxKeys               int          `xml:"MaxKeys"`
+	IsTruncated           bool         `xml:"IsTruncated"`
+	NextContinuationToken string       `xml:"NextContinuationToken,omitempty"`
+	Contents              []fakeObject `xml:"Contents"`
+	CommonPrefixes        []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+type fakeObject struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && r.URL.Query().Has("delete") {
+		// Держим запрос, чтобы параллельные удаления пересеклись по времени
+		n := f.inflight.Add(1)
+		defer f.inflight.Add(-1)
+		for peak := f.peak.Load(); n > peak && !f.peak.CompareAndSwap(peak, n); peak = f.peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		query := r.URL.Query()
+		maxKeys, err := strconv.Atoi(query.Get("max-keys"))
+		if err != nil || maxKeys <= 0 || maxKeys > 1000 {
+			maxKeys = 1000
+		}
+		f.maxKeys = append(f.maxKeys, maxKeys)
+
+		result := fakeListResult{Name: bucket, Prefix: query.Get("prefix"), MaxKeys: maxKeys}
+		token, delimiter := query.Get("continuation-token"), query.Get("delimiter")
+		last := ""
+		for _, k := range f.keys {
+			if !strings.HasPrefix(k, result.Prefix) || k <= token ||
+				(delimiter != "" && strings.HasSuffix(token, delimiter) && strings.HasPrefix(k, token)) {
+				continue
+			}
+			// С разделителем ключи «подпапки» сворачиваются в один общий префикс
+			common := ""
+			if i := strings.Index(k[len(result.Prefix):], delimiter); delimiter != "" && i >= 0 {
+				common = k[:len(result.Prefix)+i+len(delimiter)]
+				if common == last {
+					continue
+				}
+			}
+			if result.KeyCount == maxKeys {
+				result.IsTruncated = true
+				result.NextContinuationToken = last
+				break
+			}
+			if common != "" {
+				result.CommonPrefixes = append(result.CommonPrefixes, struct {
+					Prefix string `xml:"Prefix"`
+				}{Prefix: common})
+				last = common
+			} else {
+				result.Contents = append(result.Contents, fakeObject{Key: k, Size: 1})
+				last = k
+			}
+			result.KeyCount++
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodDelete && key != "":
+		if !slices.Contains(f.keys, key) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Key>%s</Key></Error>", key)
+			return
+		}
+		f.keys = slices.DeleteFunc(f.keys, func(k string) bool { return k == key })
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		var req fakeDeleteRequest
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.deleteSizes = append(f.deleteSizes, len(req.Objects))
+		var result fakeDeleteResult
+		remove := make(map[string]bool)
+		for _, obj := range req.Objects {
+			if f.denied[obj.Key] {
+				result.Errors = append(result.Errors, fakeDeleteError{Key: obj.Key, Code: "AccessDenied", Message: "Access Denied"})
+				continue
+			}
+			remove[obj.Key] = true
+		}
+		f.keys = slices.DeleteFunc(f.keys, func(k string) bool { return remove[k] })
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(result)
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
+
+// newFakeS3 запускает fakeS3 с ключами keys и возвращает репозиторий, настроенный на него.
+func newFakeS3(t *testing.T, keys []string) (*fakeS3, *S3Repository) {
+	t.Helper()
+
+	fake := &fakeS3{keys: slices.Sorted(slices.Values(keys))}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, NewS3Repository(S3Config{
+		BucketName:    "bucket",
+		AccessKey:     "access",
+		SecretKey:     "secret",
+		Endpoint:      server.URL,
+		Region:        "ru-1",
+		UsePathStyle:  true,
+		PublicBaseURL: "http://cdn.test",
+	})
+}
+
+func TestS3ListingIsPaginated(t *testing.T) {
+	var keys []string
+	for i := range 2500 {
+		keys = append(keys, fmt.Sprintf("photos/s3-many/%05d.png", i))
+	}
+	keys = append(keys, "photos/s3-other/a.png")
+	fake, s3Repo := newFakeS3(t, keys)
+	ctx := context.Background()
+
+	var sizes []int
+	err := s3Repo.ListPages(ctx, "photos/s3-many/", func(page []ObjectInfo) error {
+		sizes = append(sizes, len(page))
+		return nil
+	})
+	if err != nil || fmt.Sprint(sizes) != "[1000 1000 500]" {
+		t.Fatalf("expected pages [1000 1000 500], got %v, %v", sizes, err)
+	}
+
+	fake.maxKeys = nil
+	if exists, err := s3Repo.FolderExists(ctx, "photos/s3-other/"); err != nil || !exists {
+		t.Fatalf("folder must exist: %v, %v", exists, err)
+	}
+	if fmt.Sprint(fake.maxKeys) != "[1]" {
+		t.Fatalf("FolderExists must request a single key, got max-keys %v", fake.maxKeys)
+	}
+
+	// Отмена контекста останавливает обход после текущей страницы
+	cancelCtx, cancel := context.WithCancel(ctx)
+	fake.requests = 0
+	err = s3Repo.ListPages(cancelCtx, "photos/s3-many/", func([]ObjectInfo) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || fake.requests != 1 {
+		t.Fatalf("expected context.Canceled after one request, got %v after %d", err, fake.requests)
+	}
+
+	// Объекты страницы можно удалять прямо внутри fn
+	deleted := 0
+	err = s3Repo.ListPages(ctx, "photos/s3-many/", func(page []ObjectInfo) error {
+		keys := make([]string, len(page))
+		for i, obj := range page {
+			keys[i] = obj.Key
+		}
+		deleted += len(keys)
+		return s3Repo.DeleteFilesBatch(ctx, keys)
+	})
+	if err != nil || deleted != 2500 {
+		t.Fatalf("expected 2500 deleted keys, got %d, %v", deleted, err)
+	}
+	if len(fake.keys) != 1 || fake.keys[0] != "photos/s3-other/a.png" {
+		t.Fatalf("expected only the other folder to remain, got %d keys", len(fake.keys))
+	}
+}
+
+func TestS3DeleteFilesBatch(t *testing.T) {
+	var keys []string
+	for i := range 2500 {
+		keys = append(keys, fmt.Sprintf("photos/s3-batch/%05d.png", i))
+	}
+	fake, s3Repo := newFakeS3(t, keys)
+	fake.denied = map[string]bool{keys[1500]: true, keys[10]: true}
+	s3Repo.DeleteConcurrency = 2
+
+	err := s3Repo.DeleteFilesBatch(context.Background(), keys)
+	var partial *DeleteFilesError
+	if !errors.As(err, &partial) || len(partial.Failed) != 2 ||
+		partial.Failed[0].Key != keys[10] || partial.Failed[1].Key != keys[1500] ||
+		!strings.Contains(partial.Failed[0].Reason, "AccessDenied") {
+		t.Fatalf("expected two denied keys in DeleteFilesError, got %v", err)
+	}
+	if len(fake.keys) != 2 {
+		t.Fatalf("only denied keys must remain, got %d", len(fake.keys))
+	}
+	slices.Sort(fake.deleteSizes)
+	if fmt.Sprint(fake.deleteSizes) != "[500 1000 1000]" {
+		t.Fatalf("expected DeleteObjects batches of up to 1000 keys, got %v", fake.deleteSizes)
+	}
+	if peak := fake.peak.Load(); peak != 2 {
+		t.Fatalf("expected 2 concurrent DeleteObjects requests, got %d", peak)
+	}
+}
+
+func TestS3DeleteFileMapsNotFound(t *testing.T) {
+	fake, s3Repo := newFakeS3(t, []string{"photos/s3-delete/a.png"})
+	if err := s3Repo.DeleteFile(context.Background(), "photos/s3-delete/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.keys) != 0 {
+		t.Fatalf("expected the key to be deleted, got %v", fake.keys)
+	}
+	if err := s3Repo.DeleteFile(context.Background(), "photos/s3-delete/a.png"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing key, got %v", err)
+	}
+}
+
+func TestS3ListPageUsesContinuationToken(t *testing.T) {
+	var keys []string
+	for i := range 30 {
+		keys = append(keys, fmt.Sprintf("photos/s3-page/%02d.png", i))
+	}
+	fake, s3Repo := newFakeS3(t, keys)
+	ctx := context.Background()
+
+	first, err := s3Repo.ListPage(ctx, ListPageOptions{Prefix: "photos/s3-page/", Limit: 10})
+	if err != nil || len(first.Objects) != 10 || first.NextToken == "" {
+		t.Fatalf("unexpected first page %+v, %v", first, err)
+	}
+	second, err := s3Repo.ListPage(ctx, ListPageOptions{Prefix: "photos/s3-page/", Limit: 10, Token: first.NextToken})
+	if err != nil || len(second.Objects) != 10 || second.Objects[0].Key != keys[10] {
+		t.Fatalf("unexpected second page %+v, %v", second, err)
+	}
+	if fmt.Sprint(fake.maxKeys) != "[10 10]" {
+		t.Fatalf("expected one ListObjectsV2 request of 10 keys per page, got %v", fake.maxKeys)
+	}
+}
+
+func TestS3ListPageUsesDelimiter(t *testing.T) {
+	_, s3Repo := newFakeS3(t, []string{
+		"photos/1/a.png", "photos/1/b.png", "photos/1/b_thumb.png", "photos/2/c.png", "photos/root.png",
+	})
+	ctx := context.Background()
+
+	first, err := s3Repo.ListPage(ctx, ListPageOptions{Prefix: "photos/", Limit: 2, Delimiter: "/"})
+	if err != nil || fmt.Sprint(first.CommonPrefixes) != "[photos/1/ photos/2/]" ||
+		len(first.Objects) != 0 || first.NextToken == "" {
+		t.Fatalf("unexpected first page %+v, %v", first, err)
+	}
+	second, err := s3Repo.ListPage(ctx, ListPageOptions{Prefix: "photos/", Limit: 2, Delimiter: "/", Token: first.NextToken})
+	if err != nil || len(second.CommonPrefixes) != 0 || len(second.Objects) != 1 ||
+		second.Objects[0].Key != "photos/root.png" || second.NextToken != "" {
+		t.Fatalf("unexpected second page %+v, %v", second, err)
+	}
+}
+
+func TestS3PresignGetURL(t *testing.T) {
+	s3Repo := NewS3Repository(S3Config{
+		BucketName: "bucket",
+		AccessKey:  "access",
+		SecretKey:  "secret",
+		Endpoint:   "https://s3.example.test",
+		Region:     "ru-1",
+	})
+	presigned, err := s3Repo.PresignGetURL(context.Background(), "photos/1/a.png", PresignOptions{
+		Expires:            10 * time.Minute,
+		ContentDisposition: "attachment",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := url.Parse(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := signed.Query()
+	if signed.Host != "bucket.s3.example.test" || signed.Path != "/photos/1/a.png" ||
+		query.Get("X-Amz-Expires") != "600" || query.Get("X-Amz-Signature") == "" ||
+		query.Get("response-content-disposition") != "attachment" {
+		t.Fatalf("unexpected presigned URL %q", presigned)
+	}
+}
+
+func TestS3PresignUpload(t *testing.T) {
+	s3Repo := NewS3Repository(S3Config{
+		BucketName: "bucket",
+		AccessKey:  "access",
+		SecretKey:  "secret",
+		Endpoint:   "https://s3.example.test",
+		Region:     "ru-1",
+	})
+	ctx := context.Background()
+
+	put, err := s3Repo.PresignUpload(ctx, "photos/1/a.png", PresignUploadOptions{
+		Method:      UploadMethodPut,
+		Expires:     time.Minute,
+		ContentType: "image/png",
+		Size:        100,
+		Visibility:  VisibilityPublic,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := url.Parse(put.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers := signed.Query().Get("X-Amz-SignedHeaders"); !strings.Contains(headers, "content-length") ||
+		!strings.Contains(headers, "content-type") || !strings.Contains(headers, "x-amz-acl") ||
+		put.Headers["x-amz-acl"] != "public-read" {
+		t.Fatalf("unexpected presigned PUT %+v", put)
+	}
+
+	post, err := s3Repo.PresignUpload(ctx, "photos/1/b.png", PresignUploadOptions{
+		Method:      UploadMethodPost,
+		Expires:     time.Minute,
+		ContentType: "image/png",
+		MaxSize:     1000,
+		Visibility:  VisibilityPrivate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := base64.StdEncoding.DecodeString(post.Fields["policy"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Fields["key"] != "photos/1/b.png" || post.Fields["Content-Type"] != "image/png" || post.Fields["acl"] != "" ||
+		!bytes.Contains(policy, []byte(`["content-length-range",1,1000]`)) ||
+		!bytes.Contains(policy, []byte(`{"Content-Type":"image/png"}`)) {
+		t.Fatalf("unexpected POST policy %s (%+v)", policy, post.Fields)
+	}
+}
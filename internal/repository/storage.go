@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNotFound возвращается бэкендом, если объекта с таким ключом нет.
+var ErrNotFound = errors.New("объект не найден")
+
+// ListPageSize — наибольшее число объектов на странице ListPages (предел ListObjectsV2).
+const ListPageSize = 1000
+
+// ListPageOptions — параметры одной страницы листинга (ListPage).
+type ListPageOptions struct {
+	Prefix string
+	// Limit — наибольшее число объектов на странице: от 1 до ListPageSize (иначе ListPageSize).
+	Limit int
+	// Token — NextToken предыдущей страницы; пусто — с начала.
+	Token string
+	// Delimiter — разделитель «папок» (обычно "/"). Ключи, в которых после Prefix встречается
+	// разделитель, не возвращаются, а сворачиваются в CommonPrefixes; каждый такой префикс
+	// занимает на странице одно место, как объект.
+	Delimiter string
+}
+
+// ObjectPage — страница листинга.
+type ObjectPage struct {
+	Objects []ObjectInfo
+	// CommonPrefixes — «подпапки» вида Prefix + имя + Delimiter (только при Delimiter).
+	CommonPrefixes []string
+	// NextToken — токен продолжения; пусто, если страница последняя.
+	NextToken string
+}
+
+// MaxDeleteKeys — наибольшее число ключей в одном запросе DeleteObjects.
+const MaxDeleteKeys = 1000
+
+// DeleteFailure — ключ, который не удалось удалить, и причина.
+type DeleteFailure struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// DeleteFilesError возвращается DeleteFilesBatch, если часть ключей удалить не удалось.
+// Остальные ключи при этом удалены.
+type DeleteFilesError struct {
+	Failed []DeleteFailure
+}
+
+func (e *DeleteFilesError) Error() string {
+	first := e.Failed[0]
+	if len(e.Failed) == 1 {
+		return fmt.Sprintf("не удалось удалить %s: %s", first.Key, first.Reason)
+	}
+	return fmt.Sprintf("не удалось удалить %d объектов, например %s: %s", len(e.Failed), first.Key, first.Reason)
+}
+
+// deleteFilesError собирает DeleteFilesError, отсортированный по ключам; без неудач — nil.
+func deleteFilesError(failed []DeleteFailure) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	slices.SortFunc(failed, func(a, b DeleteFailure) int { return strings.Compare(a.Key, b.Key) })
+	return &DeleteFilesError{Failed: failed}
+}
+
+// ObjectInfo — описание объекта в хранилище, не зависящее от конкретного бэкенда.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	ETag         string
+	StorageClass string
+}
+
+// Storage — интерфейс хранилища объектов, с которым работает S3Service.
+// Ключи всегда в формате "photos/:id/uuid.ext" (разделитель — "/").
+type Storage interface {
+	// UploadFile сохраняет объект с заданной видимостью и возвращает его URL.
+	// URL приватного объекта без подписи не открывается — для чтения нужен PresignGetURL.
+	UploadFile(ctx context.Context, key, contentType string, body io.Reader, visibility Visibility) (string, error)
+	// GetFile открывает объект на чтение. Вызывающий обязан закрыть reader.
+	GetFile(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// GetFileRange открывает на чтение часть объекта: length байт начиная с offset
+	// (length < 0 — до конца объекта). Вызывающий обязан закрыть reader.
+	GetFileRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// HeadFile возвращает метаданные объекта без тела.
+	HeadFile(ctx context.Context, key string) (*ObjectInfo, error)
+	// ListPages обходит объекты с префиксом prefix в порядке ключей страницами не больше
+	// ListPageSize и вызывает fn для каждой. Обход останавливается, если fn вернула ошибку
+	// (она и возвращается) или отменён ctx. Внутри fn можно удалять объекты страницы.
+	ListPages(ctx context.Context, prefix string, fn func(page []ObjectInfo) error) error
+	// ListPage возвращает одну страницу объектов с префиксом opts.Prefix в порядке ключей,
+	// начиная с opts.Token. Токен непрозрачен и действует только для того же префикса.
+	ListPage(ctx context.Context, opts ListPageOptions) (*ObjectPage, error)
+	// FolderExists проверяет, есть ли хотя бы один объект с префиксом folderName.
+	FolderExists(ctx context.Context, folderName string) (bool, error)
+	// DeleteFile удаляет один объект.
+	DeleteFile(ctx context.Context, key string) error
+	// DeleteFilesBatch удаляет группу объектов. Ошибка по одному ключу не прерывает удаление
+	// остальных: такие ключи возвращаются в *DeleteFilesError.
+	DeleteFilesBatch(ctx context.Context, keys []string) error
+	// CopyFile копирует объект srcKey в dstKey внутри хранилища.
+	CopyFile(ctx context.Context, srcKey, dstKey string, visibility Visibility) error
+	// FileURL строит публичный URL объекта по ключу.
+	FileURL(key string) string
+	// PresignGetURL возвращает ссылку на чтение объекта (в том числе приватного),
+	// действующую opts.Expires.
+	PresignGetURL(ctx context.Context, key string, opts PresignOptions) (string, error)
+	// PresignUpload подписывает загрузку объекта key клиентом напрямую в хранилище (PUT или POST).
+	// Бэкенды без такой возможности возвращают ErrNotSupported.
+	PresignUpload(ctx context.Context, key string, opts PresignUploadOptions) (*PresignedUpload, error)
+
+	// CreateMultipartUpload начинает загрузку объекта key по частям и возвращает её идентификатор.
+	CreateMultipartUpload(ctx context.Context, key, contentType string, visibility Visibility) (string, error)
+	// UploadPart сохраняет часть number (с 1) размером size и возвращает её ETag.
+	// Все части, кроме последней, должны быть не меньше MinPartSize.
+	UploadPart(ctx context.Context, key, uploadID string, number int32, body io.Reader, size int64) (string, error)
+	// CompleteMultipartUpload собирает объект из частей в порядке возрастания номеров.
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	// AbortMultipartUpload отменяет загрузку и удаляет сохранённые части.
+	// Если загрузки нет, возвращается ErrNotFound.
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+// pageAfter вырезает из отсортированного списка страницу для ListPage бэкендов, которые читают
+// список целиком. Токеном служит последний ключ или общий префикс предыдущей страницы.
+func pageAfter(objects []ObjectInfo, opts ListPageOptions) *ObjectPage {
+	limit := opts.Limit
+	if limit <= 0 || limit > ListPageSize {
+		limit = ListPageSize
+	}
+
+	// Элементы страницы в порядке ключей: объекты и свёрнутые префиксы. Ключи с общим префиксом
+	// в отсортированном списке идут подряд, поэтому префикс встаёт на место первого из них.
+	type entry struct {
+		name   string
+		object *ObjectInfo
+	}
+	entries := make([]entry, 0, len(objects))
+	for i := range objects {
+		key := objects[i].Key
+		if opts.Delimiter != "" {
+			rest := strings.TrimPrefix(key, opts.Prefix)
+			if idx := strings.Index(rest, opts.Delimiter); idx >= 0 {
+				common := opts.Prefix + rest[:idx+len(opts.Delimiter)]
+				if len(entries) == 0 || entries[len(entries)-1].name != common {
+					entries = append(entries, entry{name: common})
+				}
+				continue
+			}
+		}
+		entries = append(entries, entry{name: key, object: &objects[i]})
+	}
+
+	start, _ := slices.BinarySearchFunc(entries, opts.Token, func(e entry, token string) int {
+		if e.name <= token {
+			return -1
+		}
+		return 1
+	})
+	end := min(start+limit, len(entries))
+	page := &ObjectPage{Objects: make([]ObjectInfo, 0, end-start)}
+	for _, e := range entries[start:end] {
+		if e.object != nil {
+			page.Objects = append(page.Objects, *e.object)
+		} else {
+			page.CommonPrefixes = append(page.CommonPrefixes, e.name)
+		}
+	}
+	if end < len(entries) {
+		page.NextToken = entries[end-1].name
+	}
+	return page
+}
+
+// pageObjects отдаёт уже полученный список в fn страницами по ListPageSize — для бэкендов,
+// которые читают список целиком (память, файловая система).
+func pageObjects(ctx context.Context, objects []ObjectInfo, fn func(page []ObjectInfo) error) error {
+	for len(objects) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := min(len(objects), ListPageSize)
+		if err := fn(objects[:n:n]); err != nil {
+			return err
+		}
+		objects = objects[n:]
+	}
+	return nil
+}
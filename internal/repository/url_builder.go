@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// URLBuilder строит публичные URL объектов от одного базового адреса
+// (домен бакета, CDN или локальный путь вроде "/storage").
+type URLBuilder struct {
+	base string
+}
+
+// NewURLBuilder создаёт построитель URL. Завершающий слэш у base отбрасывается.
+func NewURLBuilder(base string) URLBuilder {
+	return URLBuilder{base: strings.TrimRight(base, "/")}
+}
+
+// FileURL возвращает URL объекта. Сегменты ключа экранируются, слэши сохраняются.
+func (b URLBuilder) FileURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return b.base + "/" + strings.Join(segments, "/")
+}
+
+// Base возвращает базовый адрес без завершающего слэша.
+func (b URLBuilder) Base() string {
+	return b.base
+}
+
+// DefaultS3PublicBaseURL вычисляет публичный адрес бакета по endpoint.
+// Для virtual-hosted адресации это scheme://bucket.host, для path-style — endpoint/bucket.
+// Пустой endpoint означает AWS S3 в указанном регионе.
+func DefaultS3PublicBaseURL(endpoint, region, bucket string, usePathStyle bool) (string, error) {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("некорректный S3 endpoint %q", endpoint)
+	}
+	if usePathStyle {
+		return strings.TrimRight(endpoint, "/") + "/" + bucket, nil
+	}
+	return fmt.Sprintf("%s://%s.%s%s", u.Scheme, bucket, u.Host, strings.TrimRight(u.Path, "/")), nil
+}
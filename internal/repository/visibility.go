@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Visibility — доступность объекта по публичному URL.
+type Visibility string
+
+const (
+	// VisibilityPublic — объект доступен всем по FileURL (ACL public-read в S3).
+	VisibilityPublic Visibility = "public"
+	// VisibilityPrivate — объект доступен только по подписанной ссылке (PresignGetURL).
+	VisibilityPrivate Visibility = "private"
+)
+
+// ParseVisibility разбирает значение "public" или "private".
+func ParseVisibility(value string) (Visibility, error) {
+	switch v := Visibility(value); v {
+	case VisibilityPublic, VisibilityPrivate:
+		return v, nil
+	default:
+		return "", fmt.Errorf("неизвестная видимость %q", value)
+	}
+}
+
+// PresignOptions — параметры подписанной ссылки на чтение объекта.
+type PresignOptions struct {
+	// Expires — срок действия ссылки.
+	Expires time.Duration
+	// ContentDisposition переопределяет заголовок Content-Disposition ответа (пусто — без изменений).
+	ContentDisposition string
+}
+
+// Способы прямой загрузки в хранилище.
+const (
+	// UploadMethodPut — один PUT на подписанный URL с заданными заголовками.
+	UploadMethodPut = "PUT"
+	// UploadMethodPost — HTML-форма (multipart/form-data) с полями подписанной POST-политики.
+	UploadMethodPost = "POST"
+)
+
+// PresignUploadOptions — параметры подписанной загрузки объекта клиентом напрямую в хранилище.
+type PresignUploadOptions struct {
+	// Method — UploadMethodPut или UploadMethodPost.
+	Method string
+	// Expires — срок действия подписи.
+	Expires     time.Duration
+	ContentType string
+	// Size — точный размер файла для PUT (подписывается Content-Length).
+	Size int64
+	// MaxSize — верхняя граница content-length-range для POST.
+	MaxSize    int64
+	Visibility Visibility
+}
+
+// PresignedUpload — всё, что нужно клиенту для загрузки. Для PUT заголовки Headers обязательны
+// (они входят в подпись); для POST поля Fields передаются в форме перед полем file.
+type PresignedUpload struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// ErrNotSupported — бэкенд не поддерживает операцию.
+var ErrNotSupported = errors.New("операция не поддерживается хранилищем")
+
+// ErrInvalidSignature — подписанная ссылка повреждена или истекла.
+var ErrInvalidSignature = errors.New("недействительная или просроченная ссылка")
+
+// URLSigner подписывает ссылки на приватные объекты для бэкендов, у которых нет собственных
+// presigned URL (локальный диск, память). Подпись — HMAC-SHA256 от ключа, срока и Content-Disposition.
+type URLSigner struct {
+	key []byte
+}
+
+// NewURLSigner — конструктор. С пустым ключом генерируется случайный: выданные ссылки
+// перестают действовать после перезапуска.
+func NewURLSigner(key string) URLSigner {
+	if key != "" {
+		return URLSigner{key: []byte(key)}
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	return URLSigner{key: random}
+}
+
+// Sign добавляет к fileURL параметры expires, disposition и signature.
+func (s URLSigner) Sign(fileURL, key string, opts PresignOptions, now time.Time) string {
+	expires := strconv.FormatInt(now.Add(opts.Expires).Unix(), 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {s.signature(key, expires, opts.ContentDisposition)},
+	}
+	if opts.ContentDisposition != "" {
+		query.Set("disposition", opts.ContentDisposition)
+	}
+	return fileURL + "?" + query.Encode()
+}
+
+// Verify проверяет подпись и срок действия ссылки на объект key.
+func (s URLSigner) Verify(key string, query url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return ErrInvalidSignature
+	}
+	expected := s.signature(key, query.Get("expires"), query.Get("disposition"))
+	if !hmac.Equal([]byte(query.Get("signature")), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s URLSigner) signature(key, expires, disposition string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(key + "\n" + expires + "\n" + disposition))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"files/internal/api/handlers"
+	"files/internal/api/middlewares"
+	"files/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+func ImageRoutes(r *gin.RouterGroup, imageHandlers *handlers.ImageHandlers) {
+	// Трансформация изображения «на лету»: только пресеты или подписанные параметры.
+	// Видимость загрузок определяет, могут ли результат хранить общие кэши
+	r.GET("/img/:id/:uuid", owned(services.ScopeRead,
+		middlewares.VisibilityMiddleware(uploadVisibility()),
+		imageHandlers.TransformHandler,
+	)...)
+}
@@ -1,25 +1,191 @@
 package routes
 
 import (
+	"strings"
+	"time"
+
+	"files/configs/env"
 	"files/internal/api/handlers"
 	"files/internal/api/middlewares"
+	"files/internal/api/middlewares/auth"
+	"files/internal/repository"
+	"files/internal/services"
+	"files/pkg/imaging"
+	"files/pkg/log"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
-func S3Routes(r *gin.RouterGroup, s3Handlers *handlers.S3Handlers) {
-	r.POST("/upload/:id",
-		middlewares.LimitRequestSizeMiddleware(50<<20),
-		middlewares.CheckExtensionsMiddleware([]string{".png", ".jpg", ".jpeg", ".gif", ".webp"}),
+// reservedIDs — первые сегменты статических маршрутов /files. Папку с таким :id нельзя отдать
+// по GET /files/:id/:uuid, поэтому загрузка в неё отклоняется; скачать её файлы, созданные раньше,
+// можно через /files/download/:id/:uuid.
+var reservedIDs = []string{"upload", "objects", "download", "presign", "img", "tus"}
+
+// maxUploadSize — наибольший размер загрузки: всего multipart-запроса или одного файла,
+// загружаемого напрямую в хранилище или по протоколу tus.
+const maxUploadSize = 50 << 20
+
+func S3Routes(r *gin.RouterGroup, s3Handlers *handlers.S3Handlers, limits imaging.Limits) {
+	// Правила приёма файлов общие для загрузки через сервис и напрямую в хранилище
+	uploads := r.Group("/upload/:id", owned(services.ScopeWrite, uploadPolicy(limits)...)...)
+	uploads.POST("",
+		middlewares.LimitRequestSizeMiddleware(maxUploadSize),
 		s3Handlers.UploadMultipleHandler,
 	)
 
-	r.DELETE("/upload/:id", s3Handlers.DeleteAllByIDHandler)
+	// Загрузка напрямую в бакет: подписанный PUT или POST-политика, затем подтверждение
+	uploads.POST("/presign",
+		middlewares.LimitRequestSizeMiddleware(1<<20),
+		middlewares.PresignPolicyMiddleware(presignPolicy()),
+		s3Handlers.PresignUploadHandler,
+	)
+	uploads.POST("/confirm",
+		middlewares.LimitRequestSizeMiddleware(1<<20),
+		s3Handlers.ConfirmUploadHandler,
+	)
+
+	r.DELETE("/upload/:id", owned(services.ScopeDelete, s3Handlers.DeleteAllByIDHandler)...)
+
+	r.DELETE("/upload/:id/:uuid", owned(services.ScopeDelete, s3Handlers.DeleteOneByUUIDHandler)...)
 
-	r.DELETE("/upload/:id/:uuid", s3Handlers.DeleteOneByUUIDHandler)
+	// Новый маршрут для получения списка всех файлов; без prefix=photos/:id/ (весь бакет) — только с files:admin
+	r.GET("/objects", guarded(services.ScopeRead, auth.FolderQuery("prefix", false),
+		middlewares.ListFormatMiddleware(listFormat()),
+		s3Handlers.ListAllFilesHandler,
+	)...)
 
-	// Новый маршрут для получения списка всех файлов
-	r.GET("/objects", s3Handlers.ListAllFilesHandler)
+	// Просмотр «папок»: подпапки с числом файлов и объёмом и файлы первого уровня
+	r.GET("/objects/browse", guarded(services.ScopeRead, auth.FolderQuery("prefix", true), s3Handlers.BrowseHandler)...)
 
 	// Новый маршрут для проверки существования папки в S3
-	r.GET("/objects/exists", s3Handlers.FolderExistsHandler)
+	r.GET("/objects/exists", guarded(services.ScopeRead, auth.FolderQuery("folder", true),
+		middlewares.ListFormatMiddleware(listFormat()),
+		s3Handlers.FolderExistsHandler,
+	)...)
+
+	// Скачивание оригинала через сервис (Range, условные запросы, HEAD). Статические маршруты
+	// имеют приоритет над :id, поэтому папки из reservedIDs доступны только через /download/:id/:uuid
+	r.GET("/:id/:uuid", owned(services.ScopeRead, s3Handlers.DownloadHandler)...)
+	r.HEAD("/:id/:uuid", owned(services.ScopeRead, s3Handlers.DownloadHandler)...)
+	r.GET("/download/:id/:uuid", owned(services.ScopeRead, s3Handlers.DownloadHandler)...)
+	r.HEAD("/download/:id/:uuid", owned(services.ScopeRead, s3Handlers.DownloadHandler)...)
+
+	// Временная ссылка на файл, в том числе приватный
+	r.GET("/presign/:id/:uuid", owned(services.ScopeRead,
+		middlewares.PresignPolicyMiddleware(presignPolicy()),
+		s3Handlers.PresignHandler,
+	)...)
+}
+
+// guarded добавляет перед handlers проверки разрешения scope и владения папкой resource:
+// пропускаются только владелец папки photos/:id и администратор.
+func guarded(scope string, resource auth.Resource, handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	return append([]gin.HandlerFunc{
+		auth.RequireScope(scope),
+		auth.OwnershipMiddleware(resource),
+	}, handlers...)
+}
+
+// owned — guarded для маршрутов с :id в пути.
+func owned(scope string, handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	return guarded(scope, auth.PathParam("id"), handlers...)
+}
+
+// uploadPolicy — правила приёма файлов, общие для всех способов загрузки:
+// через сервис, напрямую в хранилище и по протоколу tus. limits — допустимые размеры изображений.
+func uploadPolicy(limits imaging.Limits) []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middlewares.ReservedIDsMiddleware(reservedIDs),
+		middlewares.CheckExtensionsMiddleware([]string{".png", ".jpg", ".jpeg", ".gif", ".webp"}),
+		middlewares.ValidateImagesMiddleware(limits),
+		middlewares.ImageVariantsMiddleware(imageVariants()),
+		middlewares.ConvertToWebPMiddleware(webpConversion()),
+		middlewares.StripMetadataMiddleware(privacyMode()),
+		middlewares.VisibilityMiddleware(uploadVisibility()),
+		middlewares.MaxFileSizeMiddleware(maxUploadSize),
+	}
+}
+
+// imageVariants читает набор уменьшенных копий из IMAGE_VARIANTS ("thumb:200,preview:800").
+// Пустое значение отключает создание вариантов.
+func imageVariants() []imaging.Variant {
+	variants, err := imaging.ParseVariants(env.GetEnv("IMAGE_VARIANTS", "thumb:200,preview:800"))
+	if err != nil {
+		log.Fatal("Invalid IMAGE_VARIANTS", zap.Error(err))
+	}
+	return variants
+}
+
+// webpConversion читает настройки конвертации в WebP: IMAGE_WEBP_CONVERT включает её,
+// IMAGE_WEBP_QUALITY задаёт качество, IMAGE_WEBP_KEEP_ORIGINAL сохраняет исходный файл.
+func webpConversion() *services.WebPConversion {
+	if !env.GetEnvBool("IMAGE_WEBP_CONVERT", false) {
+		return nil
+	}
+	if !imaging.WebPSupported {
+		log.Fatal("IMAGE_WEBP_CONVERT requires a build with CGO_ENABLED=1", zap.Error(imaging.ErrWebPUnsupported))
+	}
+	quality := env.GetEnvInt("IMAGE_WEBP_QUALITY", 80)
+	if quality < 1 || quality > 100 {
+		log.Fatal("Invalid IMAGE_WEBP_QUALITY", zap.Int("quality", quality))
+	}
+	return &services.WebPConversion{
+		Quality:      quality,
+		KeepOriginal: env.GetEnvBool("IMAGE_WEBP_KEEP_ORIGINAL", true),
+	}
+}
+
+// privacyMode читает настройки очистки метаданных: IMAGE_STRIP_METADATA включает её,
+// IMAGE_KEEP_EXIF_TAGS перечисляет сохраняемые теги ("Copyright,Artist").
+func privacyMode() *services.PrivacyMode {
+	if !env.GetEnvBool("IMAGE_STRIP_METADATA", true) {
+		return nil
+	}
+	privacy := &services.PrivacyMode{}
+	for _, name := range strings.Split(env.GetEnv("IMAGE_KEEP_EXIF_TAGS", "Copyright"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		tag, ok := imaging.ExifTagID(name)
+		if !ok {
+			log.Fatal("Invalid IMAGE_KEEP_EXIF_TAGS", zap.String("tag", name))
+		}
+		privacy.KeepTags = append(privacy.KeepTags, tag)
+	}
+	return privacy
+}
+
+// uploadVisibility читает видимость загружаемых файлов из UPLOAD_VISIBILITY: public или private.
+func uploadVisibility() repository.Visibility {
+	visibility, err := repository.ParseVisibility(env.GetEnv("UPLOAD_VISIBILITY", string(repository.VisibilityPublic)))
+	if err != nil {
+		log.Fatal("Invalid UPLOAD_VISIBILITY", zap.Error(err))
+	}
+	return visibility
+}
+
+// listFormat читает формат ответа списков по умолчанию из LIST_FORMAT: objects или urls
+// (прежний массив URL — для клиентов, которые ещё не перешли на объекты).
+func listFormat() string {
+	format := env.GetEnv("LIST_FORMAT", middlewares.ListFormatObjects)
+	if format != middlewares.ListFormatObjects && format != middlewares.ListFormatURLs {
+		log.Fatal("Invalid LIST_FORMAT", zap.String("format", format))
+	}
+	return format
+}
+
+// presignPolicy читает сроки действия подписанных ссылок: PRESIGN_EXPIRY — по умолчанию,
+// PRESIGN_MAX_EXPIRY — наибольший (не больше 7 дней — предел подписи S3).
+func presignPolicy() services.PresignPolicy {
+	policy := services.PresignPolicy{
+		DefaultExpiry: env.GetEnvDuration("PRESIGN_EXPIRY", 15*time.Minute),
+		MaxExpiry:     env.GetEnvDuration("PRESIGN_MAX_EXPIRY", 7*24*time.Hour),
+	}
+	if policy.MaxExpiry <= 0 || policy.MaxExpiry > 7*24*time.Hour ||
+		policy.DefaultExpiry <= 0 || policy.DefaultExpiry > policy.MaxExpiry {
+		log.Fatal("Invalid PRESIGN_EXPIRY or PRESIGN_MAX_EXPIRY",
+			zap.Duration("default", policy.DefaultExpiry), zap.Duration("max", policy.MaxExpiry))
+	}
+	return policy
 }
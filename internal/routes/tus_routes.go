@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"files/internal/api/handlers"
+	"files/internal/api/middlewares"
+	"files/internal/services"
+	"files/pkg/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+func TusRoutes(r *gin.RouterGroup, tusHandlers *handlers.TusHandlers, limits imaging.Limits) {
+	// Возобновляемая загрузка по протоколу tus 1.0: POST создаёт загрузку для :id,
+	// HEAD возвращает смещение, PATCH дописывает данные, DELETE отменяет загрузку
+	tus := r.Group("/tus", middlewares.TusResumableMiddleware(services.TusVersion))
+	tus.OPTIONS("", tusHandlers.OptionsHandler)
+
+	uploads := tus.Group("/:id", owned(services.ScopeWrite, uploadPolicy(limits)...)...)
+	uploads.OPTIONS("", tusHandlers.OptionsHandler)
+	uploads.POST("", tusHandlers.CreateHandler)
+	uploads.HEAD("/:upload", tusHandlers.HeadHandler)
+	uploads.GET("/:upload", tusHandlers.GetHandler)
+	uploads.PATCH("/:upload",
+		middlewares.LimitRequestSizeMiddleware(maxUploadSize),
+		tusHandlers.PatchHandler,
+	)
+	uploads.DELETE("/:upload", tusHandlers.DeleteHandler)
+}
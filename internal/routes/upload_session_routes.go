@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"files/internal/api/handlers"
+	"files/internal/api/middlewares"
+	"files/internal/services"
+	"files/pkg/imaging"
+	"github.com/gin-gonic/gin"
+)
+
+func UploadSessionRoutes(r *gin.RouterGroup, sessionHandlers *handlers.UploadSessionHandlers, limits imaging.Limits) {
+	// Загрузка пронумерованными кусками через JSON API для клиентов без поддержки tus
+	sessions := r.Group("/upload/:id/sessions", owned(services.ScopeWrite, uploadPolicy(limits)...)...)
+	sessions.POST("",
+		middlewares.LimitRequestSizeMiddleware(1<<20),
+		sessionHandlers.CreateHandler,
+	)
+	sessions.GET("/:session", sessionHandlers.GetHandler)
+	sessions.PUT("/:session/chunks/:number",
+		middlewares.LimitRequestSizeMiddleware(maxUploadSize),
+		sessionHandlers.PutChunkHandler,
+	)
+	sessions.POST("/:session/complete", sessionHandlers.CompleteHandler)
+	sessions.DELETE("/:session", sessionHandlers.AbortHandler)
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"files/internal/repository"
+	"files/pkg/imaging"
+	"files/pkg/log"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// maxDirectUploadSize — предел одного PUT/POST в S3, если маршрут не задал свой.
+const maxDirectUploadSize = 5 << 30
+
+// directUploadPrefix — префикс, под который клиент загружает файл напрямую: uploads/direct/:id/uuid.ext.
+// Файл лежит там приватным до подтверждения и попадает в photos/:id только после проверки.
+const directUploadPrefix = "uploads/direct/"
+
+// DirectUploadRequest — описание файла, который клиент загрузит в хранилище сам.
+type DirectUploadRequest struct {
+	FileName string `json:"file_name"`
+	// ContentType — тип файла; по умолчанию определяется по расширению.
+	ContentType string `json:"content_type"`
+	// Size — точный размер файла; обязателен для PUT.
+	Size int64 `json:"size"`
+	// Method — "put" (по умолчанию) или "post".
+	Method string `json:"method"`
+}
+
+// DirectUpload — ключ, выданный сервером, и параметры подписанной загрузки.
+type DirectUpload struct {
+	Key       string    `json:"key"`
+	ExpiresAt time.Time `json:"expires_at"`
+	*repository.PresignedUpload
+}
+
+// PresignDirectUpload — выдаёт подписанную загрузку файла напрямую в хранилище под приватный ключ
+// uploads/direct/:id/uuid.ext. Тип и размер входят в подпись; после загрузки клиент вызывает
+// ConfirmDirectUpload, и только проверенный файл появляется в photos/:id с видимостью маршрута.
+func (s *S3Service) PresignDirectUpload(
+	ctx context.Context,
+	idParam string,
+	req DirectUploadRequest,
+	policy UploadPolicy,
+	expires time.Duration,
+) (DirectUpload, error) {
+	if err := policy.checkFileName(req.FileName); err != nil {
+		return DirectUpload{}, err
+	}
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = imaging.ContentTypeByExtension(path.Ext(req.FileName))
+	}
+	if err := policy.checkContentType(req.FileName, contentType); err != nil {
+		return DirectUpload{}, err
+	}
+
+	maxSize := policy.MaxFileSize
+	if maxSize <= 0 {
+		maxSize = maxDirectUploadSize
+	}
+	opts := repository.PresignUploadOptions{
+		Method:      strings.ToUpper(req.Method),
+		Expires:     expires,
+		ContentType: contentType,
+		Size:        req.Size,
+		MaxSize:     maxSize,
+		// До проверки файл не должен открываться по публичной ссылке
+		Visibility: repository.VisibilityPrivate,
+	}
+	switch opts.Method {
+	case "", repository.UploadMethodPut:
+		opts.Method = repository.UploadMethodPut
+		if req.Size <= 0 || req.Size > maxSize {
+			return DirectUpload{}, &FileRejectedError{
+				FileName: req.FileName,
+				Reason:   fmt.Sprintf("Размер файла должен быть от 1 до %d байт", maxSize),
+			}
+		}
+	case repository.UploadMethodPost:
+	default:
+		return DirectUpload{}, &FileRejectedError{
+			FileName: req.FileName,
+			Reason:   fmt.Sprintf("Неизвестный способ загрузки %q", req.Method),
+		}
+	}
+
+	ext := imaging.ExtensionForContentType(path.Ext(req.FileName), contentType)
+	key := fmt.Sprintf("%s%s/%s%s", directUploadPrefix, idParam, uuid.New().String(), ext)
+	expiresAt := time.Now().Add(expires).UTC().Truncate(time.Second)
+	upload, err := s.repo.PresignUpload(ctx, key, opts)
+	if err != nil {
+		return DirectUpload{}, fmt.Errorf("не удалось подписать загрузку: %w", err)
+	}
+	return DirectUpload{Key: key, ExpiresAt: expiresAt, PresignedUpload: upload}, nil
+}
+
+// ConfirmDirectUpload — проверяет файл, загруженный клиентом по ссылке из PresignDirectUpload
+// (ключ uploads/direct/:id/uuid.ext), и регистрирует его под photos/:id/uuid.ext так же, как UploadMultiple.
+// Загруженный объект удаляется и после успешной проверки, и после отказа; при сбое хранилища он остаётся,
+// и подтверждение можно повторить.
+func (s *S3Service) ConfirmDirectUpload(
+	ctx context.Context,
+	idParam, key string,
+	policy UploadPolicy,
+) (UploadedFile, error) {
+	name, ok := strings.CutPrefix(key, directUploadPrefix+idParam+"/")
+	if !ok {
+		return UploadedFile{}, &FileRejectedError{FileName: key, Reason: "Ключ не относится к загрузкам этого :id"}
+	}
+	return s.confirmUpload(ctx, idParam, key, fmt.Sprintf("photos/%s/%s", idParam, name), policy)
+}
+
+// confirmUpload проверяет файл, уже лежащий в хранилище под stored, и регистрирует его под key
+// (photos/:id/uuid.ext): сверяет размер и тип по содержимому, декодирует изображение, очищает
+// метаданные, создаёт варианты и WebP-копию. Оригинал копируется под key с видимостью маршрута
+// только после проверки; stored == key — файл собран на месте (tus, сессии).
+// Отклонённый файл удаляется из хранилища.
+func (s *S3Service) confirmUpload(
+	ctx context.Context,
+	idParam, stored, key string,
+	policy UploadPolicy,
+) (file UploadedFile, err error) {
+	prefix := fmt.Sprintf("photos/%s", idParam)
+	name := strings.TrimPrefix(key, prefix+"/")
+	ext := path.Ext(name)
+	fileUUID := strings.TrimSuffix(name, ext)
+	if _, parseErr := uuid.Parse(fileUUID); parseErr != nil || name == key || strings.Contains(name, "/") {
+		return file, &FileRejectedError{FileName: stored, Reason: "Ключ не относится к загрузкам этого :id"}
+	}
+	if err := policy.checkFileName(key); err != nil {
+		return file, err
+	}
+
+	info, err := s.repo.HeadFile(ctx, stored)
+	if err != nil {
+		return file, fmt.Errorf("файл %q не загружен: %w", stored, err)
+	}
+	info.Key = stored
+
+	var storedKeys []string
+	var contentType string
+	defer func() {
+		if err == nil {
+			// Загруженный объект больше не нужен, если он не стал оригиналом на месте
+			if stored != key || !policy.keepsOriginal(contentType) {
+				if err := s.repo.DeleteFile(context.WithoutCancel(ctx), stored); err != nil {
+					log.Error("Failed to delete confirmed upload", zap.String("key", stored), zap.Error(err))
+				}
+			}
+			return
+		}
+		// Отклонённый файл удаляется вместе с копиями; при сбое хранилища загруженный объект остаётся,
+		// и подтверждение можно повторить.
+		var keys []string
+		for _, k := range storedKeys {
+			if k != stored {
+				keys = append(keys, k)
+			}
+		}
+		var rejected *FileRejectedError
+		if errors.As(err, &rejected) {
+			keys = append(keys, stored)
+		}
+		if len(keys) > 0 {
+			s.rollback(ctx, keys)
+		}
+	}()
+
+	if policy.MaxFileSize > 0 && info.Size > policy.MaxFileSize {
+		return file, &FileRejectedError{
+			FileName: key,
+			Reason:   fmt.Sprintf("Размер файла %d больше %d байт", info.Size, policy.MaxFileSize),
+		}
+	}
+
+	object, _, err := s.repo.GetFile(ctx, stored)
+	if err != nil {
+		return file, fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	defer object.Close()
+
+	body := bufio.NewReaderSize(object, imaging.SniffLen)
+	head, err := body.Peek(imaging.SniffLen)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return file, fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	contentType = imaging.DetectContentType(head)
+	if err := policy.checkContentType(key, contentType); err != nil {
+		return file, err
+	}
+	if imaging.ExtensionForContentType(ext, contentType) != ext {
+		return file, &FileRejectedError{
+			FileName: key,
+			Reason:   fmt.Sprintf("Содержимое файла (%q) не соответствует расширению %q", contentType, ext),
+		}
+	}
+
+	file, storedKeys, err = s.storePart(ctx, partSource{
+		prefix:      prefix,
+		fileUUID:    fileUUID,
+		key:         key,
+		fileName:    key,
+		contentType: contentType,
+		stored:      info,
+	}, body, policy)
+	return file, err
+}
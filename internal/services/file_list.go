@@ -0,0 +1,300 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"files/internal/repository"
+	"files/pkg/utils"
+)
+
+// Ограничения постраничного списка файлов.
+const (
+	// DefaultListLimit — размер страницы, если limit не задан.
+	DefaultListLimit = 100
+	// maxListScanPages — сколько страниц хранилища просматривается за один запрос, когда фильтры
+	// отбрасывают объекты. Дальше возвращается неполная страница с курсором.
+	maxListScanPages = 10
+)
+
+var (
+	// ErrInvalidCursor — курсор повреждён или выдан для другого префикса.
+	ErrInvalidCursor = errors.New("некорректный курсор")
+	// ErrInvalidListQuery — противоречивые параметры списка.
+	ErrInvalidListQuery = errors.New("некорректные параметры списка")
+)
+
+// ListQuery — параметры GET /objects: фильтры, сортировка внутри страницы и курсор.
+type ListQuery struct {
+	Prefix string `form:"prefix"`
+	// Ext — расширения через запятую: "png,jpg".
+	Ext           string    `form:"ext"`
+	ModifiedSince time.Time `form:"modified_since" time_format:"2006-01-02T15:04:05Z07:00"`
+	MinSize       int64     `form:"min_size" binding:"min=0"`
+	// MaxSize — наибольший размер в байтах; 0 — без ограничения.
+	MaxSize int64 `form:"max_size" binding:"min=0"`
+	// Limit — размер страницы, не больше repository.ListPageSize.
+	Limit  int    `form:"limit" binding:"min=0,max=1000"`
+	Cursor string `form:"cursor"`
+	// Sort — поле сортировки внутри страницы: key (по умолчанию), size или last_modified.
+	Sort  string `form:"sort" binding:"omitempty,oneof=key size last_modified"`
+	Order string `form:"order" binding:"omitempty,oneof=asc desc"`
+	// Count — дополнительно посчитать все подходящие файлы (ещё один проход по листингу).
+	Count bool `form:"count"`
+}
+
+// FilePage — страница списка файлов.
+type FilePage struct {
+	Files []FileObject `json:"files"`
+	// NextCursor — курсор следующей страницы; пусто, если файлов больше нет.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Total и TotalPages заполняются только при Count.
+	Total      *int `json:"total,omitempty"`
+	TotalPages *int `json:"total_pages,omitempty"`
+}
+
+// listCursor — содержимое курсора: токен продолжения хранилища и префикс, для которого он выдан.
+type listCursor struct {
+	Token  string `json:"t"`
+	Prefix string `json:"p"`
+}
+
+// ListFiles — возвращает страницу файлов, подходящих под фильтры query. Страница может быть
+// короче limit и при наличии курсора: за один запрос просматривается не больше maxListScanPages
+// страниц хранилища. Сортировка применяется только внутри страницы.
+func (s *S3Service) ListFiles(ctx context.Context, query ListQuery) (*FilePage, error) {
+	if query.Limit <= 0 {
+		query.Limit = DefaultListLimit
+	}
+	if query.MaxSize > 0 && query.MinSize > query.MaxSize {
+		return nil, fmt.Errorf("%w: min_size больше max_size", ErrInvalidListQuery)
+	}
+	token, err := decodeListCursor(query.Cursor, query.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	match := query.matcher()
+
+	result := &FilePage{Files: make([]FileObject, 0, query.Limit)}
+	for range maxListScanPages {
+		// Запрашиваем ровно столько, сколько не хватает: тогда граница страницы хранилища
+		// совпадает с границей ответа и токен продолжения указывает точно на следующий объект.
+		page, err := s.repo.ListPage(ctx, repository.ListPageOptions{
+			Prefix: query.Prefix,
+			Limit:  query.Limit - len(result.Files),
+			Token:  token,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("не удалось получить список файлов: %w", err)
+		}
+		for _, obj := range page.Objects {
+			if match(obj) {
+				result.Files = append(result.Files, s.fileObject(obj))
+			}
+		}
+		token = page.NextToken
+		if token == "" || len(result.Files) == query.Limit {
+			break
+		}
+	}
+	if token != "" {
+		result.NextCursor = encodeListCursor(token, query.Prefix)
+	}
+	sortFiles(result.Files, query.Sort, query.Order == "desc")
+
+	if query.Count {
+		total := 0
+		err := s.repo.ListPages(ctx, query.Prefix, func(page []repository.ObjectInfo) error {
+			for _, obj := range page {
+				if match(obj) {
+					total++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("не удалось посчитать файлы: %w", err)
+		}
+		totalPages := utils.CalculateTotalPages(total, query.Limit)
+		result.Total, result.TotalPages = &total, &totalPages
+	}
+	return result, nil
+}
+
+// matcher собирает из фильтров запроса проверку объекта.
+func (q ListQuery) matcher() func(repository.ObjectInfo) bool {
+	var exts []string
+	for _, ext := range strings.Split(q.Ext, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			exts = append(exts, "."+ext)
+		}
+	}
+	return func(obj repository.ObjectInfo) bool {
+		if len(exts) > 0 && !slices.Contains(exts, strings.ToLower(path.Ext(obj.Key))) {
+			return false
+		}
+		if !q.ModifiedSince.IsZero() && obj.LastModified.Before(q.ModifiedSince) {
+			return false
+		}
+		return obj.Size >= q.MinSize && (q.MaxSize <= 0 || obj.Size <= q.MaxSize)
+	}
+}
+
+// sortFiles сортирует страницу по key, size или last_modified; равные элементы — по ключу.
+func sortFiles(files []FileObject, field string, desc bool) {
+	slices.SortStableFunc(files, func(a, b FileObject) int {
+		var c int
+		switch field {
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "last_modified":
+			c = a.LastModified.Compare(b.LastModified)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Key, b.Key)
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
+
+func encodeListCursor(token, prefix string) string {
+	data, _ := json.Marshal(listCursor{Token: token, Prefix: prefix})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeListCursor возвращает токен хранилища из курсора; курсор другого префикса не принимается.
+func decodeListCursor(cursor, prefix string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Token == "" {
+		return "", ErrInvalidCursor
+	}
+	if c.Prefix != prefix {
+		return "", fmt.Errorf("%w: курсор выдан для префикса %q", ErrInvalidCursor, c.Prefix)
+	}
+	return c.Token, nil
+}
+
+// browseStatsConcurrency — сколько подпапок Browse обходит одновременно при подсчёте.
+const browseStatsConcurrency = 8
+
+// BrowseQuery — параметры GET /objects/browse.
+type BrowseQuery struct {
+	// Prefix — «папка», например photos/ или photos/123/; пусто — корень хранилища.
+	Prefix string `form:"prefix"`
+	// Limit — сколько подпапок и файлов вместе вернуть на странице, не больше repository.ListPageSize.
+	Limit  int    `form:"limit" binding:"min=0,max=1000"`
+	Cursor string `form:"cursor"`
+}
+
+// FolderEntry — подпапка в выдаче Browse.
+type FolderEntry struct {
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+	// Files и Bytes — число файлов и их общий размер во всей подпапке, включая вложенные.
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// FolderListing — содержимое «папки»: подпапки и файлы первого уровня.
+type FolderListing struct {
+	Prefix     string        `json:"prefix"`
+	Folders    []FolderEntry `json:"folders"`
+	Files      []FileObject  `json:"files"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// Browse — возвращает страницу подпапок (ListObjectsV2 с разделителем "/") и файлов «папки»
+// query.Prefix. Для каждой подпапки на странице считаются число файлов и байты.
+func (s *S3Service) Browse(ctx context.Context, query BrowseQuery) (*FolderListing, error) {
+	prefix := query.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultListLimit
+	}
+	token, err := decodeListCursor(query.Cursor, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := s.repo.ListPage(ctx, repository.ListPageOptions{
+		Prefix:    prefix,
+		Limit:     query.Limit,
+		Token:     token,
+		Delimiter: "/",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить содержимое папки %s: %w", prefix, err)
+	}
+
+	listing := &FolderListing{
+		Prefix:  prefix,
+		Folders: make([]FolderEntry, 0, len(page.CommonPrefixes)),
+		Files:   make([]FileObject, 0, len(page.Objects)),
+	}
+	for _, obj := range page.Objects {
+		listing.Files = append(listing.Files, s.fileObject(obj))
+	}
+	for _, common := range page.CommonPrefixes {
+		listing.Folders = append(listing.Folders, FolderEntry{
+			Prefix: common,
+			Name:   strings.TrimSuffix(strings.TrimPrefix(common, prefix), "/"),
+		})
+	}
+	if err := s.folderStats(ctx, listing.Folders); err != nil {
+		return nil, fmt.Errorf("не удалось посчитать содержимое подпапок: %w", err)
+	}
+	if page.NextToken != "" {
+		listing.NextCursor = encodeListCursor(page.NextToken, prefix)
+	}
+	return listing, nil
+}
+
+// folderStats заполняет Files и Bytes подпапок, обходя каждую постранично,
+// не больше browseStatsConcurrency подпапок одновременно.
+func (s *S3Service) folderStats(ctx context.Context, folders []FolderEntry) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(folders))
+	sem := make(chan struct{}, browseStatsConcurrency)
+	for i := range folders {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			folder := &folders[i]
+			errs[i] = s.repo.ListPages(ctx, folder.Prefix, func(page []repository.ObjectInfo) error {
+				for _, obj := range page {
+					folder.Files++
+					folder.Bytes += obj.Size
+				}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	"files/internal/repository"
+	"files/pkg/imaging"
+)
+
+// ErrTransformForbidden — параметры не совпадают ни с одним пресетом и не подписаны.
+var ErrTransformForbidden = errors.New("параметры трансформации не разрешены")
+
+// ErrInvalidTransform — параметры трансформации некорректны.
+var ErrInvalidTransform = errors.New("некорректные параметры трансформации")
+
+// ImageService — трансформация изображений «на лету» с кэшированием результата в хранилище.
+type ImageService struct {
+	repo       repository.Storage
+	presets    map[string]imaging.Transform
+	signingKey []byte
+	limits     imaging.Limits
+}
+
+// NewImageService — конструктор. Произвольные параметры принимаются только с подписью signingKey;
+// пустой ключ оставляет доступными лишь пресеты. limits ограничивают декодируемые оригиналы.
+func NewImageService(
+	repo repository.Storage,
+	presets map[string]imaging.Transform,
+	signingKey string,
+	limits imaging.Limits,
+) *ImageService {
+	return &ImageService{
+		repo:       repo,
+		presets:    presets,
+		signingKey: []byte(signingKey),
+		limits:     limits,
+	}
+}
+
+// ResolveTransform определяет параметры по query: либо ?preset=name, либо w/h/fit/q/fmt с подписью sig.
+func (s *ImageService) ResolveTransform(idParam, uuidParam string, query url.Values) (imaging.Transform, error) {
+	if name := query.Get("preset"); name != "" {
+		t, ok := s.presets[name]
+		if !ok {
+			return imaging.Transform{}, fmt.Errorf("%w: неизвестный пресет %q", ErrTransformForbidden, name)
+		}
+		return t, nil
+	}
+
+	t, err := imaging.ParseTransform(query)
+	if err != nil {
+		return imaging.Transform{}, fmt.Errorf("%w: %v", ErrInvalidTransform, err)
+	}
+	if len(s.signingKey) == 0 {
+		return imaging.Transform{}, fmt.Errorf("%w: разрешены только пресеты", ErrTransformForbidden)
+	}
+	signature, err := hex.DecodeString(query.Get("sig"))
+	if err != nil || !hmac.Equal(signature, s.signature(idParam, uuidParam, t)) {
+		return imaging.Transform{}, fmt.Errorf("%w: неверная подпись", ErrTransformForbidden)
+	}
+	return t, nil
+}
+
+// Sign возвращает подпись параметров для файла: hex(HMAC-SHA256(key, "id/uuid?" + CanonicalQuery)).
+// Её выдаёт клиентам сервис, который знает ключ.
+func (s *ImageService) Sign(idParam, uuidParam string, t imaging.Transform) string {
+	return hex.EncodeToString(s.signature(idParam, uuidParam, t))
+}
+
+// Transform возвращает трансформированное изображение. Результат кэшируется в хранилище рядом
+// с оригиналом (photos/:id/:uuid_tr-...), поэтому удаляется вместе с ним.
+func (s *ImageService) Transform(
+	ctx context.Context,
+	idParam, uuidParam string,
+	t imaging.Transform,
+) (io.ReadCloser, *repository.ObjectInfo, error) {
+	originalKey, originalType, err := findOriginal(ctx, s.repo, idParam, uuidParam)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outputType := t.OutputType(originalType)
+	cacheKey := VariantKey(originalKey, t.CacheName(), imaging.ExtensionForContentType("", outputType))
+	if body, info, err := s.repo.GetFile(ctx, cacheKey); err == nil {
+		return body, info, nil
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, fmt.Errorf("ошибка чтения кэша: %w", err)
+	}
+
+	original, _, err := s.repo.GetFile(ctx, originalKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка чтения оригинала: %w", err)
+	}
+	defer original.Close()
+
+	img, _, err := imaging.Decode(original, s.limits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка декодирования оригинала: %w", err)
+	}
+
+	result, err := t.Apply(img)
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, result, outputType, t.Quality); err != nil {
+		return nil, nil, fmt.Errorf("ошибка кодирования результата: %w", err)
+	}
+	data := buf.Bytes()
+
+	// Кэш читается только через сервис, поэтому он приватный — даже если оригинал публичный
+	if _, err := s.repo.UploadFile(ctx, cacheKey, outputType, bytes.NewReader(data), repository.VisibilityPrivate); err != nil {
+		return nil, nil, fmt.Errorf("ошибка сохранения в кэш: %w", err)
+	}
+	info, err := s.repo.HeadFile(ctx, cacheKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка чтения кэша: %w", err)
+	}
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+// signature вычисляет HMAC-SHA256 от "id/uuid?" + CanonicalQuery.
+func (s *ImageService) signature(idParam, uuidParam string, t imaging.Transform) []byte {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(idParam + "/" + uuidParam + "?" + t.CanonicalQuery()))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// Ограничения открытых ключей и загрузки JWKS.
+const (
+	// minRSAKeyBits — наименьший размер ключа RS256.
+	minRSAKeyBits = 2048
+	// maxJWKSSize — наибольший размер документа JWKS в байтах.
+	maxJWKSSize = 1 << 20
+	// jwksFetchTimeout — время на загрузку JWKS по URL.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// ErrUnknownKey — в наборе нет ключа с kid из заголовка токена.
+var ErrUnknownKey = errors.New("неизвестный ключ подписи")
+
+// VerificationKey — открытый ключ для проверки подписи и алгоритм, для которого он предназначен:
+// RS256 (RSA), ES256 (ECDSA P-256) или EdDSA (Ed25519).
+type VerificationKey struct {
+	ID  string
+	Alg string
+	Key crypto.PublicKey
+}
+
+// VerificationKeys — источник открытых ключей, из которого ключ выбирается по kid токена.
+type VerificationKeys interface {
+	// Key возвращает ключ kid; пустой kid допустим, только если ключ в наборе один.
+	Key(kid string) (*VerificationKey, error)
+}
+
+// StaticKeys — неизменный набор ключей по kid (PEM-файлы или загруженный JWKS).
+type StaticKeys map[string]*VerificationKey
+
+// Key возвращает ключ kid из набора.
+func (k StaticKeys) Key(kid string) (*VerificationKey, error) {
+	if kid == "" && len(k) == 1 {
+		for _, key := range k {
+			return key, nil
+		}
+	}
+	if key, ok := k[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: kid %q", ErrUnknownKey, kid)
+}
+
+// LoadPEMKeys читает открытые ключи из PEM-файлов. spec — список через запятую
+// "kid=path" или просто "path"; во втором случае kid — имя файла без расширения.
+// Поддерживаются PUBLIC KEY (PKIX), RSA PUBLIC KEY (PKCS #1) и CERTIFICATE.
+func LoadPEMKeys(spec string) (StaticKeys, error) {
+	keys := StaticKeys{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, file, ok := strings.Cut(entry, "=")
+		if !ok {
+			file = entry
+			kid = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		if _, exists := keys[kid]; exists {
+			return nil, fmt.Errorf("ключ %q указан дважды", kid)
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать ключ %q: %w", kid, err)
+		}
+		pub, err := parsePEMPublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("ключ %q из %s: %w", kid, file, err)
+		}
+		key, err := newVerificationKey(kid, "", pub)
+		if err != nil {
+			return nil, err
+		}
+		keys[kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("не задано ни одного открытого ключа")
+	}
+	return keys, nil
+}
+
+func parsePEMPublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("файл не содержит PEM-блока")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("неподдерживаемый PEM-блок %s", block.Type)
+}
+
+// newVerificationKey определяет алгоритм по типу ключа. alg из JWK, если задан, должен с ним совпадать:
+// так токен не может выбрать для ключа другой алгоритм.
+func newVerificationKey(kid, alg string, pub crypto.PublicKey) (*VerificationKey, error) {
+	var keyAlg string
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("ключ %q: RSA короче %d бит", kid, minRSAKeyBits)
+		}
+		keyAlg = jwt.SigningMethodRS256.Alg()
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ключ %q: поддерживается только кривая P-256", kid)
+		}
+		keyAlg = jwt.SigningMethodES256.Alg()
+	case ed25519.PublicKey:
+		keyAlg = jwt.SigningMethodEdDSA.Alg()
+	default:
+		return nil, fmt.Errorf("ключ %q: неподдерживаемый тип %T", kid, pub)
+	}
+	if alg != "" && alg != keyAlg {
+		return nil, fmt.Errorf("ключ %q: алгоритм %s не подходит, ожидается %s", kid, alg, keyAlg)
+	}
+	return &VerificationKey{ID: kid, Alg: keyAlg, Key: pub}, nil
+}
+
+// jwk — ключ из документа JWKS (RFC 7517, 7518, 8037).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS разбирает документ {"keys": [...]}. Ключи шифрования (use=enc) пропускаются. Ключи, которые
+// нельзя использовать для проверки (alg не RS256/ES256/EdDSA, RSA короче 2048 бит, другой kty или кривая,
+// повторный kid, ошибка кодирования), пропускаются с предупреждением: один такой ключ в JWKS провайдера
+// не должен ломать ротацию. Ошибка — только если ключей подписи не осталось.
+func ParseJWKS(data []byte, logger *zap.Logger) (StaticKeys, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("некорректный JWKS: %w", err)
+	}
+
+	keys := StaticKeys{}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.verificationKey()
+		if err == nil {
+			if _, exists := keys[k.Kid]; exists {
+				err = errors.New("kid уже встречался в JWKS")
+			}
+		}
+		if err != nil {
+			logger.Warn("Skipping JWKS key",
+				zap.String("kid", k.Kid), zap.String("kty", k.Kty), zap.String("alg", k.Alg), zap.Error(err))
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("в JWKS нет ключей подписи RS256, ES256 или EdDSA")
+	}
+	return keys, nil
+}
+
+func (k jwk) verificationKey() (*VerificationKey, error) {
+	pub, err := k.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	return newVerificationKey(k.Kid, k.Alg, pub)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный n: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("некорректный e")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("некорректные координаты x, y")
+		}
+		// ecdh проверяет, что точка лежит на кривой
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("точка не на кривой P-256: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("некорректный x")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("неподдерживаемый тип ключа: kty %s, crv %s", k.Kty, k.Crv)
+}
+
+// JWKSConfig — откуда и как часто перечитывать JWKS.
+type JWKSConfig struct {
+	// Source — путь к файлу или http(s)-URL документа JWKS.
+	Source string
+	// Refresh — через сколько набор ключей считается устаревшим и перечитывается в фоне.
+	Refresh time.Duration
+	// MinRefresh — не чаще какого интервала JWKS перечитывается из-за неизвестного kid,
+	// чтобы токены со случайным kid не нагружали сервис ключей.
+	MinRefresh time.Duration
+}
+
+// JWKSKeys — набор ключей из JWKS с кэшем. Устаревший набор обновляется в фоне,
+// а токен с неизвестным kid (ключи ротировали) вызывает немедленное перечитывание.
+// При ошибке загрузки продолжают действовать прежние ключи.
+type JWKSKeys struct {
+	config JWKSConfig
+	client *http.Client
+	logger *zap.Logger
+
+	mu      sync.RWMutex
+	keys    StaticKeys
+	fetched time.Time // время последней успешной загрузки
+
+	reloadMu   sync.Mutex
+	attempted  time.Time   // время последней попытки загрузки, под reloadMu
+	refreshing atomic.Bool // идёт фоновое обновление
+}
+
+// NewJWKSKeys загружает JWKS; сервис не создаётся, если документ недоступен или в нём нет ключей.
+func NewJWKSKeys(config JWKSConfig, logger *zap.Logger) (*JWKSKeys, error) {
+	if config.Refresh <= 0 || config.MinRefresh < 0 {
+		return nil, fmt.Errorf("некорректный интервал обновления JWKS: %s, %s", config.Refresh, config.MinRefresh)
+	}
+	j := &JWKSKeys{
+		config: config,
+		client: &http.Client{Timeout: jwksFetchTimeout},
+		logger: logger,
+	}
+	keys, err := j.fetch()
+	if err != nil {
+		return nil, err
+	}
+	j.keys, j.fetched, j.attempted = keys, time.Now(), time.Now()
+	return j, nil
+}
+
+// Key возвращает ключ kid из кэша, при необходимости перечитав JWKS.
+func (j *JWKSKeys) Key(kid string) (*VerificationKey, error) {
+	keys, fetched := j.snapshot()
+	if time.Since(fetched) >= j.config.Refresh && j.refreshing.CompareAndSwap(false, true) {
+		go func() {
+			defer j.refreshing.Store(false)
+			_, _ = j.reload(fetched)
+		}()
+	}
+
+	key, err := keys.Key(kid)
+	if !errors.Is(err, ErrUnknownKey) {
+		return key, err
+	}
+	keys, reloadErr := j.reload(fetched)
+	if reloadErr != nil {
+		return nil, errors.Join(err, reloadErr)
+	}
+	return keys.Key(kid)
+}
+
+func (j *JWKSKeys) snapshot() (StaticKeys, time.Time) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.keys, j.fetched
+}
+
+// reload перечитывает JWKS, если его не перечитали после seen и с прошлой попытки прошло MinRefresh.
+// Одновременно выполняется только одна загрузка.
+func (j *JWKSKeys) reload(seen time.Time) (StaticKeys, error) {
+	j.reloadMu.Lock()
+	defer j.reloadMu.Unlock()
+
+	keys, fetched := j.snapshot()
+	if fetched.After(seen) || time.Since(j.attempted) < j.config.MinRefresh {
+		return keys, nil
+	}
+	j.attempted = time.Now()
+
+	fresh, err := j.fetch()
+	if err != nil {
+		j.logger.Warn("Failed to refresh JWKS", zap.String("source", j.config.Source), zap.Error(err))
+		return keys, err
+	}
+	j.mu.Lock()
+	j.keys, j.fetched = fresh, time.Now()
+	j.mu.Unlock()
+	j.logger.Info("JWKS refreshed", zap.String("source", j.config.Source), zap.Int("keys", len(fresh)))
+	return fresh, nil
+}
+
+// fetch читает и разбирает JWKS из файла или по URL.
+func (j *JWKSKeys) fetch() (StaticKeys, error) {
+	source := j.config.Source
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать JWKS: %w", err)
+		}
+		return ParseJWKS(data, j.logger)
+	}
+
+	resp, err := j.client.Get(source)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось загрузить JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("не удалось загрузить JWKS: статус %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("не удалось загрузить JWKS: %w", err)
+	}
+	if len(data) > maxJWKSSize {
+		return nil, fmt.Errorf("JWKS больше %d байт", maxJWKSSize)
+	}
+	return ParseJWKS(data, j.logger)
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testJWK описывает открытый ключ в формате JWK.
+func testJWK(kid string, pub crypto.PublicKey) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "crv": "P-256", "kid": kid,
+			"x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "crv": "Ed25519", "kid": kid, "x": b64(pub)}
+	}
+	panic(fmt.Sprintf("unsupported key %T", pub))
+}
+
+func jwksDocument(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseJWKSSkipsUnusableKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JWKS провайдера: рядом с ключами RS256/ES256/EdDSA лежат ключи, которые мы не поддерживаем
+	withAlg := func(jwk map[string]string, alg string) map[string]string {
+		jwk["alg"] = alg
+		return jwk
+	}
+	p384 := map[string]string{"kty": "EC", "crv": "P-384", "kid": "p384",
+		"x": base64.RawURLEncoding.EncodeToString(p384Key.X.Bytes()),
+		"y": base64.RawURLEncoding.EncodeToString(p384Key.Y.Bytes())}
+	document := jwksDocument(t,
+		withAlg(testJWK("rs256", rsaKey.Public()), "RS256"),
+		testJWK("es256", ecKey.Public()),
+		testJWK("eddsa", edKey.Public()),
+		withAlg(testJWK("rs512", rsaKey.Public()), "RS512"),
+		withAlg(testJWK("ps256", rsaKey.Public()), "PS256"),
+		withAlg(testJWK("oaep", rsaKey.Public()), "RSA-OAEP"),
+		testJWK("weak", weakRSAKey.Public()),
+		p384,
+		withAlg(testJWK("ec-rs256", ecKey.Public()), "RS256"),
+		map[string]string{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		map[string]string{"kty": "RSA", "kid": "broken", "n": "!!!", "e": "AQAB"},
+		map[string]string{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		withAlg(testJWK("es256", edKey.Public()), "EdDSA"),
+	)
+
+	keys, err := ParseJWKS(document, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 usable keys, got %d: %v", len(keys), keys)
+	}
+	for kid, alg := range map[string]string{"rs256": "RS256", "es256": "ES256", "eddsa": "EdDSA"} {
+		key, err := keys.Key(kid)
+		if err != nil || key.Alg != alg {
+			t.Errorf("%s: expected %s key, got %+v, %v", kid, alg, key, err)
+		}
+	}
+
+	// Без единого пригодного ключа JWKS отвергается
+	if _, err := ParseJWKS(jwksDocument(t, withAlg(testJWK("ec", ecKey.Public()), "RS256"), testJWK("weak", weakRSAKey.Public())), zap.NewNop()); err == nil {
+		t.Error("JWKS without usable signing keys must be rejected")
+	}
+}
+
+func TestLoadPEMKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writePEM := func(name string, pub crypto.PublicKey) string {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := dir + "/" + name
+		if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	// kid — имя файла без расширения или явный "kid=path"
+	keys, err := LoadPEMKeys(writePEM("rsa.pem", rsaKey.Public()) + ", " +
+		writePEM("ec.pem", ecKey.Public()) + ",ed-2024=" + writePEM("ed.pem", edPub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 keys, got %v", keys)
+	}
+	for kid, alg := range map[string]string{"rsa": "RS256", "ec": "ES256", "ed-2024": "EdDSA"} {
+		key, err := keys.Key(kid)
+		if err != nil || key.Alg != alg || key.ID != kid {
+			t.Errorf("%s: expected %s key, got %+v, %v", kid, alg, key, err)
+		}
+	}
+	if _, err := keys.Key("ed"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("explicit kid must replace the file name, got %v", err)
+	}
+	if _, err := keys.Key(""); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("empty kid is ambiguous with several keys, got %v", err)
+	}
+
+	for name, spec := range map[string]string{
+		"weak RSA":      writePEM("weak.pem", weakRSAKey.Public()),
+		"duplicate kid": "a=" + dir + "/rsa.pem,a=" + dir + "/ec.pem",
+		"missing file":  dir + "/missing.pem",
+		"empty":         " , ",
+	} {
+		if _, err := LoadPEMKeys(spec); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestJWKSKeysRotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Сервис ключей: документ можно подменить (ротация), запросы считаются
+	var document atomic.Value
+	var fetches atomic.Int32
+	document.Store(jwksDocument(t, testJWK("k1", edPub)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document.Load().([]byte))
+	}))
+	defer server.Close()
+
+	keys, err := NewJWKSKeys(JWKSConfig{Source: server.URL, Refresh: time.Hour}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if key, err := keys.Key("k1"); err != nil || key.Alg != "EdDSA" {
+			t.Fatalf("k1: expected EdDSA key, got %+v, %v", key, err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("known keys must be served from cache, got %d fetches", n)
+	}
+
+	// Ротация: неизвестный kid перечитывает JWKS, старый ключ пропадает из набора
+	document.Store(jwksDocument(t, testJWK("k2", ecKey.Public())))
+	if key, err := keys.Key("k2"); err != nil || key.Alg != "ES256" {
+		t.Errorf("k2 after rotation: expected ES256 key, got %+v, %v", key, err)
+	}
+	if _, err := keys.Key("k1"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("k1 after rotation: expected ErrUnknownKey, got %v", err)
+	}
+
+	// Неизвестный kid перечитывает JWKS не чаще MinRefresh
+	limited, err := NewJWKSKeys(JWKSConfig{Source: server.URL, Refresh: time.Hour, MinRefresh: time.Hour}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := fetches.Load()
+	for range 3 {
+		if _, err := limited.Key("unknown"); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("expected ErrUnknownKey, got %v", err)
+		}
+	}
+	if n := fetches.Load() - before; n != 0 {
+		t.Errorf("unknown kid within MinRefresh must not refetch JWKS, got %d fetches", n)
+	}
+
+	// JWKS из файла
+	file := t.TempDir() + "/jwks.json"
+	if err := os.WriteFile(file, jwksDocument(t, testJWK("rsa", rsaKey.Public())), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fileKeys, err := NewJWKSKeys(JWKSConfig{Source: file, Refresh: time.Hour}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := fileKeys.Key("rsa"); err != nil || key.Alg != "RS256" {
+		t.Errorf("JWKS file: expected RS256 key, got %+v, %v", key, err)
+	}
+
+	for name, config := range map[string]JWKSConfig{
+		"no refresh":   {Source: file},
+		"missing file": {Source: file + ".missing", Refresh: time.Hour},
+	} {
+		if _, err := NewJWKSKeys(config, zap.NewNop()); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -11,58 +15,154 @@ import (
 type JWTServiceInterface interface {
 	// GenerateAccessToken генерирует JWT access токен
 	// userId - уникальный идентификатор пользователя
+	// scopes - разрешения токена (ScopeRead, ScopeWrite, ScopeDelete, ScopeAdmin)
 	// expiresIn - продолжительность времени действия токена
-	GenerateAccessToken(userId int, expiresIn time.Duration) (string, error)
+	GenerateAccessToken(userId int, scopes []string, expiresIn time.Duration) (string, error)
 
 	// ValidateToken проверяет валидность предоставленного токена
 	// Возвращает объект токена или ошибку
 	ValidateToken(token string) (*jwt.Token, error)
 }
 
+// MinJWTSecretLength — наименьшая длина секрета HS256 в байтах (размер выхода SHA-256).
+const MinJWTSecretLength = 32
+
+// JWTConfig — параметры подписи и проверки токенов.
+type JWTConfig struct {
+	// Secret — ключ HMAC для HS256, не короче MinJWTSecretLength байт. Без него токены
+	// не выдаются и проверяются только по Keys.
+	Secret string
+	// Keys — открытые ключи для RS256, ES256 и EdDSA; ключ выбирается по kid токена.
+	// nil — принимаются только токены HS256.
+	Keys VerificationKeys
+	// Issuer и Audience, если заданы, записываются в выдаваемые токены и обязательны при проверке.
+	Issuer   string
+	Audience string
+	// Leeway — допустимое расхождение часов при проверке exp, nbf и iat.
+	Leeway time.Duration
+}
+
 // jwtService структура, содержащая настройки для работы с JWT
 type jwtService struct {
-	secretKey string      // секретный ключ для подписи токенов
-	logger    *zap.Logger // логгер для записи действий
+	config JWTConfig   // секрет и правила проверки токенов
+	parser *jwt.Parser // парсер с закреплённым алгоритмом и проверкой iss/aud
+	logger *zap.Logger // логгер для записи действий
 }
 
 // Claims определяет пользовательские данные для хранения в JWT токене
 // UserID - идентификатор пользователя
 // RegisteredClaims - встроенные поля JWT (например, время истечения)
 type Claims struct {
-	UserId               int `json:"userId"` // Уникальный идентификатор пользователя
-	jwt.RegisteredClaims     // Встроенные стандартные claims (exp, iat и т.д.)
+	UserId int `json:"userId"` // Уникальный идентификатор пользователя
+	// Roles - роли пользователя; RoleAdmin снимает проверку владения папкой
+	Roles []string `json:"roles,omitempty"`
+	// Folders - дополнительные :id папок, к которым у пользователя есть доступ (кроме собственной userId)
+	Folders []string `json:"folders,omitempty"`
+	// Scopes - разрешённые действия: files:read, files:write, files:delete, files:admin
+	Scopes               []string `json:"scopes,omitempty"`
+	jwt.RegisteredClaims          // Встроенные стандартные claims (exp, iat и т.д.)
+}
+
+// RoleAdmin - роль администратора: доступ к папкам всех пользователей и ко всему хранилищу
+const RoleAdmin = "admin"
+
+// Разрешения (scopes) токена, которые требуют маршруты
+const (
+	ScopeRead   = "files:read"   // списки, скачивание, ссылки и трансформации
+	ScopeWrite  = "files:write"  // загрузка любым способом
+	ScopeDelete = "files:delete" // удаление файлов и папок
+	ScopeAdmin  = "files:admin"  // все разрешения и доступ ко всем папкам, как RoleAdmin
+)
+
+// IsAdmin сообщает, есть ли у пользователя роль RoleAdmin или разрешение ScopeAdmin
+func (c *Claims) IsAdmin() bool {
+	return slices.Contains(c.Roles, RoleAdmin) || slices.Contains(c.Scopes, ScopeAdmin)
+}
+
+// HasScope сообщает, есть ли у токена разрешение scope; администратору разрешено всё
+func (c *Claims) HasScope(scope string) bool {
+	return c.IsAdmin() || slices.Contains(c.Scopes, scope)
+}
+
+// CanAccess проверяет владение папкой photos/:id: id совпадает с userId или перечислен в Folders.
+// Администратору доступны все папки
+func (c *Claims) CanAccess(id string) bool {
+	if c.IsAdmin() {
+		return true
+	}
+	return id == strconv.Itoa(c.UserId) || slices.Contains(c.Folders, id)
 }
 
 // NewJWTService создает новый экземпляр JWTServiceInterface
-// secretKey - строка, используемая для подписи токенов
+// config - секрет, открытые ключи и правила проверки токенов; короткий секрет или ни секрета, ни ключей — ошибка
 // logger - объект логгера для записи действий
-func NewJWTService(secretKey string, logger *zap.Logger) JWTServiceInterface {
-	return &jwtService{
-		secretKey: secretKey,
-		logger:    logger,
+func NewJWTService(config JWTConfig, logger *zap.Logger) (JWTServiceInterface, error) {
+	if config.Secret == "" && config.Keys == nil {
+		return nil, errors.New("для JWT нужен секрет HS256 или открытые ключи")
+	}
+	if config.Secret != "" && len(config.Secret) < MinJWTSecretLength {
+		return nil, fmt.Errorf("секрет JWT должен быть не короче %d байт, получено %d", MinJWTSecretLength, len(config.Secret))
+	}
+	if config.Leeway < 0 {
+		return nil, fmt.Errorf("допуск расхождения часов JWT не может быть отрицательным: %s", config.Leeway)
+	}
+
+	// Принимаем только настроенные алгоритмы: токен с другим alg (в том числе none) не проверяется вовсе
+	var methods []string
+	if config.Secret != "" {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.Keys != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg(), jwt.SigningMethodEdDSA.Alg())
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(config.Leeway),
+	}
+	if config.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		opts = append(opts, jwt.WithAudience(config.Audience))
 	}
+	return &jwtService{
+		config: config,
+		parser: jwt.NewParser(opts...),
+		logger: logger,
+	}, nil
 }
 
-// GenerateAccessToken генерирует JWT токен с заданным userID и временем действия
+// GenerateAccessToken генерирует JWT токен с заданным userID, разрешениями и временем действия
 // Возвращает подписанный токен в виде строки или ошибку
-func (s *jwtService) GenerateAccessToken(userId int, expiresIn time.Duration) (string, error) {
+func (s *jwtService) GenerateAccessToken(userId int, scopes []string, expiresIn time.Duration) (string, error) {
+	if s.config.Secret == "" {
+		return "", errors.New("выдача токенов недоступна: не задан секрет HS256")
+	}
 	// Логируем начало генерации токена
-	s.logger.Info("Generating access token", zap.Int("userId", userId), zap.Duration("expiresIn", expiresIn))
+	s.logger.Info("Generating access token",
+		zap.Int("userId", userId), zap.Strings("scopes", scopes), zap.Duration("expiresIn", expiresIn))
 
 	// Создаем claims с пользовательскими и стандартными данными
 	claims := Claims{
 		UserId: userId,
+		Scopes: scopes,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.config.Issuer,                               // Издатель, который проверяет ValidateToken
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)), // Устанавливаем время истечения токена
 			IssuedAt:  jwt.NewNumericDate(time.Now()),                // Устанавливаем время создания токена
 		},
 	}
+	if s.config.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{s.config.Audience}
+	}
 
 	// Создаем новый токен с методом подписи HS256 и нашими claims
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Подписываем токен с помощью секретного ключа
-	signedToken, err := token.SignedString([]byte(s.secretKey))
+	signedToken, err := token.SignedString([]byte(s.config.Secret))
 	if err != nil {
 		s.logger.Error("Failed to sign token", zap.Error(err))
 		return "", err
@@ -75,21 +175,33 @@ func (s *jwtService) GenerateAccessToken(userId int, expiresIn time.Duration) (s
 
 // ValidateToken проверяет валидность предоставленного токена
 // tokenStr - строковое представление токена
+// Проверяются алгоритм (HS256 или RS256/ES256/EdDSA с ключом по kid), подпись, exp/iat с учётом Leeway,
+// а также iss и aud, если они настроены
 // Возвращает объект токена и nil, если токен валиден, или ошибку, если он недействителен
 func (s *jwtService) ValidateToken(tokenStr string) (*jwt.Token, error) {
-	// Логируем начало валидации токена
-	s.logger.Info("Validating token")
-
-	// Разбираем токен и проверяем его подпись с использованием секретного ключа
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.secretKey), nil
-	})
+	// Разбираем токен и проверяем его подпись секретом или открытым ключом
+	token, err := s.parser.ParseWithClaims(tokenStr, &Claims{}, s.verificationKey)
 	if err != nil {
-		s.logger.Error("Failed to validate token", zap.Error(err))
+		s.logger.Debug("Failed to validate token", zap.Error(err))
 		return nil, err
 	}
-
-	// Логируем успешную валидацию токена
-	s.logger.Info("Token validated successfully")
 	return token, nil
 }
+
+// verificationKey выбирает ключ проверки подписи: секрет для HS256, иначе открытый ключ по kid.
+// Алгоритм токена должен совпадать с алгоритмом ключа, иначе подпись не проверяется.
+func (s *jwtService) verificationKey(token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if alg == jwt.SigningMethodHS256.Alg() {
+		return []byte(s.config.Secret), nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, err := s.config.Keys.Key(kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.Alg != alg {
+		return nil, fmt.Errorf("ключ %q предназначен для %s, токен подписан %s", key.ID, key.Alg, alg)
+	}
+	return key.Key, nil
+}
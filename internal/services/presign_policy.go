@@ -0,0 +1,11 @@
+package services
+
+import "time"
+
+// PresignPolicy — ограничения на срок действия подписанных ссылок, которые выдаёт маршрут.
+type PresignPolicy struct {
+	// DefaultExpiry — срок действия, если клиент его не указал.
+	DefaultExpiry time.Duration
+	// MaxExpiry — наибольший срок, который может запросить клиент.
+	MaxExpiry time.Duration
+}
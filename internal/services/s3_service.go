@@ -2,171 +2,263 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/google/uuid"
-	"io"
-	"mime"
-	"mime/multipart"
 	"path"
+	"strings"
+	"time"
 
 	"files/internal/repository"
+	"files/pkg/imaging"
+	"github.com/google/uuid"
 )
 
 // S3Service — слой бизнес-логики для работы с файлами.
 type S3Service struct {
-	repo *repository.S3Repository
+	repo repository.Storage
 }
 
-// NewS3Service — конструктор, принимает репозиторий.
-func NewS3Service(repo *repository.S3Repository) *S3Service {
+// NewS3Service — конструктор, принимает хранилище (S3 или любой другой бэкенд).
+func NewS3Service(repo repository.Storage) *S3Service {
 	return &S3Service{repo: repo}
 }
 
-// UploadMultiple — читает файлы из multipart.Reader, заливает их в S3.
-func (s *S3Service) UploadMultiple(
-	ctx context.Context,
-	idParam string,
-	multipartReader *multipart.Reader,
-) ([]string, error) {
-	// Префикс (папка) в S3, напр. photos/123
-	prefix := fmt.Sprintf("photos/%s", idParam)
-	var fileURLs []string
-
-	// Читаем части (part) из multipart.Reader
-	for {
-		part, err := multipartReader.NextPart()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("ошибка чтения part: %w", err)
-		}
+// deleteChunkKeys — сколько ключей deletePrefix передаёт хранилищу за раз. Хранилище делит их
+// на запросы DeleteObjects и выполняет параллельно; в памяти больше этого числа ключей не копится.
+const deleteChunkKeys = 10 * repository.MaxDeleteKeys
 
-		fileName := part.FileName()
-		if fileName == "" {
-			continue
-		}
+// DeleteResult — итог удаления папки: удалённые ключи и ключи, которые удалить не удалось.
+type DeleteResult struct {
+	Deleted []string                   `json:"deleted"`
+	Failed  []repository.DeleteFailure `json:"failed"`
+}
 
-		ext := path.Ext(fileName)
-		fileUUID := uuid.New().String()
-		// Формируем ключ в S3: photos/123/uuid.png
-		s3Key := fmt.Sprintf("%s/%s%s", prefix, fileUUID, ext)
+// DeleteAllByID — удаляет все файлы в photos/:id/. Список читается постранично, поэтому число
+// файлов в папке не ограничено. Ошибка удаления отдельных файлов не прерывает удаление остальных:
+// такие файлы попадают в DeleteResult.Failed.
+func (s *S3Service) DeleteAllByID(ctx context.Context, idParam string) (*DeleteResult, error) {
+	return s.deletePrefix(ctx, fmt.Sprintf("photos/%s/", idParam))
+}
 
-		contentType := mime.TypeByExtension(ext)
-		if contentType == "" {
-			contentType = "application/octet-stream"
-		}
+// DeleteOneByUUID — удаляет оригинал photos/:id/:uuid.ext вместе с его копиями (варианты, WebP,
+// кэш трансформаций). :uuid должен быть UUID, иначе короткий :uuid удалил бы по префиксу чужие файлы.
+// Как и в DeleteAllByID, неудалённые файлы попадают в DeleteResult.Failed.
+func (s *S3Service) DeleteOneByUUID(ctx context.Context, idParam, uuidParam string) (*DeleteResult, error) {
+	prefix := fmt.Sprintf("photos/%s/%s", idParam, uuidParam)
+	if _, err := uuid.Parse(uuidParam); err != nil || idParam == "" || strings.Contains(idParam, "/") {
+		return nil, fmt.Errorf("%w: %s", repository.ErrNotFound, prefix)
+	}
+	return s.deletePrefix(ctx, prefix)
+}
 
-		fileURL, err := s.repo.UploadFile(ctx, s3Key, contentType, part)
-		if err != nil {
-			return nil, fmt.Errorf("ошибка загрузки в S3: %w", err)
-		}
+// deletePrefix удаляет все файлы с префиксом prefix, читая список постранично.
+// Если файлов нет — ErrNotFound.
+func (s *S3Service) deletePrefix(ctx context.Context, prefix string) (*DeleteResult, error) {
+	result := &DeleteResult{Deleted: []string{}, Failed: []repository.DeleteFailure{}}
 
-		fileURLs = append(fileURLs, fileURL)
+	keys := make([]string, 0, deleteChunkKeys)
+	flush := func() error {
+		err := s.repo.DeleteFilesBatch(ctx, keys)
+		var partial *repository.DeleteFilesError
+		if err != nil && !errors.As(err, &partial) {
+			return fmt.Errorf("ошибка удаления файлов: %w", err)
+		}
+		failed := make(map[string]bool)
+		if partial != nil {
+			for _, f := range partial.Failed {
+				failed[f.Key] = true
+			}
+			result.Failed = append(result.Failed, partial.Failed...)
+		}
+		for _, k := range keys {
+			if !failed[k] {
+				result.Deleted = append(result.Deleted, k)
+			}
+		}
+		keys = keys[:0]
+		return nil
 	}
 
-	if len(fileURLs) == 0 {
-		return nil, fmt.Errorf("в multipart нет файлов")
+	err := s.repo.ListPages(ctx, prefix, func(page []repository.ObjectInfo) error {
+		for _, obj := range page {
+			keys = append(keys, obj.Key)
+		}
+		if len(keys) < deleteChunkKeys {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil && len(keys) > 0 {
+		err = flush()
 	}
-
-	return fileURLs, nil
-}
-
-// DeleteAllByID — удаляет все файлы в photos/:id/
-func (s *S3Service) DeleteAllByID(ctx context.Context, idParam string) error {
-	prefix := fmt.Sprintf("photos/%s/", idParam)
-
-	objects, err := s.repo.ListFilesByPrefix(ctx, prefix)
 	if err != nil {
-		return fmt.Errorf("не удалось получить список файлов: %w", err)
+		return result, fmt.Errorf("не удалось удалить файлы с префиксом '%s': %w", prefix, err)
 	}
-	if len(objects) == 0 {
-		return fmt.Errorf("нет файлов с префиксом '%s'", prefix)
+	if len(result.Deleted)+len(result.Failed) == 0 {
+		return nil, fmt.Errorf("%w: нет файлов с префиксом '%s'", repository.ErrNotFound, prefix)
 	}
+	return result, nil
+}
 
-	var keys []string
-	for _, obj := range objects {
-		keys = append(keys, *obj.Key)
+// OpenFile — открывает оригинал photos/:id/:uuid.ext на чтение с произвольного смещения.
+// Вызывающий обязан закрыть reader.
+func (s *S3Service) OpenFile(ctx context.Context, idParam, uuidParam string) (*repository.ObjectReader, error) {
+	key, _, err := findOriginal(ctx, s.repo, idParam, uuidParam)
+	if err != nil {
+		return nil, err
 	}
-
-	if err := s.repo.DeleteFilesBatch(ctx, keys); err != nil {
-		return fmt.Errorf("ошибка удаления файлов: %w", err)
+	info, err := s.repo.HeadFile(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить метаданные файла: %w", err)
 	}
-	return nil
+	return repository.NewObjectReader(ctx, s.repo, *info), nil
 }
 
-// DeleteOneByUUID — удаляет один (или несколько) файлов с префиксом photos/:id/:uuid
-func (s *S3Service) DeleteOneByUUID(ctx context.Context, idParam, uuidParam string) ([]string, error) {
-	prefix := fmt.Sprintf("photos/%s/%s", idParam, uuidParam)
+// PresignedURL — временная ссылка на чтение файла.
+type PresignedURL struct {
+	Key       string    `json:"key"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
 
-	objects, err := s.repo.ListFilesByPrefix(ctx, prefix)
+// PresignDownload — выдаёт ссылку на оригинал photos/:id/:uuid.ext (или на его копию variant),
+// которая действует opts.Expires. Работает и для приватных файлов.
+func (s *S3Service) PresignDownload(
+	ctx context.Context,
+	idParam, uuidParam, variant string,
+	opts repository.PresignOptions,
+) (PresignedURL, error) {
+	key, _, err := findObject(ctx, s.repo, idParam, uuidParam, variant)
 	if err != nil {
-		return nil, fmt.Errorf("не удалось получить список файлов: %w", err)
+		return PresignedURL{}, err
 	}
-	if len(objects) == 0 {
-		return nil, fmt.Errorf("файл с префиксом %q не найден", prefix)
+	expiresAt := time.Now().Add(opts.Expires).UTC().Truncate(time.Second)
+	presignedURL, err := s.repo.PresignGetURL(ctx, key, opts)
+	if err != nil {
+		return PresignedURL{}, fmt.Errorf("не удалось подписать ссылку: %w", err)
 	}
+	return PresignedURL{Key: key, URL: presignedURL, ExpiresAt: expiresAt}, nil
+}
 
-	var keys []string
-	for _, obj := range objects {
-		keys = append(keys, *obj.Key)
-	}
+// FileObject — описание файла в списках: ключ, разобранные из него :id и :uuid и метаданные
+// из листинга хранилища.
+type FileObject struct {
+	Key  string `json:"key"`
+	ID   string `json:"id,omitempty"`
+	UUID string `json:"uuid,omitempty"`
+	// Variant — имя уменьшенной копии (photos/:id/:uuid_<variant>.ext); пусто для оригинала.
+	Variant      string    `json:"variant,omitempty"`
+	URL          string    `json:"url"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag,omitempty"`
+	StorageClass string    `json:"storage_class,omitempty"`
+}
 
-	if err := s.repo.DeleteFilesBatch(ctx, keys); err != nil {
-		return nil, fmt.Errorf("ошибка удаления: %w", err)
+// URLs возвращает URL файлов — прежний формат ответа списков.
+func URLs(files []FileObject) []string {
+	urls := make([]string, 0, len(files))
+	for _, f := range files {
+		urls = append(urls, f.URL)
 	}
-	return keys, nil
+	return urls
 }
 
-// ListAllFiles — возвращает список URL всех файлов из S3 бакета.
-func (s *S3Service) ListAllFiles(ctx context.Context) ([]string, error) {
-	objects, err := s.repo.ListAllFiles(ctx)
+// FolderInfoQuery — параметры GET /objects/exists: папка и страница её файлов.
+type FolderInfoQuery struct {
+	Folder string `form:"folder"`
+	// Limit — сколько файлов вернуть, не больше repository.ListPageSize; по умолчанию DefaultListLimit.
+	Limit  int    `form:"limit" binding:"min=0,max=1000"`
+	Cursor string `form:"cursor"`
+}
+
+// FolderInfo — существует ли папка и страница её файлов.
+type FolderInfo struct {
+	Folder     string
+	Exists     bool
+	Files      []FileObject
+	NextCursor string
+}
+
+// GetFolderInfo — возвращает информацию о папке: существует ли она (запрос одного ключа)
+// и страницу её файлов размером query.Limit, как ListFiles.
+func (s *S3Service) GetFolderInfo(ctx context.Context, query FolderInfoQuery) (*FolderInfo, error) {
+	// Приводим имя папки к корректному виду: заканчивается слэшом.
+	prefix := query.Folder
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	exists, err := s.repo.FolderExists(ctx, prefix)
 	if err != nil {
-		return nil, fmt.Errorf("не удалось получить список файлов: %w", err)
+		return nil, fmt.Errorf("ошибка проверки папки %s: %w", prefix, err)
+	}
+	info := &FolderInfo{Folder: query.Folder, Exists: exists}
+	if !exists {
+		return info, nil
 	}
 
-	var fileURLs []string
-	for _, obj := range objects {
-		if obj.Key == nil {
-			continue
-		}
-		fileURL := fmt.Sprintf("https://%s.s3.timeweb.cloud/%s", s.repo.BucketName, *obj.Key)
-		fileURLs = append(fileURLs, fileURL)
+	page, err := s.ListFiles(ctx, ListQuery{Prefix: prefix, Limit: query.Limit, Cursor: query.Cursor})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения файлов для папки %s: %w", prefix, err)
 	}
-	return fileURLs, nil
+	info.Files, info.NextCursor = page.Files, page.NextCursor
+	return info, nil
 }
 
-// ListFilesInFolder — возвращает список URL файлов по заданному префиксу (папке).
-func (s *S3Service) ListFilesInFolder(ctx context.Context, folderName string) ([]string, error) {
-	// Если folderName не заканчивается слэшем, дополняем его.
-	if folderName[len(folderName)-1] != '/' {
-		folderName += "/"
+// fileObject дополняет метаданные объекта URL и частями ключа photos/:id/:uuid[_variant].ext.
+// Листинг S3 не возвращает Content-Type, поэтому он определяется по расширению.
+func (s *S3Service) fileObject(obj repository.ObjectInfo) FileObject {
+	file := FileObject{
+		Key:          obj.Key,
+		URL:          s.repo.FileURL(obj.Key),
+		Size:         obj.Size,
+		ContentType:  obj.ContentType,
+		LastModified: obj.LastModified,
+		ETag:         obj.ETag,
+		StorageClass: obj.StorageClass,
 	}
-	objects, err := s.repo.ListFilesByPrefix(ctx, folderName)
-	if err != nil {
-		return nil, fmt.Errorf("не удалось получить файлы по префиксу %s: %w", folderName, err)
+	ext := path.Ext(obj.Key)
+	if file.ContentType == "" {
+		file.ContentType = imaging.ContentTypeByExtension(ext)
 	}
-	var fileURLs []string
-	for _, obj := range objects {
-		if obj.Key != nil {
-			fileURL := fmt.Sprintf("https://%s.s3.timeweb.cloud/%s", s.repo.BucketName, *obj.Key)
-			fileURLs = append(fileURLs, fileURL)
+	parts := strings.Split(obj.Key, "/")
+	if len(parts) == 3 && parts[0] == "photos" {
+		file.ID = parts[1]
+		fileUUID, variant, _ := strings.Cut(strings.TrimSuffix(parts[2], ext), "_")
+		if _, err := uuid.Parse(fileUUID); err == nil {
+			file.UUID, file.Variant = fileUUID, variant
 		}
 	}
-	return fileURLs, nil
+	return file
 }
 
-// GetFolderInfo — возвращает информацию о папке: существует ли папка и список файлов в ней.
-func (s *S3Service) GetFolderInfo(ctx context.Context, folderName string) (bool, []string, error) {
-	// Приводим folderName к корректному виду: заканчивается слэшом.
-	if folderName[len(folderName)-1] != '/' {
-		folderName += "/"
+// findOriginal ищет оригинал photos/:id/:uuid.ext (варианты вида :uuid_thumb не подходят).
+// Возвращает ключ и MIME-тип по расширению.
+func findOriginal(ctx context.Context, repo repository.Storage, idParam, uuidParam string) (string, string, error) {
+	return findObject(ctx, repo, idParam, uuidParam, "")
+}
+
+// findObject ищет оригинал или, если variant не пуст, его копию photos/:id/:uuid_<variant>.ext.
+func findObject(ctx context.Context, repo repository.Storage, idParam, uuidParam, variant string) (string, string, error) {
+	base := fmt.Sprintf("photos/%s/%s", idParam, uuidParam)
+	if _, err := uuid.Parse(uuidParam); err != nil || idParam == "" || strings.Contains(idParam, "/") {
+		return "", "", fmt.Errorf("%w: %s", repository.ErrNotFound, base)
 	}
-	fileURLs, err := s.ListFilesInFolder(ctx, folderName)
+	if variant != "" {
+		if !imaging.IsVariantName(variant) {
+			return "", "", fmt.Errorf("%w: %s_%s", repository.ErrNotFound, base, variant)
+		}
+		base += "_" + variant
+	}
+	// Оригинал с данным UUID один, поэтому достаточно первого ключа
+	page, err := repo.ListPage(ctx, repository.ListPageOptions{Prefix: base + ".", Limit: 1})
 	if err != nil {
-		return false, nil, fmt.Errorf("ошибка получения файлов для папки %s: %w", folderName, err)
+		return "", "", fmt.Errorf("не удалось получить список файлов: %w", err)
+	}
+	if len(page.Objects) == 0 {
+		return "", "", fmt.Errorf("%w: %s", repository.ErrNotFound, base)
 	}
-	// Если файлов нет, считаем, что папка не существует.
-	exists := len(fileURLs) > 0
-	return exists, fileURLs, nil
+	key := page.Objects[0].Key
+	return key, imaging.ContentTypeByExtension(key[strings.LastIndexByte(key, '.'):]), nil
 }
@@ -0,0 +1,452 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"files/internal/repository"
+	"files/pkg/imaging"
+	"files/pkg/log"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Версия и расширения протокола tus, которые поддерживает сервис.
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,termination,expiration"
+)
+
+// tusStatePrefix — префикс служебных объектов tus в хранилище: состояние загрузки (<id>.json),
+// принятые байты, которых ещё не хватает на часть multipart-загрузки (<id>.pending),
+// и приватный собранный файл (<id>.bin), который попадает в photos/:id только после проверки.
+const tusStatePrefix = "uploads/tus/"
+
+var (
+	// ErrUploadExpired — срок незавершённой загрузки истёк.
+	ErrUploadExpired = errors.New("срок загрузки истёк")
+	// ErrOffsetMismatch — Upload-Offset запроса не совпадает со смещением загрузки.
+	ErrOffsetMismatch = errors.New("Upload-Offset не совпадает с текущим смещением загрузки")
+	// ErrUploadLocked — загрузку в этот момент дописывает другой запрос.
+	ErrUploadLocked = errors.New("загрузка уже обрабатывается другим запросом")
+	// ErrUploadTooLarge — размер загрузки больше допустимого или данных больше, чем заявлено.
+	ErrUploadTooLarge = errors.New("превышен допустимый размер загрузки")
+)
+
+// TusUpload — состояние загрузки по протоколу tus. Хранится в хранилище рядом с файлами,
+// поэтому загрузку можно продолжить после перезапуска сервиса.
+type TusUpload struct {
+	ID       string `json:"id"`
+	FolderID string `json:"folder_id"`
+	// Key — итоговый ключ файла photos/:id/uuid.ext. До проверки файл собирается под stagedKey.
+	Key string `json:"key"`
+	// MultipartID — идентификатор multipart-загрузки под stagedKey; пусто, когда объект уже собран.
+	MultipartID string `json:"multipart_id,omitempty"`
+	Length      int64  `json:"length"`
+	Offset      int64  `json:"offset"`
+	// Metadata — заголовок Upload-Metadata в исходном виде.
+	Metadata string                     `json:"metadata,omitempty"`
+	Parts    []repository.CompletedPart `json:"parts,omitempty"`
+	// Pending — сколько байтов из Offset ещё не вошло в части и лежит в объекте <id>.pending.
+	Pending   int64     `json:"pending,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Result — загруженный файл; заполняется после проверки собранного объекта.
+	Result *UploadedFile `json:"result,omitempty"`
+}
+
+// TusService реализует загрузку по протоколу tus поверх multipart-загрузки хранилища.
+// Принятые байты копятся до PartSize и уходят в хранилище частями; собранный приватный файл
+// проверяется так же, как загруженный напрямую (ConfirmDirectUpload).
+type TusService struct {
+	repo    repository.Storage
+	uploads *S3Service
+	// Expiration — сколько живёт незавершённая загрузка после последнего PATCH.
+	Expiration time.Duration
+	// CleanupInterval — период удаления просроченных загрузок (RunCleanup).
+	CleanupInterval time.Duration
+	// PartSize — размер части multipart-загрузки (не меньше repository.MinPartSize).
+	PartSize int64
+	// locks не даёт двум запросам одновременно дописывать одну загрузку в пределах экземпляра сервиса.
+	locks uploadLocks
+}
+
+// NewTusService — конструктор.
+func NewTusService(repo repository.Storage, uploads *S3Service, expiration, cleanupInterval time.Duration) *TusService {
+	return &TusService{
+		repo:            repo,
+		uploads:         uploads,
+		Expiration:      expiration,
+		CleanupInterval: cleanupInterval,
+		PartSize:        repository.MinPartSize,
+	}
+}
+
+// Create — расширение creation: проверяет имя и тип файла из Upload-Metadata (ключи filename
+// и filetype), выбирает ключ photos/:id/uuid.ext и начинает приватную multipart-загрузку
+// под uploads/tus/<upload>.bin.
+func (s *TusService) Create(
+	ctx context.Context,
+	idParam string,
+	length int64,
+	metadata string,
+	policy UploadPolicy,
+) (*TusUpload, error) {
+	meta, err := parseTusMetadata(metadata)
+	if err != nil {
+		return nil, &FileRejectedError{FileName: "Upload-Metadata", Reason: err.Error()}
+	}
+	fileName := meta["filename"]
+	if fileName == "" {
+		fileName = meta["name"]
+	}
+	if fileName == "" {
+		return nil, &FileRejectedError{FileName: "Upload-Metadata", Reason: "Не указано имя файла (filename)"}
+	}
+	if err := policy.checkFileName(fileName); err != nil {
+		return nil, err
+	}
+	contentType := meta["filetype"]
+	if contentType == "" {
+		contentType = imaging.ContentTypeByExtension(path.Ext(fileName))
+	}
+	if err := policy.checkContentType(fileName, contentType); err != nil {
+		return nil, err
+	}
+
+	maxSize := policy.MaxFileSize
+	if maxSize <= 0 {
+		maxSize = maxDirectUploadSize
+	}
+	if length > maxSize {
+		return nil, fmt.Errorf("%w: %d больше %d байт", ErrUploadTooLarge, length, maxSize)
+	}
+	if length <= 0 {
+		return nil, &FileRejectedError{FileName: fileName, Reason: "Пустой файл"}
+	}
+
+	ext := imaging.ExtensionForContentType(path.Ext(fileName), contentType)
+	uploadID := uuid.New().String()
+	// До проверки файл не должен открываться по публичной ссылке
+	multipartID, err := s.repo.CreateMultipartUpload(ctx, stagedKey(uploadID), contentType, repository.VisibilityPrivate)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать загрузку: %w", err)
+	}
+
+	upload := &TusUpload{
+		ID:          uploadID,
+		FolderID:    idParam,
+		Key:         fmt.Sprintf("photos/%s/%s%s", idParam, uuid.New().String(), ext),
+		MultipartID: multipartID,
+		Length:      length,
+		Metadata:    metadata,
+		ExpiresAt:   s.expiresAt(),
+	}
+	if err := s.save(ctx, upload); err != nil {
+		_ = s.repo.AbortMultipartUpload(context.WithoutCancel(ctx), stagedKey(uploadID), multipartID)
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Get возвращает состояние загрузки. Незавершённая загрузка с истёкшим сроком — ErrUploadExpired.
+func (s *TusService) Get(ctx context.Context, idParam, uploadID string) (*TusUpload, error) {
+	upload, err := s.load(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.FolderID != idParam {
+		return nil, fmt.Errorf("%w: загрузка %s", repository.ErrNotFound, uploadID)
+	}
+	if upload.Result == nil && time.Now().After(upload.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	return upload, nil
+}
+
+// Write — PATCH: дописывает тело запроса с позиции offset. size — Content-Length запроса
+// (-1, если неизвестен). Части по PartSize отправляются в хранилище сразу, остаток сохраняется
+// отдельным объектом, поэтому при обрыве соединения принятые байты не теряются.
+// Когда получены все байты, объект собирается и проверяется.
+func (s *TusService) Write(
+	ctx context.Context,
+	idParam, uploadID string,
+	offset int64,
+	body io.Reader,
+	size int64,
+	policy UploadPolicy,
+) (*TusUpload, error) {
+	if err := checkUploadID(uploadID); err != nil {
+		return nil, err
+	}
+	unlock, ok := s.locks.tryLock(uploadID)
+	if !ok {
+		return nil, ErrUploadLocked
+	}
+	defer unlock()
+
+	upload, err := s.Get(ctx, idParam, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+	if size > upload.Length-upload.Offset {
+		return upload, fmt.Errorf("%w: осталось %d байт", ErrUploadTooLarge, upload.Length-upload.Offset)
+	}
+	if upload.Result != nil {
+		return upload, nil
+	}
+
+	// Клиент может оборвать соединение посреди запроса — принятое всё равно сохраняем
+	ctx = context.WithoutCancel(ctx)
+	upload.ExpiresAt = s.expiresAt()
+	if err := s.writeParts(ctx, upload, io.LimitReader(body, upload.Length-upload.Offset)); err != nil {
+		return upload, err
+	}
+	if upload.Offset < upload.Length {
+		return upload, nil
+	}
+	return upload, s.finish(ctx, upload, policy)
+}
+
+// writeParts дописывает body к накопленному остатку и отправляет в хранилище заполненные части.
+func (s *TusService) writeParts(ctx context.Context, upload *TusUpload, body io.Reader) error {
+	buf := make([]byte, 0, s.PartSize)
+	if upload.Pending > 0 {
+		pending, err := s.repo.GetFileRange(ctx, pendingKey(upload.ID), 0, upload.Pending)
+		if err != nil {
+			return fmt.Errorf("не удалось прочитать принятые данные: %w", err)
+		}
+		n, err := io.ReadFull(pending, buf[:upload.Pending])
+		_ = pending.Close()
+		if err != nil {
+			return fmt.Errorf("не удалось прочитать принятые данные: %w", err)
+		}
+		buf = buf[:n]
+	}
+	// Байты, уже сохранённые в частях
+	stored := upload.Offset - upload.Pending
+
+	var readErr error
+	for readErr == nil {
+		var n int
+		n, readErr = io.ReadFull(body, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		last := stored+int64(len(buf)) == upload.Length
+		if len(buf) == 0 || (len(buf) < cap(buf) && !last) {
+			continue
+		}
+
+		number := int32(len(upload.Parts) + 1)
+		etag, err := s.repo.UploadPart(ctx, stagedKey(upload.ID), upload.MultipartID, number, bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			return fmt.Errorf("не удалось сохранить часть %d: %w", number, err)
+		}
+		stored += int64(len(buf))
+		upload.Parts = append(upload.Parts, repository.CompletedPart{Number: number, ETag: etag, Size: int64(len(buf))})
+		upload.Offset, upload.Pending = stored, 0
+		buf = buf[:0]
+		if err := s.save(ctx, upload); err != nil {
+			return err
+		}
+		if last {
+			break
+		}
+	}
+
+	if len(buf) > 0 {
+		_, err := s.repo.UploadFile(ctx, pendingKey(upload.ID), "application/octet-stream",
+			bytes.NewReader(buf), repository.VisibilityPrivate)
+		if err != nil {
+			return fmt.Errorf("не удалось сохранить принятые данные: %w", err)
+		}
+		upload.Offset, upload.Pending = stored+int64(len(buf)), int64(len(buf))
+	}
+	if err := s.save(ctx, upload); err != nil {
+		return err
+	}
+	if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+		return readErr
+	}
+	return nil
+}
+
+// finish собирает приватный объект из частей, проверяет его и копирует под Key с видимостью маршрута.
+// Отклонённый файл удаляется вместе с загрузкой; после сбоя хранилища завершение повторяется
+// следующим PATCH, а брошенную загрузку удалит CleanupExpired.
+func (s *TusService) finish(ctx context.Context, upload *TusUpload, policy UploadPolicy) error {
+	if upload.MultipartID != "" {
+		if err := completeMultipart(ctx, s.repo, stagedKey(upload.ID), upload.MultipartID, upload.Parts); err != nil {
+			return err
+		}
+		upload.MultipartID = ""
+		if err := s.save(ctx, upload); err != nil {
+			return err
+		}
+	}
+
+	file, err := s.uploads.confirmUpload(ctx, upload.FolderID, stagedKey(upload.ID), upload.Key, policy)
+	if err != nil {
+		var rejected *FileRejectedError
+		if errors.As(err, &rejected) {
+			s.remove(ctx, upload)
+		}
+		return err
+	}
+	upload.Result = &file
+	if err := s.save(ctx, upload); err != nil {
+		return err
+	}
+	_ = s.repo.DeleteFile(ctx, pendingKey(upload.ID))
+	return nil
+}
+
+// Terminate — расширение termination: отменяет загрузку и удаляет принятые данные.
+// Уже загруженный файл остаётся (его удаляет DELETE /upload/:id/:uuid).
+func (s *TusService) Terminate(ctx context.Context, idParam, uploadID string) error {
+	if err := checkUploadID(uploadID); err != nil {
+		return err
+	}
+	unlock, ok := s.locks.tryLock(uploadID)
+	if !ok {
+		return ErrUploadLocked
+	}
+	defer unlock()
+
+	upload, err := s.load(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+	if upload.FolderID != idParam {
+		return fmt.Errorf("%w: загрузка %s", repository.ErrNotFound, uploadID)
+	}
+	s.remove(context.WithoutCancel(ctx), upload)
+	return nil
+}
+
+// CleanupExpired удаляет загрузки, срок которых истёк к моменту now, вместе с частями
+// и принятыми данными, а также остатки без состояния. Возвращает число удалённых загрузок.
+func (s *TusService) CleanupExpired(ctx context.Context, now time.Time) (int, error) {
+	removed := 0
+	err := listGroups(ctx, s.repo, tusStatePrefix, func(key string) string {
+		base := strings.TrimPrefix(key, tusStatePrefix)
+		return strings.TrimSuffix(base, path.Ext(base))
+	}, func(uploadID string, objects []repository.ObjectInfo) {
+		hasState := slices.ContainsFunc(objects, func(obj repository.ObjectInfo) bool {
+			return obj.Key == stateKey(uploadID)
+		})
+		if !hasState {
+			for _, obj := range objects {
+				if now.Sub(obj.LastModified) > s.Expiration {
+					_ = s.repo.DeleteFile(ctx, obj.Key)
+				}
+			}
+			return
+		}
+
+		unlock, ok := s.locks.tryLock(uploadID)
+		if !ok {
+			return
+		}
+		defer unlock()
+		upload, err := s.load(ctx, uploadID)
+		if err == nil && now.After(upload.ExpiresAt) {
+			s.remove(ctx, upload)
+			removed++
+		}
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Error("Failed to load tus upload", zap.String("upload", uploadID), zap.Error(err))
+		}
+	})
+	return removed, err
+}
+
+// RunCleanup раз в CleanupInterval удаляет просроченные загрузки, пока не отменён ctx.
+func (s *TusService) RunCleanup(ctx context.Context) {
+	runCleanup(ctx, "tus uploads", s.CleanupInterval, s.CleanupExpired)
+}
+
+// remove отменяет multipart-загрузку и удаляет служебные объекты вместе с собранным, но не
+// подтверждённым файлом. Ошибки только логируются: оставшееся удалит следующая очистка
+// или правило жизненного цикла бакета.
+func (s *TusService) remove(ctx context.Context, upload *TusUpload) {
+	if upload.MultipartID != "" {
+		err := s.repo.AbortMultipartUpload(ctx, stagedKey(upload.ID), upload.MultipartID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Error("Failed to abort multipart upload", zap.String("key", stagedKey(upload.ID)), zap.Error(err))
+		}
+	}
+	err := s.repo.DeleteFilesBatch(ctx, []string{pendingKey(upload.ID), stagedKey(upload.ID), stateKey(upload.ID)})
+	if err != nil {
+		log.Error("Failed to delete tus upload state", zap.String("upload", upload.ID), zap.Error(err))
+	}
+}
+
+func (s *TusService) load(ctx context.Context, uploadID string) (*TusUpload, error) {
+	if err := checkUploadID(uploadID); err != nil {
+		return nil, err
+	}
+	var upload TusUpload
+	if err := loadState(ctx, s.repo, stateKey(uploadID), &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (s *TusService) save(ctx context.Context, upload *TusUpload) error {
+	return saveState(ctx, s.repo, stateKey(upload.ID), upload)
+}
+
+func (s *TusService) expiresAt() time.Time {
+	return time.Now().Add(s.Expiration).UTC().Truncate(time.Second)
+}
+
+// checkUploadID отсекает идентификаторы, которые сервис не мог выдать, до обращения к хранилищу.
+func checkUploadID(uploadID string) error {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return fmt.Errorf("%w: загрузка %q", repository.ErrNotFound, uploadID)
+	}
+	return nil
+}
+
+func stateKey(uploadID string) string {
+	return tusStatePrefix + uploadID + ".json"
+}
+
+func pendingKey(uploadID string) string {
+	return tusStatePrefix + uploadID + ".pending"
+}
+
+func stagedKey(uploadID string) string {
+	return tusStatePrefix + uploadID + ".bin"
+}
+
+// parseTusMetadata разбирает Upload-Metadata: пары "ключ base64(значение)" через запятую,
+// значение может отсутствовать.
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("пустой ключ в Upload-Metadata")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("значение %q в Upload-Metadata не в base64", key)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
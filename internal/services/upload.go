@@ -0,0 +1,485 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"files/internal/repository"
+	"files/pkg/imaging"
+	"files/pkg/log"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// orientedQuality — качество, с которым перекодируется изображение, повёрнутое по EXIF Orientation.
+const orientedQuality = 95
+
+// UploadedFile — результат загрузки одного файла.
+type UploadedFile struct {
+	Key  string `json:"key"`
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+	// Private — файл приватный: URL без подписи не открывается, ссылку выдаёт /presign.
+	Private bool `json:"private,omitempty"`
+	// Variants — URL уменьшенных копий по имени варианта (thumb, preview, ...).
+	Variants map[string]string `json:"variants,omitempty"`
+	// WebP — копия в WebP, если маршрут конвертирует изображения.
+	WebP *ConvertedFile `json:"webp,omitempty"`
+}
+
+// ConvertedFile — изображение, сконвертированное в другой формат.
+type ConvertedFile struct {
+	Key          string `json:"key"`
+	URL          string `json:"url"`
+	Size         int64  `json:"size"`
+	OriginalSize int64  `json:"original_size"`
+	// SavedBytes — на сколько байт копия меньше оригинала (отрицательное значение — больше).
+	SavedBytes int64 `json:"saved_bytes"`
+}
+
+// UploadMultiple — читает файлы из multipart.Reader и по одному передаёт их в хранилище потоком.
+// Каждый part проверяется по policy до и во время записи. Если какой-либо файл отклонён или запись
+// не удалась, все файлы, уже сохранённые в рамках этого запроса, удаляются.
+func (s *S3Service) UploadMultiple(
+	ctx context.Context,
+	idParam string,
+	multipartReader *multipart.Reader,
+	policy UploadPolicy,
+) (files []UploadedFile, err error) {
+	// Префикс (папка) в хранилище, напр. photos/123
+	prefix := fmt.Sprintf("photos/%s", idParam)
+	var uploadedKeys []string
+
+	defer func() {
+		if err != nil && len(uploadedKeys) > 0 {
+			s.rollback(ctx, uploadedKeys)
+		}
+	}()
+
+	// Читаем части (part) из multipart.Reader
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: ошибка чтения part: %w", ErrMalformedUpload, err)
+		}
+
+		fileName := part.FileName()
+		if fileName == "" {
+			continue
+		}
+
+		file, storedKeys, err := s.uploadPart(ctx, prefix, fileName, part, policy)
+		uploadedKeys = append(uploadedKeys, storedKeys...)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: нет файлов", ErrMalformedUpload)
+	}
+
+	return files, nil
+}
+
+// uploadPart проверяет и сохраняет один файл вместе с его вариантами. Возвращает все записанные ключи,
+// даже когда затем файл отклонён — такие ключи нужно удалить при откате.
+func (s *S3Service) uploadPart(
+	ctx context.Context,
+	prefix, fileName string,
+	part io.Reader,
+	policy UploadPolicy,
+) (UploadedFile, []string, error) {
+	var file UploadedFile
+	if err := policy.checkFileName(fileName); err != nil {
+		return file, nil, err
+	}
+
+	// Определяем реальный тип по первым байтам. bufio.Reader отдаёт их повторно при записи,
+	// поэтому part по-прежнему читается потоком.
+	body := bufio.NewReaderSize(part, imaging.SniffLen)
+	head, err := body.Peek(imaging.SniffLen)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return file, nil, fmt.Errorf("%w: ошибка чтения part: %w", ErrMalformedUpload, err)
+	}
+	contentType := imaging.DetectContentType(head)
+	if err := policy.checkContentType(fileName, contentType); err != nil {
+		return file, nil, err
+	}
+
+	// Расширение ключа соответствует реальному типу: image.jpg с PNG внутри сохраняется как .png
+	ext := imaging.ExtensionForContentType(path.Ext(fileName), contentType)
+	fileUUID := uuid.New().String()
+	// Формируем ключ: photos/123/uuid.png
+	s3Key := fmt.Sprintf("%s/%s%s", prefix, fileUUID, ext)
+	return s.storePart(ctx, partSource{
+		prefix:      prefix,
+		fileUUID:    fileUUID,
+		key:         s3Key,
+		fileName:    fileName,
+		contentType: contentType,
+	}, body, policy)
+}
+
+// partSource — файл, ключ и тип которого уже определены.
+type partSource struct {
+	prefix      string
+	fileUUID    string
+	key         string
+	fileName    string
+	contentType string
+	// stored — файл уже лежит в хранилище (прямая загрузка, tus, сессии) под stored.Key. Если его
+	// не нужно изменять, он не записывается повторно: под key он копируется только после проверки,
+	// а при stored.Key == key остаётся на месте.
+	stored *repository.ObjectInfo
+}
+
+// storePart сохраняет файл вместе с его вариантами и WebP-копией. Возвращает все записанные ключи,
+// даже когда затем файл отклонён — такие ключи нужно удалить при откате.
+func (s *S3Service) storePart(
+	ctx context.Context,
+	src partSource,
+	body io.Reader,
+	policy UploadPolicy,
+) (UploadedFile, []string, error) {
+	var file UploadedFile
+	private := policy.visibility() == repository.VisibilityPrivate
+
+	if !policy.decodesImages() {
+		if src.stored != nil {
+			storedKeys, err := s.copyStored(ctx, src, policy)
+			if err != nil {
+				return file, nil, err
+			}
+			file = UploadedFile{Key: src.key, URL: s.repo.FileURL(src.key), Size: src.stored.Size, Private: private}
+			return file, storedKeys, nil
+		}
+		counter := &countingReader{r: body}
+		fileURL, err := s.repo.UploadFile(ctx, src.key, src.contentType, counter, policy.visibility())
+		if err != nil {
+			return file, nil, counter.storageError(err)
+		}
+		file = UploadedFile{Key: src.key, URL: fileURL, Size: counter.n, Private: private}
+		return file, []string{src.key}, nil
+	}
+
+	var img image.Image
+	var size int64
+	var storedKeys []string
+	var err error
+	if policy.stripsMetadata(src.contentType) {
+		file, img, size, storedKeys, err = s.uploadSanitized(ctx, src, body, policy)
+	} else {
+		file, img, size, storedKeys, err = s.uploadDecoded(ctx, src, body, policy)
+	}
+	if err != nil {
+		return file, storedKeys, err
+	}
+	file.Private = private
+
+	if policy.convertsToWebP(src.contentType) {
+		webpKey := fmt.Sprintf("%s/%s.webp", src.prefix, src.fileUUID)
+		converted, err := s.uploadWebP(ctx, webpKey, img, size, policy)
+		if err != nil {
+			return file, storedKeys, err
+		}
+		storedKeys = append(storedKeys, converted.Key)
+		file.WebP = converted
+		if !policy.keepsOriginal(src.contentType) {
+			file.Key, file.URL, file.Size = converted.Key, converted.URL, converted.Size
+		}
+	}
+
+	for _, variant := range policy.Variants {
+		variantKey, variantURL, err := s.uploadVariant(ctx, file.Key, img, policy.variantType(src.contentType), variant, policy.visibility())
+		if err != nil {
+			return file, storedKeys, err
+		}
+		storedKeys = append(storedKeys, variantKey)
+		if file.Variants == nil {
+			file.Variants = make(map[string]string, len(policy.Variants))
+		}
+		file.Variants[variant.Name] = variantURL
+	}
+	return file, storedKeys, nil
+}
+
+// uploadDecoded сохраняет изображение потоком, декодируя его параллельно с записью: байты, уходящие
+// в хранилище, одновременно попадают в декодер через io.Pipe. Возвращает описание сохранённого
+// оригинала (пустое, если оригинал не хранится), декодированное изображение и размер файла.
+func (s *S3Service) uploadDecoded(
+	ctx context.Context,
+	src partSource,
+	body io.Reader,
+	policy UploadPolicy,
+) (UploadedFile, image.Image, int64, []string, error) {
+	var file UploadedFile
+	key, fileName, contentType := src.key, src.fileName, src.contentType
+	validator := startImageValidation(policy.imageLimits())
+	counter := &countingReader{r: body}
+	keepOriginal := policy.keepsOriginal(contentType)
+	// Уже сохранённый оригинал только декодируется
+	upload := keepOriginal && src.stored == nil
+	fileURL := s.repo.FileURL(key)
+	var uploadErr error
+	if upload {
+		fileURL, uploadErr = s.repo.UploadFile(ctx, key, contentType, io.TeeReader(counter, validator), policy.visibility())
+	} else {
+		// Оригинал не записывается (будет только WebP или он уже в хранилище) — поток идёт лишь в декодер
+		_, uploadErr = io.Copy(validator, counter)
+	}
+	decoderFailed := validator.failed.Load()
+	img, format, decodeErr := validator.finish(uploadErr)
+
+	if uploadErr != nil {
+		// Запись прервал декодер — значит, файл отклонён, а не сломано хранилище
+		if decoderFailed {
+			return file, nil, 0, nil, &FileRejectedError{FileName: fileName, Reason: decodeErr.Error()}
+		}
+		return file, nil, 0, nil, counter.storageError(uploadErr)
+	}
+	var storedKeys []string
+	if upload {
+		storedKeys = append(storedKeys, key)
+	}
+	if decodeErr != nil {
+		return file, nil, 0, storedKeys, &FileRejectedError{FileName: fileName, Reason: decodeErr.Error()}
+	}
+	if err := checkFormat(fileName, format, contentType); err != nil {
+		return file, nil, 0, storedKeys, err
+	}
+	if keepOriginal && src.stored != nil {
+		storedKeys, uploadErr = s.copyStored(ctx, src, policy)
+		if uploadErr != nil {
+			return file, nil, 0, nil, uploadErr
+		}
+	}
+	if keepOriginal {
+		file = UploadedFile{Key: key, URL: fileURL, Size: counter.n}
+	}
+	return file, img, counter.n, storedKeys, nil
+}
+
+// uploadSanitized сохраняет изображение без метаданных. Файл читается в память целиком (объём
+// ограничен лимитом запроса): EXIF в WebP обычно лежит в конце файла, а повёрнутое по Orientation
+// изображение приходится перекодировать.
+func (s *S3Service) uploadSanitized(
+	ctx context.Context,
+	src partSource,
+	body io.Reader,
+	policy UploadPolicy,
+) (UploadedFile, image.Image, int64, []string, error) {
+	var file UploadedFile
+	key, fileName, contentType := src.key, src.fileName, src.contentType
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return file, nil, 0, nil, fmt.Errorf("%w: ошибка чтения part: %w", ErrMalformedUpload, err)
+	}
+	img, format, err := imaging.Decode(bytes.NewReader(data), policy.imageLimits())
+	if err != nil {
+		return file, nil, 0, nil, &FileRejectedError{FileName: fileName, Reason: err.Error()}
+	}
+	if err := checkFormat(fileName, format, contentType); err != nil {
+		return file, nil, 0, nil, err
+	}
+
+	meta := imaging.ReadMetadata(data, contentType)
+	keep := policy.Privacy.KeepTags
+	if meta.Orientation > 1 {
+		// Варианты и WebP-копия строятся из уже повёрнутых пикселей
+		img = imaging.Orient(img, meta.Orientation)
+		if imaging.CanEncode(contentType) {
+			var buf bytes.Buffer
+			if err := imaging.Reencode(&buf, img, data, contentType, orientedQuality); err != nil {
+				return file, nil, 0, nil, fmt.Errorf("ошибка кодирования повёрнутого изображения: %w", err)
+			}
+			data = buf.Bytes()
+		} else {
+			// Сборка без cgo не кодирует WebP: оригинал не перекодируется, а Orientation
+			// остаётся в EXIF, чтобы файл отображался правильно
+			keep = append(slices.Clip(keep), imaging.OrientationTag)
+		}
+	}
+	cleaned, err := imaging.StripMetadata(data, contentType, meta, keep)
+	if err != nil {
+		return file, nil, 0, nil, &FileRejectedError{FileName: fileName, Reason: err.Error()}
+	}
+	size := int64(len(cleaned))
+
+	if !policy.keepsOriginal(contentType) {
+		return file, img, size, nil, nil
+	}
+	fileURL, err := s.repo.UploadFile(ctx, key, contentType, bytes.NewReader(cleaned), policy.visibility())
+	if err != nil {
+		return file, nil, 0, nil, fmt.Errorf("ошибка загрузки в хранилище: %w", err)
+	}
+	return UploadedFile{Key: key, URL: fileURL, Size: size}, img, size, []string{key}, nil
+}
+
+// copyStored копирует проверенный файл из src.stored.Key под src.key с видимостью маршрута.
+// Возвращает записанный ключ; файл, собранный на месте, не копируется.
+func (s *S3Service) copyStored(ctx context.Context, src partSource, policy UploadPolicy) ([]string, error) {
+	if src.stored.Key == src.key {
+		return nil, nil
+	}
+	if err := s.repo.CopyFile(ctx, src.stored.Key, src.key, policy.visibility()); err != nil {
+		return nil, fmt.Errorf("ошибка копирования в хранилище: %w", err)
+	}
+	return []string{src.key}, nil
+}
+
+// checkFormat сверяет формат, определённый декодером, с типом по сигнатуре.
+func checkFormat(fileName, format, contentType string) error {
+	if format == contentType {
+		return nil
+	}
+	return &FileRejectedError{
+		FileName: fileName,
+		Reason:   fmt.Sprintf("Формат изображения %q не совпадает с содержимым %q", format, contentType),
+	}
+}
+
+// uploadWebP кодирует изображение в WebP и сохраняет его под key.
+func (s *S3Service) uploadWebP(
+	ctx context.Context,
+	key string,
+	img image.Image,
+	originalSize int64,
+	policy UploadPolicy,
+) (*ConvertedFile, error) {
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, "image/webp", policy.WebP.Quality); err != nil {
+		return nil, fmt.Errorf("ошибка конвертации в WebP: %w", err)
+	}
+	size := int64(buf.Len())
+
+	fileURL, err := s.repo.UploadFile(ctx, key, "image/webp", &buf, policy.visibility())
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки WebP в хранилище: %w", err)
+	}
+	return &ConvertedFile{
+		Key:          key,
+		URL:          fileURL,
+		Size:         size,
+		OriginalSize: originalSize,
+		SavedBytes:   originalSize - size,
+	}, nil
+}
+
+// uploadVariant уменьшает изображение, кодирует его в variantType и сохраняет рядом с оригиналом.
+func (s *S3Service) uploadVariant(
+	ctx context.Context,
+	originalKey string,
+	img image.Image,
+	variantType string,
+	variant imaging.Variant,
+	visibility repository.Visibility,
+) (string, string, error) {
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, imaging.Fit(img, variant.MaxSize), variantType, imaging.DefaultQuality); err != nil {
+		return "", "", fmt.Errorf("ошибка создания варианта %q: %w", variant.Name, err)
+	}
+
+	key := VariantKey(originalKey, variant.Name, imaging.ExtensionForContentType("", variantType))
+	variantURL, err := s.repo.UploadFile(ctx, key, variantType, &buf, visibility)
+	if err != nil {
+		return "", "", fmt.Errorf("ошибка загрузки варианта %q в хранилище: %w", variant.Name, err)
+	}
+	return key, variantURL, nil
+}
+
+// VariantKey возвращает ключ варианта рядом с оригиналом:
+// photos/123/uuid.png + thumb → photos/123/uuid_thumb.png.
+// Ключ варианта начинается с photos/:id/:uuid, поэтому удаляется вместе с оригиналом
+// в DeleteOneByUUID и DeleteAllByID.
+func VariantKey(originalKey, variantName, ext string) string {
+	base := strings.TrimSuffix(originalKey, path.Ext(originalKey))
+	return base + "_" + variantName + ext
+}
+
+// rollback удаляет файлы, сохранённые в рамках неудавшейся загрузки.
+// Контекст запроса к этому моменту может быть отменён, поэтому используем контекст без отмены.
+func (s *S3Service) rollback(ctx context.Context, keys []string) {
+	if err := s.repo.DeleteFilesBatch(context.WithoutCancel(ctx), keys); err != nil {
+		log.Error("Failed to roll back uploaded files", zap.Strings("keys", keys), zap.Error(err))
+	}
+}
+
+// imageValidation декодирует изображение в отдельной горутине, читая его из io.Pipe.
+// Если декодер находит ошибку раньше, чем закончилась запись, он закрывает pipe с этой ошибкой,
+// и запись в хранилище прерывается.
+type imageValidation struct {
+	pw     *io.PipeWriter
+	done   chan struct{}
+	failed atomic.Bool
+
+	img    image.Image
+	format string
+	err    error
+}
+
+func startImageValidation(limits imaging.Limits) *imageValidation {
+	pr, pw := io.Pipe()
+	v := &imageValidation{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(v.done)
+		v.img, v.format, v.err = imaging.Decode(pr, limits)
+		if v.err != nil {
+			v.failed.Store(true)
+		}
+		_ = pr.CloseWithError(v.err)
+	}()
+	return v
+}
+
+// Write передаёт очередную порцию байт декодеру.
+func (v *imageValidation) Write(p []byte) (int, error) {
+	return v.pw.Write(p)
+}
+
+// finish сообщает декодеру о конце потока (или об ошибке записи) и ждёт результата.
+func (v *imageValidation) finish(uploadErr error) (image.Image, string, error) {
+	_ = v.pw.CloseWithError(uploadErr)
+	<-v.done
+	return v.img, v.format, v.err
+}
+
+// countingReader считает прочитанные байты и запоминает ошибку чтения тела запроса, чтобы
+// обрыв или превышение размера не выдавались за сбой хранилища.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+	return n, err
+}
+
+// storageError оборачивает ошибку записи: если её вызвало чтение тела запроса — это ошибка клиента.
+func (c *countingReader) storageError(err error) error {
+	if c.err != nil {
+		return fmt.Errorf("%w: ошибка чтения part: %w", ErrMalformedUpload, c.err)
+	}
+	return fmt.Errorf("ошибка загрузки в хранилище: %w", err)
+}
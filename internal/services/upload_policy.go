@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"files/internal/repository"
+	"files/pkg/imaging"
+)
+
+// UploadPolicy — правила приёма файлов, которые маршрут задаёт для UploadMultiple.
+// Пустые поля означают «без ограничений».
+type UploadPolicy struct {
+	// AllowedExtensions — белый список расширений в нижнем регистре, например ".png".
+	AllowedExtensions []string
+	// AllowedContentTypes — типы, которые допускаются по реальному содержимому (magic bytes).
+	AllowedContentTypes []string
+	// ImageLimits включает полное декодирование изображений (png, jpeg, gif, webp) с проверкой размеров.
+	// Повреждённые и обрезанные файлы, а также «decompression bomb» отклоняются.
+	ImageLimits *imaging.Limits
+	// Variants — уменьшенные копии, которые сохраняются рядом с оригиналом (требуют декодирования).
+	Variants []imaging.Variant
+	// WebP включает конвертацию JPEG и PNG в WebP (требует декодирования).
+	WebP *WebPConversion
+	// Privacy включает удаление метаданных (EXIF, XMP, IPTC) из JPEG, PNG и WebP.
+	Privacy *PrivacyMode
+	// Visibility — видимость сохраняемых файлов и их копий (по умолчанию public).
+	Visibility repository.Visibility
+	// MaxFileSize — наибольший размер файла, загружаемого напрямую в хранилище (0 — без ограничения).
+	// Загрузка через сервис ограничивается размером всего запроса (LimitRequestSizeMiddleware).
+	MaxFileSize int64
+}
+
+// PrivacyMode — параметры очистки метаданных загружаемых изображений.
+// Перед удалением EXIF пиксели поворачиваются по тегу Orientation.
+type PrivacyMode struct {
+	// KeepTags — теги EXIF, которые переносятся в очищенный файл (например, Copyright).
+	KeepTags []uint16
+}
+
+// WebPConversion — параметры конвертации загружаемых изображений в WebP.
+type WebPConversion struct {
+	// Quality — качество кодирования WebP (1–100).
+	Quality int
+	// KeepOriginal сохраняет и исходный файл; иначе хранится только WebP.
+	KeepOriginal bool
+}
+
+// convertsToWebP сообщает, конвертируется ли файл данного типа в WebP.
+// GIF не конвертируется, чтобы не потерять анимацию; WebP уже в нужном формате.
+func (p UploadPolicy) convertsToWebP(contentType string) bool {
+	return p.WebP != nil && (contentType == "image/jpeg" || contentType == "image/png")
+}
+
+// keepsOriginal сообщает, сохраняется ли исходный файл.
+func (p UploadPolicy) keepsOriginal(contentType string) bool {
+	return !p.convertsToWebP(contentType) || p.WebP.KeepOriginal
+}
+
+// variantType возвращает MIME-тип, в котором кодируются варианты.
+func (p UploadPolicy) variantType(contentType string) string {
+	if p.WebP != nil {
+		return "image/webp"
+	}
+	return imaging.EncodeFormat(contentType)
+}
+
+// stripsMetadata сообщает, очищаются ли метаданные файла данного типа.
+func (p UploadPolicy) stripsMetadata(contentType string) bool {
+	return p.Privacy != nil && imaging.SupportsMetadataStripping(contentType)
+}
+
+// visibility возвращает видимость файлов (public, если не задана).
+func (p UploadPolicy) visibility() repository.Visibility {
+	if p.Visibility == "" {
+		return repository.VisibilityPublic
+	}
+	return p.Visibility
+}
+
+// decodesImages сообщает, нужно ли декодировать загружаемые файлы.
+func (p UploadPolicy) decodesImages() bool {
+	return p.ImageLimits != nil || len(p.Variants) > 0 || p.WebP != nil || p.Privacy != nil
+}
+
+// imageLimits возвращает лимиты декодирования (без ограничений, если они не заданы).
+func (p UploadPolicy) imageLimits() imaging.Limits {
+	if p.ImageLimits == nil {
+		return imaging.Limits{}
+	}
+	return *p.ImageLimits
+}
+
+// ErrMalformedUpload — тело запроса загрузки не читается как multipart или не содержит файлов.
+// В отличие от сбоев хранилища это ошибка клиента.
+var ErrMalformedUpload = errors.New("некорректный multipart-запрос")
+
+// FileRejectedError — файл из multipart не прошёл проверку.
+// Все файлы, уже сохранённые в рамках запроса, к этому моменту удалены.
+type FileRejectedError struct {
+	FileName string
+	Reason   string
+}
+
+func (e *FileRejectedError) Error() string {
+	return fmt.Sprintf("файл %q отклонён: %s", e.FileName, e.Reason)
+}
+
+// checkFileName проверяет имя файла по политике.
+func (p UploadPolicy) checkFileName(fileName string) error {
+	if len(p.AllowedExtensions) == 0 {
+		return nil
+	}
+	ext := strings.ToLower(path.Ext(fileName))
+	for _, allowed := range p.AllowedExtensions {
+		if ext == allowed {
+			return nil
+		}
+	}
+	return &FileRejectedError{
+		FileName: fileName,
+		Reason:   fmt.Sprintf("Файл с расширением %q не разрешён", ext),
+	}
+}
+
+// checkContentType проверяет тип, определённый по содержимому файла.
+func (p UploadPolicy) checkContentType(fileName, detected string) error {
+	if len(p.AllowedContentTypes) == 0 {
+		return nil
+	}
+	for _, allowed := range p.AllowedContentTypes {
+		if detected == allowed {
+			return nil
+		}
+	}
+	return &FileRejectedError{
+		FileName: fileName,
+		Reason:   fmt.Sprintf("Содержимое файла не соответствует разрешённым типам (обнаружен %q)", detected),
+	}
+}
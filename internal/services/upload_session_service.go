@@ -0,0 +1,521 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"files/internal/repository"
+	"files/pkg/imaging"
+	"files/pkg/log"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// sessionStatePrefix — префикс служебных объектов сессий загрузки:
+// uploads/sessions/<session>/session.json, по объекту chunk-<номер>.json на принятый кусок
+// и приватный собранный файл object.bin, который попадает в photos/:id только после проверки.
+// Каждый кусок пишет только свой объект, поэтому куски можно отправлять параллельно.
+const sessionStatePrefix = "uploads/sessions/"
+
+var (
+	// ErrChecksumMismatch — SHA-256 принятого куска не совпадает с переданной клиентом.
+	ErrChecksumMismatch = errors.New("контрольная сумма куска не совпадает")
+	// ErrChunksMissing — при завершении сессии получены не все куски.
+	ErrChunksMissing = errors.New("получены не все куски")
+	// ErrUploadCompleted — сессия уже завершена, куски больше не принимаются.
+	ErrUploadCompleted = errors.New("загрузка уже завершена")
+)
+
+// UploadSessionRequest — параметры новой сессии загрузки кусками.
+type UploadSessionRequest struct {
+	FileName string `json:"file_name"`
+	// ContentType — тип файла; по умолчанию определяется по расширению.
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	// ChunkSize — размер каждого куска, кроме последнего (не меньше 5 МБ, если кусков несколько).
+	ChunkSize int64 `json:"chunk_size"`
+}
+
+// UploadSession — состояние сессии для клиента.
+type UploadSession struct {
+	ID        string    `json:"session_id"`
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	ChunkSize int64     `json:"chunk_size"`
+	Chunks    int       `json:"chunks"`
+	Received  []int     `json:"received"`
+	Missing   []int     `json:"missing"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// File — загруженный файл; появляется после завершения сессии.
+	File *UploadedFile `json:"file,omitempty"`
+}
+
+// ChunkReceipt — подтверждение приёма куска.
+type ChunkReceipt struct {
+	Number int    `json:"number"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	ETag   string `json:"etag"`
+}
+
+// sessionState — состояние сессии в хранилище.
+type sessionState struct {
+	ID       string `json:"id"`
+	FolderID string `json:"folder_id"`
+	// Key — итоговый ключ файла photos/:id/uuid.ext. До проверки файл собирается под stagedSessionKey.
+	Key string `json:"key"`
+	// MultipartID — идентификатор multipart-загрузки под stagedSessionKey; пусто, когда объект уже собран.
+	MultipartID string        `json:"multipart_id,omitempty"`
+	Size        int64         `json:"size"`
+	ChunkSize   int64         `json:"chunk_size"`
+	Chunks      int           `json:"chunks"`
+	ExpiresAt   time.Time     `json:"expires_at"`
+	Result      *UploadedFile `json:"result,omitempty"`
+}
+
+// chunkSize возвращает ожидаемый размер куска number: все куски равны ChunkSize, кроме последнего.
+func (st *sessionState) chunkSize(number int) int64 {
+	if number < st.Chunks {
+		return st.ChunkSize
+	}
+	return st.Size - int64(st.Chunks-1)*st.ChunkSize
+}
+
+// view собирает ответ клиенту по состоянию и принятым кускам.
+func (st *sessionState) view(receipts []ChunkReceipt) *UploadSession {
+	received := make(map[int]bool, len(receipts))
+	session := &UploadSession{
+		ID:        st.ID,
+		Key:       st.Key,
+		Size:      st.Size,
+		ChunkSize: st.ChunkSize,
+		Chunks:    st.Chunks,
+		Received:  []int{},
+		Missing:   []int{},
+		ExpiresAt: st.ExpiresAt,
+		File:      st.Result,
+	}
+	for _, receipt := range receipts {
+		received[receipt.Number] = true
+		session.Received = append(session.Received, receipt.Number)
+	}
+	if st.Result == nil {
+		for number := 1; number <= st.Chunks; number++ {
+			if !received[number] {
+				session.Missing = append(session.Missing, number)
+			}
+		}
+	}
+	return session
+}
+
+// UploadSessionService — загрузка файла пронумерованными кусками через JSON API для клиентов,
+// которые не поддерживают tus. Каждый кусок — часть multipart-загрузки хранилища.
+type UploadSessionService struct {
+	repo    repository.Storage
+	uploads *S3Service
+	// Expiration — сколько живёт сессия после последнего принятого куска.
+	Expiration time.Duration
+	// CleanupInterval — период удаления просроченных сессий (RunCleanup).
+	CleanupInterval time.Duration
+	// locks: куски захватывают сессию совместно, завершение и отмена — монопольно
+	// (в пределах экземпляра сервиса).
+	locks uploadLocks
+}
+
+// NewUploadSessionService — конструктор.
+func NewUploadSessionService(
+	repo repository.Storage,
+	uploads *S3Service,
+	expiration, cleanupInterval time.Duration,
+) *UploadSessionService {
+	return &UploadSessionService{
+		repo:            repo,
+		uploads:         uploads,
+		Expiration:      expiration,
+		CleanupInterval: cleanupInterval,
+	}
+}
+
+// Create проверяет имя, тип и размер файла, выбирает ключ photos/:id/uuid.ext
+// и начинает приватную multipart-загрузку внутри uploads/sessions/<session>/.
+func (s *UploadSessionService) Create(
+	ctx context.Context,
+	idParam string,
+	req UploadSessionRequest,
+	policy UploadPolicy,
+) (*UploadSession, error) {
+	if err := policy.checkFileName(req.FileName); err != nil {
+		return nil, err
+	}
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = imaging.ContentTypeByExtension(path.Ext(req.FileName))
+	}
+	if err := policy.checkContentType(req.FileName, contentType); err != nil {
+		return nil, err
+	}
+
+	maxSize := policy.MaxFileSize
+	if maxSize <= 0 {
+		maxSize = maxDirectUploadSize
+	}
+	if req.Size > maxSize {
+		return nil, fmt.Errorf("%w: %d больше %d байт", ErrUploadTooLarge, req.Size, maxSize)
+	}
+	if req.Size <= 0 || req.ChunkSize <= 0 {
+		return nil, &FileRejectedError{FileName: req.FileName, Reason: "Размер файла и куска должны быть больше нуля"}
+	}
+	chunks := int((req.Size + req.ChunkSize - 1) / req.ChunkSize)
+	if chunks > 1 && req.ChunkSize < repository.MinPartSize {
+		return nil, &FileRejectedError{
+			FileName: req.FileName,
+			Reason:   fmt.Sprintf("Размер куска должен быть не меньше %d байт", repository.MinPartSize),
+		}
+	}
+	if chunks > repository.MaxParts {
+		return nil, &FileRejectedError{
+			FileName: req.FileName,
+			Reason:   fmt.Sprintf("Кусков больше %d", repository.MaxParts),
+		}
+	}
+
+	ext := imaging.ExtensionForContentType(path.Ext(req.FileName), contentType)
+	sessionID := uuid.New().String()
+	// До проверки файл не должен открываться по публичной ссылке
+	multipartID, err := s.repo.CreateMultipartUpload(ctx, stagedSessionKey(sessionID), contentType, repository.VisibilityPrivate)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось начать загрузку: %w", err)
+	}
+
+	st := &sessionState{
+		ID:          sessionID,
+		FolderID:    idParam,
+		Key:         fmt.Sprintf("photos/%s/%s%s", idParam, uuid.New().String(), ext),
+		MultipartID: multipartID,
+		Size:        req.Size,
+		ChunkSize:   req.ChunkSize,
+		Chunks:      chunks,
+		ExpiresAt:   s.expiresAt(),
+	}
+	if err := saveState(ctx, s.repo, sessionKey(st.ID), st); err != nil {
+		_ = s.repo.AbortMultipartUpload(context.WithoutCancel(ctx), stagedSessionKey(sessionID), multipartID)
+		return nil, err
+	}
+	return st.view(nil), nil
+}
+
+// Get возвращает сессию со списками принятых и недостающих кусков.
+func (s *UploadSessionService) Get(ctx context.Context, idParam, sessionID string) (*UploadSession, error) {
+	st, err := s.get(ctx, idParam, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := s.receipts(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return st.view(receipts), nil
+}
+
+// PutChunk принимает кусок number и сверяет его размер и SHA-256 (hex) с checksum.
+// Повторная отправка куска заменяет предыдущую.
+func (s *UploadSessionService) PutChunk(
+	ctx context.Context,
+	idParam, sessionID string,
+	number int,
+	body io.Reader,
+	checksum string,
+) (ChunkReceipt, error) {
+	if err := checkSessionID(sessionID); err != nil {
+		return ChunkReceipt{}, err
+	}
+	unlock, ok := s.locks.tryRLock(sessionID)
+	if !ok {
+		return ChunkReceipt{}, ErrUploadLocked
+	}
+	defer unlock()
+
+	st, err := s.get(ctx, idParam, sessionID)
+	if err != nil {
+		return ChunkReceipt{}, err
+	}
+	if st.Result != nil {
+		return ChunkReceipt{}, ErrUploadCompleted
+	}
+	chunkName := "chunk " + strconv.Itoa(number)
+	if number < 1 || number > st.Chunks {
+		return ChunkReceipt{}, &FileRejectedError{
+			FileName: chunkName,
+			Reason:   fmt.Sprintf("Номер куска должен быть от 1 до %d", st.Chunks),
+		}
+	}
+
+	size := st.chunkSize(number)
+	data, err := io.ReadAll(io.LimitReader(body, size+1))
+	if err != nil {
+		return ChunkReceipt{}, err
+	}
+	if int64(len(data)) != size {
+		return ChunkReceipt{}, &FileRejectedError{
+			FileName: chunkName,
+			Reason:   fmt.Sprintf("Ожидается кусок размером %d байт", size),
+		}
+	}
+	sum := sha256.Sum256(data)
+	if !strings.EqualFold(checksum, hex.EncodeToString(sum[:])) {
+		return ChunkReceipt{}, ErrChecksumMismatch
+	}
+
+	// Кусок принят целиком — дальнейшие шаги не зависят от соединения с клиентом
+	ctx = context.WithoutCancel(ctx)
+	etag, err := s.repo.UploadPart(ctx, stagedSessionKey(sessionID), st.MultipartID, int32(number), bytes.NewReader(data), size)
+	if err != nil {
+		return ChunkReceipt{}, fmt.Errorf("не удалось сохранить кусок %d: %w", number, err)
+	}
+	receipt := ChunkReceipt{Number: number, Size: size, SHA256: hex.EncodeToString(sum[:]), ETag: etag}
+	if err := saveState(ctx, s.repo, chunkKey(sessionID, number), receipt); err != nil {
+		return ChunkReceipt{}, err
+	}
+	st.ExpiresAt = s.expiresAt()
+	if err := saveState(ctx, s.repo, sessionKey(sessionID), st); err != nil {
+		return ChunkReceipt{}, err
+	}
+	return receipt, nil
+}
+
+// Complete собирает приватный файл из кусков, проверяет его так же, как загруженный напрямую
+// (ConfirmDirectUpload), и копирует под Key с видимостью маршрута. Отклонённый файл удаляется
+// вместе с сессией; повторный вызов для завершённой сессии возвращает тот же файл.
+func (s *UploadSessionService) Complete(
+	ctx context.Context,
+	idParam, sessionID string,
+	policy UploadPolicy,
+) (UploadedFile, error) {
+	if err := checkSessionID(sessionID); err != nil {
+		return UploadedFile{}, err
+	}
+	unlock, ok := s.locks.tryLock(sessionID)
+	if !ok {
+		return UploadedFile{}, ErrUploadLocked
+	}
+	defer unlock()
+
+	st, err := s.get(ctx, idParam, sessionID)
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	if st.Result != nil {
+		return *st.Result, nil
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	if st.MultipartID != "" {
+		receipts, err := s.receipts(ctx, sessionID)
+		if err != nil {
+			return UploadedFile{}, err
+		}
+		if missing := st.view(receipts).Missing; len(missing) > 0 {
+			return UploadedFile{}, fmt.Errorf("%w: %v", ErrChunksMissing, missing)
+		}
+		parts := make([]repository.CompletedPart, 0, len(receipts))
+		for _, receipt := range receipts {
+			parts = append(parts, repository.CompletedPart{
+				Number: int32(receipt.Number),
+				ETag:   receipt.ETag,
+				Size:   receipt.Size,
+			})
+		}
+		if err := completeMultipart(ctx, s.repo, stagedSessionKey(sessionID), st.MultipartID, parts); err != nil {
+			return UploadedFile{}, err
+		}
+		st.MultipartID = ""
+		if err := saveState(ctx, s.repo, sessionKey(sessionID), st); err != nil {
+			return UploadedFile{}, err
+		}
+	}
+
+	file, err := s.uploads.confirmUpload(ctx, st.FolderID, stagedSessionKey(sessionID), st.Key, policy)
+	if err != nil {
+		var rejected *FileRejectedError
+		if errors.As(err, &rejected) {
+			s.remove(ctx, st)
+		}
+		return UploadedFile{}, err
+	}
+	st.Result = &file
+	if err := saveState(ctx, s.repo, sessionKey(sessionID), st); err != nil {
+		return UploadedFile{}, err
+	}
+	return file, nil
+}
+
+// Abort отменяет сессию: удаляет принятые куски и состояние. Файл завершённой сессии остаётся.
+func (s *UploadSessionService) Abort(ctx context.Context, idParam, sessionID string) error {
+	if err := checkSessionID(sessionID); err != nil {
+		return err
+	}
+	unlock, ok := s.locks.tryLock(sessionID)
+	if !ok {
+		return ErrUploadLocked
+	}
+	defer unlock()
+
+	st, err := s.load(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if st.FolderID != idParam {
+		return fmt.Errorf("%w: сессия %s", repository.ErrNotFound, sessionID)
+	}
+	s.remove(context.WithoutCancel(ctx), st)
+	return nil
+}
+
+// CleanupExpired удаляет сессии, срок которых истёк к моменту now, и куски без состояния.
+// Возвращает число удалённых сессий.
+func (s *UploadSessionService) CleanupExpired(ctx context.Context, now time.Time) (int, error) {
+	removed := 0
+	err := listGroups(ctx, s.repo, sessionStatePrefix, func(key string) string {
+		sessionID, _, _ := strings.Cut(strings.TrimPrefix(key, sessionStatePrefix), "/")
+		return sessionID
+	}, func(sessionID string, objects []repository.ObjectInfo) {
+		unlock, ok := s.locks.tryLock(sessionID)
+		if !ok {
+			return
+		}
+		defer unlock()
+		st, err := s.load(ctx, sessionID)
+		switch {
+		case err == nil && now.After(st.ExpiresAt):
+			s.remove(ctx, st)
+			removed++
+		case errors.Is(err, repository.ErrNotFound):
+			// Куски без состояния остаются после сбоя посреди отмены
+			var stale []string
+			for _, obj := range objects {
+				if now.Sub(obj.LastModified) > s.Expiration {
+					stale = append(stale, obj.Key)
+				}
+			}
+			if len(stale) > 0 {
+				_ = s.repo.DeleteFilesBatch(ctx, stale)
+			}
+		case err != nil:
+			log.Error("Failed to load upload session", zap.String("session", sessionID), zap.Error(err))
+		}
+	})
+	return removed, err
+}
+
+// RunCleanup раз в CleanupInterval удаляет просроченные сессии, пока не отменён ctx.
+func (s *UploadSessionService) RunCleanup(ctx context.Context) {
+	runCleanup(ctx, "upload sessions", s.CleanupInterval, s.CleanupExpired)
+}
+
+// get загружает состояние сессии и проверяет :id и срок действия.
+func (s *UploadSessionService) get(ctx context.Context, idParam, sessionID string) (*sessionState, error) {
+	st, err := s.load(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if st.FolderID != idParam {
+		return nil, fmt.Errorf("%w: сессия %s", repository.ErrNotFound, sessionID)
+	}
+	if st.Result == nil && time.Now().After(st.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	return st, nil
+}
+
+func (s *UploadSessionService) load(ctx context.Context, sessionID string) (*sessionState, error) {
+	if err := checkSessionID(sessionID); err != nil {
+		return nil, err
+	}
+	var st sessionState
+	if err := loadState(ctx, s.repo, sessionKey(sessionID), &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// receipts возвращает подтверждения принятых кусков по возрастанию номеров.
+func (s *UploadSessionService) receipts(ctx context.Context, sessionID string) ([]ChunkReceipt, error) {
+	receipts := make([]ChunkReceipt, 0)
+	err := s.repo.ListPages(ctx, sessionStatePrefix+sessionID+"/chunk-", func(page []repository.ObjectInfo) error {
+		for _, obj := range page {
+			var receipt ChunkReceipt
+			if err := loadState(ctx, s.repo, obj.Key, &receipt); err != nil {
+				return err
+			}
+			receipts = append(receipts, receipt)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(receipts, func(i, j int) bool { return receipts[i].Number < receipts[j].Number })
+	return receipts, nil
+}
+
+// remove отменяет multipart-загрузку и удаляет все служебные объекты сессии вместе с собранным,
+// но не подтверждённым файлом.
+func (s *UploadSessionService) remove(ctx context.Context, st *sessionState) {
+	if st.MultipartID != "" {
+		err := s.repo.AbortMultipartUpload(ctx, stagedSessionKey(st.ID), st.MultipartID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Error("Failed to abort multipart upload", zap.String("key", stagedSessionKey(st.ID)), zap.Error(err))
+		}
+	}
+	// Удаляем постранично: у сессии может быть много кусков. Неудалённые ключи одной страницы
+	// не мешают удалить остальные
+	var failed []error
+	err := s.repo.ListPages(ctx, sessionStatePrefix+st.ID+"/", func(page []repository.ObjectInfo) error {
+		keys := make([]string, 0, len(page))
+		for _, obj := range page {
+			keys = append(keys, obj.Key)
+		}
+		if err := s.repo.DeleteFilesBatch(ctx, keys); err != nil {
+			failed = append(failed, err)
+		}
+		return nil
+	})
+	if err = errors.Join(append(failed, err)...); err != nil {
+		log.Error("Failed to delete upload session", zap.String("session", st.ID), zap.Error(err))
+	}
+}
+
+func (s *UploadSessionService) expiresAt() time.Time {
+	return time.Now().Add(s.Expiration).UTC().Truncate(time.Second)
+}
+
+// checkSessionID отсекает идентификаторы, которые сервис не мог выдать, до обращения к хранилищу.
+func checkSessionID(sessionID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return fmt.Errorf("%w: сессия %q", repository.ErrNotFound, sessionID)
+	}
+	return nil
+}
+
+func sessionKey(sessionID string) string {
+	return sessionStatePrefix + sessionID + "/session.json"
+}
+
+func stagedSessionKey(sessionID string) string {
+	return sessionStatePrefix + sessionID + "/object.bin"
+}
+
+func chunkKey(sessionID string, number int) string {
+	return fmt.Sprintf("%s%s/chunk-%05d.json", sessionStatePrefix, sessionID, number)
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"files/internal/repository"
+	"files/pkg/log"
+	"go.uber.org/zap"
+)
+
+// saveState сохраняет состояние незавершённой загрузки (tus, сессии) приватным JSON-объектом.
+// Состояние лежит в том же хранилище, что и файлы, поэтому переживает перезапуск сервиса
+// и доступно всем его экземплярам.
+func saveState(ctx context.Context, repo repository.Storage, key string, state any) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = repo.UploadFile(ctx, key, "application/json", bytes.NewReader(data), repository.VisibilityPrivate)
+	if err != nil {
+		return fmt.Errorf("не удалось сохранить состояние загрузки: %w", err)
+	}
+	return nil
+}
+
+// loadState читает состояние, сохранённое saveState. Если его нет — ErrNotFound.
+func loadState(ctx context.Context, repo repository.Storage, key string, state any) error {
+	body, _, err := repo.GetFile(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(state); err != nil {
+		return fmt.Errorf("повреждено состояние загрузки %s: %w", key, err)
+	}
+	return nil
+}
+
+// uploadLocks — блокировки незавершённых загрузок в пределах экземпляра сервиса. Запись о загрузке
+// живёт, только пока блокировка удерживается, поэтому карта не растёт с числом загрузок и не
+// засоряется произвольными идентификаторами из запросов. Нулевое значение готово к работе.
+type uploadLocks struct {
+	mu sync.Mutex
+	// held — число совместных владельцев загрузки; -1 — загрузка захвачена монопольно.
+	held map[string]int
+}
+
+// tryLock монопольно захватывает загрузку id без ожидания. Если она уже захвачена — false.
+func (l *uploadLocks) tryLock(id string) (unlock func(), ok bool) {
+	return l.acquire(id, -1)
+}
+
+// tryRLock захватывает загрузку id совместно с другими tryRLock (например, параллельные куски).
+// Если она захвачена монопольно — false.
+func (l *uploadLocks) tryRLock(id string) (unlock func(), ok bool) {
+	return l.acquire(id, 1)
+}
+
+func (l *uploadLocks) acquire(id string, delta int) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	held := l.held[id]
+	if held < 0 || (held > 0 && delta < 0) {
+		return nil, false
+	}
+	if l.held == nil {
+		l.held = make(map[string]int)
+	}
+	l.held[id] = held + delta
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.held[id] -= delta
+		if l.held[id] == 0 {
+			delete(l.held, id)
+		}
+	}, true
+}
+
+// completeMultipart собирает объект из частей. Если загрузки уже нет, но объект существует,
+// значит, он собран раньше, а состояние не успело сохраниться, — это не ошибка.
+func completeMultipart(
+	ctx context.Context,
+	repo repository.Storage,
+	key, multipartID string,
+	parts []repository.CompletedPart,
+) error {
+	err := repo.CompleteMultipartUpload(ctx, key, multipartID, parts)
+	if errors.Is(err, repository.ErrNotFound) {
+		if _, headErr := repo.HeadFile(ctx, key); headErr == nil {
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("не удалось собрать файл: %w", err)
+	}
+	return nil
+}
+
+// listGroups обходит служебные объекты с префиксом prefix постранично и вызывает fn для каждой группы
+// подряд идущих объектов с одинаковым group(key) — например, всех объектов одной загрузки. Листинг
+// упорядочен по ключу, поэтому объекты группы идут подряд и в памяти держится только текущая группа.
+func listGroups(
+	ctx context.Context,
+	repo repository.Storage,
+	prefix string,
+	group func(key string) string,
+	fn func(id string, objects []repository.ObjectInfo),
+) error {
+	var current string
+	var objects []repository.ObjectInfo
+	err := repo.ListPages(ctx, prefix, func(page []repository.ObjectInfo) error {
+		for _, obj := range page {
+			id := group(obj.Key)
+			if id != current && len(objects) > 0 {
+				fn(current, objects)
+				objects = nil
+			}
+			current = id
+			objects = append(objects, obj)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(objects) > 0 {
+		fn(current, objects)
+	}
+	return nil
+}
+
+// runCleanup раз в interval вызывает cleanup, пока не отменён ctx. interval <= 0 отключает очистку.
+func runCleanup(
+	ctx context.Context,
+	name string,
+	interval time.Duration,
+	cleanup func(ctx context.Context, now time.Time) (int, error),
+) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removed, err := cleanup(ctx, now)
+			if err != nil {
+				log.Error("Failed to clean up "+name, zap.Error(err))
+				continue
+			}
+			if removed > 0 {
+				log.Info("Expired "+name+" removed", zap.Int("count", removed))
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import "testing"
+
+func TestUploadLocksReleaseEntries(t *testing.T) {
+	var locks uploadLocks
+	unlock, ok := locks.tryLock("a")
+	if !ok {
+		t.Fatal("first lock must succeed")
+	}
+	if _, ok := locks.tryLock("a"); ok {
+		t.Fatal("a held upload must not be locked twice")
+	}
+	unlockB, ok := locks.tryLock("b")
+	if !ok {
+		t.Fatal("other uploads must not be blocked")
+	}
+	unlockB()
+	unlock()
+	if len(locks.held) != 0 {
+		t.Fatalf("released locks must not stay in the map: %v", locks.held)
+	}
+	if unlock, ok := locks.tryLock("a"); !ok {
+		t.Fatal("a released upload must be lockable again")
+	} else {
+		unlock()
+	}
+}
+
+func TestUploadLocksShared(t *testing.T) {
+	var locks uploadLocks
+	first, ok := locks.tryRLock("a")
+	if !ok {
+		t.Fatal("shared lock must succeed")
+	}
+	second, ok := locks.tryRLock("a")
+	if !ok {
+		t.Fatal("shared locks must not block each other")
+	}
+	if _, ok := locks.tryLock("a"); ok {
+		t.Fatal("an exclusive lock must wait for shared holders")
+	}
+	first()
+	if _, ok := locks.tryLock("a"); ok {
+		t.Fatal("an exclusive lock must wait for the last shared holder")
+	}
+	second()
+
+	unlock, ok := locks.tryLock("a")
+	if !ok {
+		t.Fatal("exclusive lock must succeed after shared holders left")
+	}
+	if _, ok := locks.tryRLock("a"); ok {
+		t.Fatal("a shared lock must not enter an exclusively held upload")
+	}
+	unlock()
+	if len(locks.held) != 0 {
+		t.Fatalf("released locks must not stay in the map: %v", locks.held)
+	}
+}
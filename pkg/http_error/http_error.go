@@ -16,6 +16,8 @@ type HTTPError struct {
 type ErrorItem struct {
 	Field string `json:"field"` // The field in the request where the error occurred.
 	Error string `json:"error"` // The specific error message for the field.
+	// File — имя файла, к которому относится ошибка. В отличие от Field, регистр сохраняется.
+	File string `json:"file,omitempty"`
 }
 
 // NewHTTPError creates a new instance of HTTPError.
@@ -0,0 +1,73 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	// Регистрируем декодеры поддерживаемых форматов
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// ErrInvalidImage — содержимое не удалось разобрать как изображение.
+var ErrInvalidImage = errors.New("файл не является корректным изображением")
+
+// ErrImageTooLarge — размеры изображения превышают лимиты.
+var ErrImageTooLarge = errors.New("размеры изображения превышают допустимые")
+
+// Limits — ограничения на размеры изображения. Нулевое значение поля означает «без ограничения».
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	// MaxPixels ограничивает width*height и защищает от «decompression bomb»:
+	// маленький файл, который при декодировании занимает гигабайты памяти.
+	MaxPixels int64
+}
+
+// Check проверяет размеры изображения.
+func (l Limits) Check(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("%w: некорректные размеры %dx%d", ErrInvalidImage, width, height)
+	}
+	if l.MaxWidth > 0 && width > l.MaxWidth {
+		return fmt.Errorf("%w: ширина %d больше %d", ErrImageTooLarge, width, l.MaxWidth)
+	}
+	if l.MaxHeight > 0 && height > l.MaxHeight {
+		return fmt.Errorf("%w: высота %d больше %d", ErrImageTooLarge, height, l.MaxHeight)
+	}
+	if l.MaxPixels > 0 && int64(width)*int64(height) > l.MaxPixels {
+		return fmt.Errorf("%w: %d пикселей больше %d", ErrImageTooLarge, int64(width)*int64(height), l.MaxPixels)
+	}
+	return nil
+}
+
+// Decode полностью декодирует изображение из r, проверяя размеры по заголовку до выделения
+// памяти под пиксели. Возвращает изображение и MIME-тип формата ("image/png" и т.п.).
+// После успешного декодирования остаток r дочитывается, чтобы поток можно было передавать
+// через io.Pipe, не блокируя пишущую сторону.
+func Decode(r io.Reader, limits Limits) (image.Image, string, error) {
+	// Заголовок читаем через TeeReader и затем «проигрываем» его повторно для полного декодирования.
+	var header bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if err := limits.Check(cfg.Width, cfg.Height); err != nil {
+		return nil, "", err
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, "", err
+	}
+	return img, "image/" + format, nil
+}
@@ -0,0 +1,59 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// ErrWebPUnsupported — сборка без cgo не умеет кодировать WebP.
+var ErrWebPUnsupported = errors.New("кодирование в WebP недоступно: сервис собран без cgo")
+
+// DefaultQuality — качество JPEG по умолчанию для производных изображений.
+const DefaultQuality = 85
+
+// EncodeFormat возвращает MIME-тип, в котором сохраняются производные изображения
+// для исходного типа: JPEG остаётся JPEG, остальные форматы кодируются в PNG без потерь.
+func EncodeFormat(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Encode кодирует изображение в указанный MIME-тип. quality используется для форматов с потерями.
+func Encode(w io.Writer, img image.Image, contentType string, quality int) error {
+	if quality <= 0 || quality > 100 {
+		quality = DefaultQuality
+	}
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "image/png":
+		return png.Encode(w, img)
+	case "image/gif":
+		return gif.Encode(w, img, nil)
+	case "image/webp":
+		return encodeWebP(w, img, quality)
+	default:
+		return fmt.Errorf("кодирование в %q не поддерживается", contentType)
+	}
+}
+
+// CanEncode сообщает, умеет ли сборка кодировать contentType: WebP кодируется только с cgo.
+func CanEncode(contentType string) bool {
+	return contentType != "image/webp" || WebPSupported
+}
+
+// Reencode кодирует изменённое изображение в формат исходного файла data. WebP без потерь (VP8L)
+// остаётся без потерь; остальные форматы кодируются как в Encode.
+func Reencode(w io.Writer, img image.Image, data []byte, contentType string, quality int) error {
+	if contentType == "image/webp" && isLosslessWebP(data) {
+		return encodeWebPLossless(w, img)
+	}
+	return Encode(w, img, contentType, quality)
+}
@@ -0,0 +1,185 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// exifHeader — префикс EXIF-данных в JPEG (APP1) перед TIFF-структурой.
+var exifHeader = []byte("Exif\x00\x00")
+
+// OrientationTag — номер тега Orientation. Его можно передать в keep функции StripMetadata,
+// если пиксели повернуть нельзя.
+const OrientationTag = tagOrientation
+
+// Теги IFD0, которые используются при обработке метаданных.
+const (
+	tagOrientation = 0x0112
+	typeShort      = 3
+	typeASCII      = 2
+)
+
+// exifTagNames — теги, которые можно сохранить при очистке метаданных (только текстовые поля IFD0).
+var exifTagNames = map[string]uint16{
+	"copyright":        0x8298,
+	"artist":           0x013B,
+	"imagedescription": 0x010E,
+}
+
+// ExifTagID возвращает номер тега по имени ("Copyright", "Artist", "ImageDescription").
+func ExifTagID(name string) (uint16, bool) {
+	id, ok := exifTagNames[strings.ToLower(name)]
+	return id, ok
+}
+
+// exifEntry — запись IFD: тег, тип, количество и сырые байты значения.
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// parseExif разбирает IFD0 TIFF-структуры (без префикса "Exif\0\0").
+func parseExif(tiff []byte) (map[uint16]exifEntry, error) {
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("EXIF слишком короткий")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("некорректный заголовок TIFF")
+	}
+
+	ifdOffset := order.Uint32(tiff[4:8])
+	if uint64(ifdOffset)+2 > uint64(len(tiff)) {
+		return nil, fmt.Errorf("некорректное смещение IFD0")
+	}
+	count := int(order.Uint16(tiff[ifdOffset:]))
+	entries := make(map[uint16]exifEntry, count)
+	for i := 0; i < count; i++ {
+		pos := int(ifdOffset) + 2 + i*12
+		if pos+12 > len(tiff) {
+			break
+		}
+		entry := exifEntry{
+			tag:   order.Uint16(tiff[pos:]),
+			typ:   order.Uint16(tiff[pos+2:]),
+			count: order.Uint32(tiff[pos+4:]),
+		}
+		size := uint64(entry.count) * uint64(exifTypeSize(entry.typ))
+		if size <= 4 {
+			entry.value = tiff[pos+8 : pos+8+int(size)]
+		} else {
+			offset := uint64(order.Uint32(tiff[pos+8:]))
+			if offset+size > uint64(len(tiff)) {
+				continue
+			}
+			entry.value = tiff[offset : offset+size]
+		}
+		// Числовые значения приводим к little-endian, чтобы записывать их без учёта исходного порядка
+		if entry.typ == typeShort && order == binary.BigEndian {
+			le := make([]byte, len(entry.value))
+			for j := 0; j+1 < len(entry.value); j += 2 {
+				binary.LittleEndian.PutUint16(le[j:], order.Uint16(entry.value[j:]))
+			}
+			entry.value = le
+		}
+		entries[entry.tag] = entry
+	}
+	return entries, nil
+}
+
+// exifOrientation возвращает значение Orientation (1–8) или 1, если тега нет.
+func exifOrientation(tiff []byte) int {
+	entries, err := parseExif(tiff)
+	if err != nil {
+		return 1
+	}
+	entry, ok := entries[tagOrientation]
+	if !ok || entry.typ != typeShort || len(entry.value) < 2 {
+		return 1
+	}
+	orientation := int(binary.LittleEndian.Uint16(entry.value))
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// buildExif собирает новую TIFF-структуру (little-endian), в которую попадают только
+// текстовые теги из keep и Orientation. Если сохранять нечего, возвращает nil.
+func buildExif(tiff []byte, keep []uint16) []byte {
+	if len(tiff) == 0 || len(keep) == 0 {
+		return nil
+	}
+	entries, err := parseExif(tiff)
+	if err != nil {
+		return nil
+	}
+	var kept []exifEntry
+	for _, tag := range keep {
+		if entry, ok := entries[tag]; ok && (entry.typ == typeASCII || entry.tag == tagOrientation && entry.typ == typeShort) {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	// Записи IFD должны идти по возрастанию тега
+	sort.Slice(kept, func(i, j int) bool { return kept[i].tag < kept[j].tag })
+
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(8))
+
+	ifdSize := 2 + len(kept)*12 + 4
+	dataOffset := uint32(8 + ifdSize)
+	var data bytes.Buffer
+
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(len(kept)))
+	for _, entry := range kept {
+		_ = binary.Write(&buf, binary.LittleEndian, entry.tag)
+		_ = binary.Write(&buf, binary.LittleEndian, entry.typ)
+		_ = binary.Write(&buf, binary.LittleEndian, entry.count)
+		if len(entry.value) <= 4 {
+			value := make([]byte, 4)
+			copy(value, entry.value)
+			buf.Write(value)
+			continue
+		}
+		_ = binary.Write(&buf, binary.LittleEndian, dataOffset+uint32(data.Len()))
+		data.Write(entry.value)
+		// Значения выравниваются по границе слова
+		if data.Len()%2 == 1 {
+			data.WriteByte(0)
+		}
+	}
+	// Следующего IFD нет
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(0))
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+// exifTypeSize — размер одного значения TIFF-типа в байтах.
+func exifTypeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11: // LONG, SLONG, FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,511 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+)
+
+// Metadata — сведения из EXIF, которые нужны для очистки изображения.
+type Metadata struct {
+	// Orientation — значение тега Orientation (1–8); 1, если тега нет.
+	Orientation int
+	// exif — исходная TIFF-структура, из которой берутся сохраняемые теги.
+	exif []byte
+}
+
+// SupportsMetadataStripping сообщает, умеет ли StripMetadata обрабатывать формат.
+func SupportsMetadataStripping(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/webp":
+		return true
+	default:
+		return false
+	}
+}
+
+// ReadMetadata находит EXIF в JPEG (APP1), PNG (eXIf) или WebP (чанк EXIF).
+func ReadMetadata(data []byte, contentType string) Metadata {
+	var exif []byte
+	switch contentType {
+	case "image/jpeg":
+		exif = jpegExif(data)
+	case "image/png":
+		exif = pngExif(data)
+	case "image/webp":
+		exif = webpExif(data)
+	}
+	exif = bytes.TrimPrefix(exif, exifHeader)
+	return Metadata{Orientation: exifOrientation(exif), exif: exif}
+}
+
+// StripMetadata удаляет из файла EXIF, XMP, IPTC, текстовые комментарии и данные производителей.
+// Пиксели не перекодируются. Теги keep переносятся из meta в новый минимальный EXIF.
+// Orientation сохраняется, только если он есть в keep (OrientationTag) — когда изображение
+// нельзя повернуть; иначе оно должно быть уже повёрнуто (см. Orient).
+func StripMetadata(data []byte, contentType string, meta Metadata, keep []uint16) ([]byte, error) {
+	exif := buildExif(meta.exif, keep)
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data, exif)
+	case "image/png":
+		return stripPNG(data, exif)
+	case "image/webp":
+		return stripWebP(data, exif)
+	default:
+		return nil, fmt.Errorf("очистка метаданных не поддерживается для %q", contentType)
+	}
+}
+
+// Orient поворачивает и отражает пиксели по значению EXIF Orientation так,
+// чтобы изображение отображалось правильно без тега.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		// 5–8 меняют ширину и высоту местами
+		dstW, dstH = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // отражение по горизонтали
+				dx, dy = w-1-x, y
+			case 3: // поворот на 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // отражение по вертикали
+				dx, dy = x, h-1-y
+			case 5: // транспонирование
+				dx, dy = y, x
+			case 6: // поворот на 90° по часовой стрелке
+				dx, dy = h-1-y, x
+			case 7: // транспонирование относительно побочной диагонали
+				dx, dy = h-1-y, w-1-x
+			case 8: // поворот на 90° против часовой стрелки
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
+
+// jpegSegment — маркерный сегмент JPEG до начала данных изображения (SOS).
+type jpegSegment struct {
+	marker  byte
+	raw     []byte // сегмент целиком, включая маркер и длину
+	payload []byte
+}
+
+// jpegSegments разбирает сегменты JPEG до маркера SOS. rest — байты начиная с SOS.
+func jpegSegments(data []byte) (segments []jpegSegment, rest []byte, err error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, nil, fmt.Errorf("%w: нет маркера SOI", ErrInvalidImage)
+	}
+	pos := 2
+	for pos+1 < len(data) {
+		if data[pos] != 0xFF {
+			return nil, nil, fmt.Errorf("%w: некорректный маркер JPEG", ErrInvalidImage)
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF:
+			// Заполняющий байт перед маркером
+			pos++
+			continue
+		case marker == 0xDA:
+			return segments, data[pos:], nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD9):
+			// Маркеры без длины
+			segments = append(segments, jpegSegment{marker: marker, raw: data[pos : pos+2]})
+			pos += 2
+			continue
+		}
+		if pos+4 > len(data) {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segments = append(segments, jpegSegment{marker: marker, raw: data[pos:end], payload: data[pos+4 : end]})
+		pos = end
+	}
+	return nil, nil, fmt.Errorf("%w: обрезанный JPEG", ErrInvalidImage)
+}
+
+// jpegExif возвращает содержимое сегмента APP1 с EXIF.
+func jpegExif(data []byte) []byte {
+	segments, _, err := jpegSegments(data)
+	if err != nil {
+		return nil
+	}
+	for _, segment := range segments {
+		if segment.marker == 0xE1 && bytes.HasPrefix(segment.payload, exifHeader) {
+			return segment.payload
+		}
+	}
+	return nil
+}
+
+// isJPEGMetadata сообщает, содержит ли сегмент метаданные: APP1 (EXIF, XMP), APP13 (IPTC),
+// индекс MPF, сегменты производителей APP3–APP12, APP15 и комментарии.
+// APP0 (JFIF), ICC-профиль в APP2 и APP14 (Adobe) нужны для корректного отображения и остаются.
+// Сами дополнительные кадры MPF лежат после EOI основного кадра и отбрасываются в jpegImageData.
+func isJPEGMetadata(segment jpegSegment) bool {
+	switch {
+	case segment.marker == 0xE0, segment.marker == 0xEE:
+		return false
+	case segment.marker == 0xE2:
+		return bytes.HasPrefix(segment.payload, []byte("MPF\x00"))
+	case segment.marker >= 0xE1 && segment.marker <= 0xEF:
+		return true
+	case segment.marker == 0xFE:
+		return true
+	default:
+		return false
+	}
+}
+
+func stripJPEG(data, exif []byte) ([]byte, error) {
+	segments, rest, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(exif) > 0xFFFF-2-len(exifHeader) {
+		return nil, fmt.Errorf("сохраняемые теги EXIF слишком велики")
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(data[:2])
+	inserted := exif == nil
+	for _, segment := range segments {
+		// Новый EXIF записываем сразу после APP0 (JFIF), как это делают камеры
+		if !inserted && segment.marker != 0xE0 {
+			writeJPEGExif(&out, exif)
+			inserted = true
+		}
+		if !isJPEGMetadata(segment) {
+			out.Write(segment.raw)
+		}
+	}
+	if !inserted {
+		writeJPEGExif(&out, exif)
+	}
+	scan, err := jpegImageData(rest)
+	if err != nil {
+		return nil, err
+	}
+	out.Write(scan)
+	return out.Bytes(), nil
+}
+
+// jpegImageData возвращает данные основного кадра от первого SOS до его EOI включительно.
+// Сегменты метаданных между сканами прогрессивного JPEG удаляются, а всё, что записано после EOI
+// (кадры MPF, карты глубины и превью со своими EXIF и XMP), отбрасывается.
+func jpegImageData(rest []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Grow(len(rest))
+	pos := 0
+	for pos+1 < len(rest) {
+		if rest[pos] != 0xFF {
+			return nil, fmt.Errorf("%w: некорректный маркер JPEG", ErrInvalidImage)
+		}
+		marker := rest[pos+1]
+		switch {
+		case marker == 0xFF:
+			pos++
+			continue
+		case marker == 0xD9:
+			out.Write(rest[pos : pos+2])
+			return out.Bytes(), nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD8):
+			out.Write(rest[pos : pos+2])
+			pos += 2
+			continue
+		}
+		if pos+4 > len(rest) {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(rest[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(rest) {
+			break
+		}
+		segment := jpegSegment{marker: marker, raw: rest[pos:end], payload: rest[pos+4 : end]}
+		if !isJPEGMetadata(segment) {
+			out.Write(segment.raw)
+		}
+		pos = end
+		if marker != 0xDA {
+			continue
+		}
+		// Данные скана идут до следующего маркера: 0xFF00 — экранированный байт,
+		// RST0–RST7 — маркеры перезапуска внутри скана
+		start := pos
+		for pos+1 < len(rest) {
+			if next := rest[pos+1]; rest[pos] == 0xFF && next != 0x00 && (next < 0xD0 || next > 0xD7) {
+				break
+			}
+			pos++
+		}
+		out.Write(rest[start:pos])
+	}
+	return nil, fmt.Errorf("%w: обрезанный JPEG", ErrInvalidImage)
+}
+
+func writeJPEGExif(out *bytes.Buffer, exif []byte) {
+	out.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(out, binary.BigEndian, uint16(2+len(exifHeader)+len(exif)))
+	out.Write(exifHeader)
+	out.Write(exif)
+}
+
+// pngSignature — первые 8 байт любого PNG.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChunk — чанк PNG: тип и данные (длина и CRC вычисляются при записи).
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+func pngChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("%w: нет сигнатуры PNG", ErrInvalidImage)
+	}
+	var chunks []pngChunk
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			break
+		}
+		chunk := pngChunk{typ: string(data[pos+4 : pos+8]), data: data[pos+8 : pos+8+length]}
+		chunks = append(chunks, chunk)
+		pos = end
+		if chunk.typ == "IEND" {
+			return chunks, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: обрезанный PNG", ErrInvalidImage)
+}
+
+func pngExif(data []byte) []byte {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil
+	}
+	for _, chunk := range chunks {
+		if chunk.typ == "eXIf" {
+			return chunk.data
+		}
+	}
+	return nil
+}
+
+// isPNGMetadata сообщает, содержит ли чанк метаданные: EXIF, текстовые поля (в том числе XMP в iTXt)
+// и время изменения.
+func isPNGMetadata(typ string) bool {
+	switch typ {
+	case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		return true
+	default:
+		return false
+	}
+}
+
+func stripPNG(data, exif []byte) ([]byte, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(pngSignature)
+	for _, chunk := range chunks {
+		if isPNGMetadata(chunk.typ) {
+			continue
+		}
+		writePNGChunk(&out, chunk)
+		// eXIf должен идти до IDAT, поэтому записываем его сразу после заголовка
+		if chunk.typ == "IHDR" && exif != nil {
+			writePNGChunk(&out, pngChunk{typ: "eXIf", data: exif})
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func writePNGChunk(out *bytes.Buffer, chunk pngChunk) {
+	_ = binary.Write(out, binary.BigEndian, uint32(len(chunk.data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunk.typ))
+	crc.Write(chunk.data)
+	out.WriteString(chunk.typ)
+	out.Write(chunk.data)
+	_ = binary.Write(out, binary.BigEndian, crc.Sum32())
+}
+
+// Флаги чанка VP8X.
+const (
+	webpFlagAlpha = 0x10
+	webpFlagEXIF  = 0x08
+	webpFlagXMP   = 0x04
+)
+
+// webpChunk — чанк RIFF-контейнера WebP.
+type webpChunk struct {
+	fourCC string
+	data   []byte
+}
+
+func webpChunks(data []byte) ([]webpChunk, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("%w: нет заголовка WebP", ErrInvalidImage)
+	}
+	var chunks []webpChunk
+	pos := 12
+	for pos+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size
+		if size < 0 || end > len(data) {
+			return nil, fmt.Errorf("%w: обрезанный WebP", ErrInvalidImage)
+		}
+		chunks = append(chunks, webpChunk{fourCC: string(data[pos : pos+4]), data: data[pos+8 : end]})
+		// Чанки выравниваются по чётной границе
+		pos = end + size%2
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("%w: пустой WebP", ErrInvalidImage)
+	}
+	return chunks, nil
+}
+
+// isLosslessWebP сообщает, закодировано ли изображение WebP без потерь (чанк VP8L).
+func isLosslessWebP(data []byte) bool {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return false
+	}
+	for _, chunk := range chunks {
+		if chunk.fourCC == "VP8L" {
+			return true
+		}
+	}
+	return false
+}
+
+func webpExif(data []byte) []byte {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return nil
+	}
+	for _, chunk := range chunks {
+		if chunk.fourCC == "EXIF" {
+			return chunk.data
+		}
+	}
+	return nil
+}
+
+func stripWebP(data, exif []byte) ([]byte, error) {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []webpChunk
+	hasVP8X := false
+	for _, chunk := range chunks {
+		switch chunk.fourCC {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			if len(chunk.data) < 10 {
+				return nil, fmt.Errorf("%w: некорректный чанк VP8X", ErrInvalidImage)
+			}
+			vp8x := append([]byte(nil), chunk.data...)
+			vp8x[0] &^= webpFlagEXIF | webpFlagXMP
+			if exif != nil {
+				vp8x[0] |= webpFlagEXIF
+			}
+			chunk.data = vp8x
+			hasVP8X = true
+		}
+		kept = append(kept, chunk)
+	}
+
+	if exif != nil {
+		// В простом формате (только VP8/VP8L) метаданные не допускаются — нужен расширенный заголовок VP8X
+		if !hasVP8X {
+			vp8x, err := newVP8X(data, chunks)
+			if err != nil {
+				return nil, err
+			}
+			kept = append([]webpChunk{{fourCC: "VP8X", data: vp8x}}, kept...)
+		}
+		// EXIF располагается после данных изображения
+		kept = append(kept, webpChunk{fourCC: "EXIF", data: exif})
+	}
+
+	var body bytes.Buffer
+	body.Grow(len(data))
+	for _, chunk := range kept {
+		body.WriteString(chunk.fourCC)
+		_ = binary.Write(&body, binary.LittleEndian, uint32(len(chunk.data)))
+		body.Write(chunk.data)
+		if len(chunk.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+
+	var out bytes.Buffer
+	out.Grow(12 + body.Len())
+	out.WriteString("RIFF")
+	_ = binary.Write(&out, binary.LittleEndian, uint32(4+body.Len()))
+	out.WriteString("WEBP")
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
+
+// newVP8X собирает заголовок VP8X с флагом EXIF для простого WebP.
+func newVP8X(data []byte, chunks []webpChunk) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	flags := byte(webpFlagEXIF)
+	for _, chunk := range chunks {
+		// В заголовке VP8L бит 28 после сигнатуры означает наличие альфа-канала
+		if chunk.fourCC == "VP8L" && len(chunk.data) >= 5 && chunk.data[4]&0x10 != 0 {
+			flags |= webpFlagAlpha
+		}
+	}
+	vp8x := make([]byte, 10)
+	vp8x[0] = flags
+	putUint24(vp8x[4:], uint32(cfg.Width-1))
+	putUint24(vp8x[7:], uint32(cfg.Height-1))
+	return vp8x, nil
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
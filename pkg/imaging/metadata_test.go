@@ -0,0 +1,291 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+// testTag — запись IFD0 для testTIFF: SHORT, если text пуст, иначе ASCII.
+type testTag struct {
+	tag   uint16
+	short uint16
+	text  string
+}
+
+// testTIFF собирает TIFF-структуру с одним IFD0 в заданном порядке байтов.
+func testTIFF(order binary.ByteOrder, tags ...testTag) []byte {
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString("II*\x00")
+	} else {
+		buf.WriteString("MM\x00*")
+	}
+	_ = binary.Write(&buf, order, uint32(8))
+	_ = binary.Write(&buf, order, uint16(len(tags)))
+
+	dataOffset := uint32(8 + 2 + len(tags)*12 + 4)
+	var data bytes.Buffer
+	for _, tag := range tags {
+		_ = binary.Write(&buf, order, tag.tag)
+		if tag.text == "" {
+			_ = binary.Write(&buf, order, uint16(typeShort))
+			_ = binary.Write(&buf, order, uint32(1))
+			_ = binary.Write(&buf, order, tag.short)
+			_ = binary.Write(&buf, order, uint16(0))
+			continue
+		}
+		value := tag.text + "\x00"
+		_ = binary.Write(&buf, order, uint16(typeASCII))
+		_ = binary.Write(&buf, order, uint32(len(value)))
+		_ = binary.Write(&buf, order, dataOffset+uint32(data.Len()))
+		data.WriteString(value)
+	}
+	_ = binary.Write(&buf, order, uint32(0))
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+// jpegWithSegments кодирует однотонный JPEG и вставляет после SOI сегменты APPn/COM.
+func jpegWithSegments(t *testing.T, width, height int, segments ...[]byte) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := append([]byte(nil), encoded.Bytes()[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, encoded.Bytes()[2:]...)
+}
+
+// jpegSegmentBytes собирает сегмент JPEG с маркером marker.
+func jpegSegmentBytes(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(2+len(payload)))
+	return append(segment, payload...)
+}
+
+func TestReadMetadataOrientation(t *testing.T) {
+	for name, order := range map[string]binary.ByteOrder{"little-endian": binary.LittleEndian, "big-endian": binary.BigEndian} {
+		exif := append(append([]byte(nil), exifHeader...), testTIFF(order, testTag{tag: tagOrientation, short: 6})...)
+		data := jpegWithSegments(t, 4, 2, jpegSegmentBytes(0xE1, exif))
+		if got := ReadMetadata(data, "image/jpeg").Orientation; got != 6 {
+			t.Errorf("%s: expected orientation 6, got %d", name, got)
+		}
+	}
+	if got := ReadMetadata(jpegWithSegments(t, 4, 2), "image/jpeg").Orientation; got != 1 {
+		t.Errorf("without EXIF: expected orientation 1, got %d", got)
+	}
+}
+
+func TestStripJPEGDropsTrailingFrames(t *testing.T) {
+	exif := append(append([]byte(nil), exifHeader...), testTIFF(binary.BigEndian,
+		testTag{tag: 0x010F, text: "PhoneMaker"},
+		testTag{tag: tagOrientation, short: 1},
+		testTag{tag: 0x8298, text: "Jane Doe"},
+	)...)
+	xmp := []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>primary-xmp</x:xmpmeta>")
+	primary := jpegWithSegments(t, 8, 4,
+		jpegSegmentBytes(0xE1, exif),
+		jpegSegmentBytes(0xE1, xmp),
+		jpegSegmentBytes(0xE2, []byte("MPF\x00index")),
+		jpegSegmentBytes(0xFE, []byte("primary comment")),
+	)
+
+	// Кадр глубины или превью, дописанный телефоном после EOI основного кадра, со своими EXIF и XMP
+	secondaryExif := append(append([]byte(nil), exifHeader...), testTIFF(binary.LittleEndian,
+		testTag{tag: 0x010F, text: "DepthMaker"},
+	)...)
+	secondary := jpegWithSegments(t, 2, 2,
+		jpegSegmentBytes(0xE1, secondaryExif),
+		jpegSegmentBytes(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>depth-xmp</x:xmpmeta>")),
+	)
+	data := append(append([]byte(nil), primary...), secondary...)
+
+	meta := ReadMetadata(data, "image/jpeg")
+	stripped, err := StripMetadata(data, "image/jpeg", meta, []uint16{0x8298})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"PhoneMaker", "primary-xmp", "MPF\x00", "primary comment", "DepthMaker", "depth-xmp"} {
+		if bytes.Contains(stripped, []byte(leaked)) {
+			t.Errorf("stripped JPEG still contains %q", leaked)
+		}
+	}
+	if !bytes.Contains(stripped, []byte("Jane Doe")) {
+		t.Error("the kept Copyright tag is missing")
+	}
+	if !bytes.HasSuffix(stripped, []byte{0xFF, 0xD9}) || bytes.Count(stripped, []byte{0xFF, 0xD8}) != 1 {
+		t.Error("the output must end with the EOI of the primary frame")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 4 {
+		t.Fatalf("expected the primary 8x4 frame, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestStripJPEGRejectsMissingEOI(t *testing.T) {
+	data := jpegWithSegments(t, 8, 4)
+	if _, err := StripMetadata(data[:len(data)-2], "image/jpeg", Metadata{}, nil); err == nil {
+		t.Fatal("expected an error for a JPEG without EOI")
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 3, 3))); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := pngChunks(encoded.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiff := testTIFF(binary.LittleEndian, testTag{tag: 0x010F, text: "PhoneMaker"}, testTag{tag: 0x8298, text: "Jane Doe"})
+	var data bytes.Buffer
+	data.Write(pngSignature)
+	for _, chunk := range chunks {
+		writePNGChunk(&data, chunk)
+		if chunk.typ == "IHDR" {
+			writePNGChunk(&data, pngChunk{typ: "eXIf", data: tiff})
+			writePNGChunk(&data, pngChunk{typ: "tEXt", data: []byte("Comment\x00secret")})
+			writePNGChunk(&data, pngChunk{typ: "tIME", data: make([]byte, 7)})
+		}
+	}
+
+	stripped, err := StripMetadata(data.Bytes(), "image/png", ReadMetadata(data.Bytes(), "image/png"), []uint16{0x8298})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := pngChunks(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, chunk := range got {
+		types = append(types, chunk.typ)
+	}
+	if types[0] != "IHDR" || types[1] != "eXIf" || bytes.Contains(stripped, []byte("secret")) ||
+		bytes.Contains(stripped, []byte("tIME")) || bytes.Contains(stripped, []byte("PhoneMaker")) {
+		t.Fatalf("unexpected chunks %v", types)
+	}
+	if !bytes.Contains(got[1].data, []byte("Jane Doe")) {
+		t.Fatal("the kept Copyright tag is missing")
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpFlagEXIF | webpFlagXMP
+	var body bytes.Buffer
+	for _, chunk := range []webpChunk{
+		{fourCC: "VP8X", data: vp8x},
+		{fourCC: "VP8L", data: []byte{0x2F, 0, 0, 0, 0}},
+		{fourCC: "EXIF", data: testTIFF(binary.LittleEndian, testTag{tag: tagOrientation, short: 3})},
+		{fourCC: "XMP ", data: []byte("<x:xmpmeta>secret</x:xmpmeta>")},
+	} {
+		body.WriteString(chunk.fourCC)
+		_ = binary.Write(&body, binary.LittleEndian, uint32(len(chunk.data)))
+		body.Write(chunk.data)
+		if len(chunk.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	var data bytes.Buffer
+	data.WriteString("RIFF")
+	_ = binary.Write(&data, binary.LittleEndian, uint32(4+body.Len()))
+	data.WriteString("WEBP")
+	data.Write(body.Bytes())
+
+	meta := ReadMetadata(data.Bytes(), "image/webp")
+	if meta.Orientation != 3 {
+		t.Fatalf("expected orientation 3, got %d", meta.Orientation)
+	}
+	stripped, err := StripMetadata(data.Bytes(), "image/webp", meta, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := webpChunks(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || chunks[0].fourCC != "VP8X" || chunks[1].fourCC != "VP8L" {
+		t.Fatalf("expected only VP8X and VP8L, got %+v", chunks)
+	}
+	if chunks[0].data[0]&(webpFlagEXIF|webpFlagXMP) != 0 {
+		t.Fatalf("EXIF and XMP flags must be cleared, got %#x", chunks[0].data[0])
+	}
+}
+
+func TestStripMetadataKeepsOrientationOnRequest(t *testing.T) {
+	exif := append(append([]byte(nil), exifHeader...), testTIFF(binary.BigEndian,
+		testTag{tag: 0x010F, text: "PhoneMaker"},
+		testTag{tag: tagOrientation, short: 6},
+	)...)
+	data := jpegWithSegments(t, 8, 4, jpegSegmentBytes(0xE1, exif))
+	meta := ReadMetadata(data, "image/jpeg")
+
+	stripped, err := StripMetadata(data, "image/jpeg", meta, []uint16{OrientationTag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ReadMetadata(stripped, "image/jpeg").Orientation; got != 6 || bytes.Contains(stripped, []byte("PhoneMaker")) {
+		t.Fatalf("expected only Orientation=6 to be kept, got orientation %d", got)
+	}
+	stripped, err = StripMetadata(data, "image/jpeg", meta, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ReadMetadata(stripped, "image/jpeg").Orientation; got != 1 {
+		t.Fatalf("expected Orientation to be dropped, got %d", got)
+	}
+}
+
+func TestReencodeKeepsLosslessWebP(t *testing.T) {
+	if !WebPSupported {
+		t.Skip("WebP encoding needs cgo")
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	for name, encode := range map[string]func(*bytes.Buffer) error{
+		"VP8L": func(buf *bytes.Buffer) error { return encodeWebPLossless(buf, img) },
+		"VP8 ": func(buf *bytes.Buffer) error { return encodeWebP(buf, img, 80) },
+	} {
+		var original, reencoded bytes.Buffer
+		if err := encode(&original); err != nil {
+			t.Fatal(err)
+		}
+		if err := Reencode(&reencoded, Orient(img, 6), original.Bytes(), "image/webp", 95); err != nil {
+			t.Fatal(err)
+		}
+		chunks, err := webpChunks(reencoded.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fourCCs []string
+		for _, chunk := range chunks {
+			fourCCs = append(fourCCs, chunk.fourCC)
+		}
+		if !slices.Contains(fourCCs, name) {
+			t.Errorf("%s input: expected a %s chunk after re-encoding, got %v", name, name, fourCCs)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Variant — уменьшенная копия изображения, которая создаётся при загрузке.
+// MaxSize — максимальная длина большей стороны в пикселях.
+type Variant struct {
+	Name    string
+	MaxSize int
+}
+
+// ParseVariants разбирает список вида "thumb:200,preview:800".
+func ParseVariants(spec string) ([]Variant, error) {
+	var variants []Variant
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, size, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("некорректный вариант %q: ожидается name:size", item)
+		}
+		maxSize, err := strconv.Atoi(size)
+		if err != nil || maxSize <= 0 {
+			return nil, fmt.Errorf("некорректный размер варианта %q", item)
+		}
+		if !IsVariantName(name) || seen[name] {
+			return nil, fmt.Errorf("некорректное или повторяющееся имя варианта %q", name)
+		}
+		seen[name] = true
+		variants = append(variants, Variant{Name: name, MaxSize: maxSize})
+	}
+	return variants, nil
+}
+
+// Fit уменьшает изображение так, чтобы большая сторона не превышала maxSize.
+// Изображения меньше maxSize не увеличиваются.
+func Fit(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return img
+	}
+	if width >= height {
+		height = max(1, height*maxSize/width)
+		width = maxSize
+	} else {
+		width = max(1, width*maxSize/height)
+		height = maxSize
+	}
+	return Resize(img, width, height)
+}
+
+// Resize масштабирует изображение до width x height (пропорции не сохраняются).
+func Resize(img image.Image, width, height int) image.Image {
+	return resizeRect(img, img.Bounds(), width, height)
+}
+
+// resizeRect масштабирует область src изображения до width x height.
+func resizeRect(img image.Image, src image.Rectangle, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Over, nil)
+	return dst
+}
+
+// IsVariantName допускает в имени варианта только латиницу, цифры и дефис,
+// чтобы имя можно было безопасно подставить в ключ.
+func IsVariantName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package imaging
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// SniffLen — сколько первых байт файла нужно для определения типа.
+const SniffLen = 512
+
+// canonicalExtensions — расширение, под которым сохраняется файл данного типа,
+// если исходное расширение типу не соответствует.
+var canonicalExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/avif": ".avif",
+	"image/bmp":  ".bmp",
+}
+
+// extensionTypes — типы для расширений изображений, не зависящие от системной таблицы mime.
+var extensionTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".bmp":  "image/bmp",
+}
+
+// DetectContentType определяет MIME-тип по первым байтам содержимого (magic bytes).
+// Вдобавок к http.DetectContentType распознаёт AVIF. Параметры (charset и т.п.) отбрасываются.
+func DetectContentType(head []byte) string {
+	if isAVIF(head) {
+		return "image/avif"
+	}
+	return baseType(http.DetectContentType(head))
+}
+
+// ContentTypeByExtension возвращает MIME-тип для расширения (".png" → "image/png").
+// Пустая строка — тип неизвестен.
+func ContentTypeByExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if contentType, ok := extensionTypes[ext]; ok {
+		return contentType
+	}
+	return baseType(mime.TypeByExtension(ext))
+}
+
+// ExtensionForContentType возвращает расширение для ключа: исходное, если оно соответствует типу,
+// иначе каноническое для типа.
+func ExtensionForContentType(originalExt, contentType string) string {
+	if ContentTypeByExtension(originalExt) == contentType {
+		return strings.ToLower(originalExt)
+	}
+	if ext, ok := canonicalExtensions[contentType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// isAVIF проверяет ISO BMFF-заголовок: ....ftypavif / ftypavis.
+func isAVIF(head []byte) bool {
+	if len(head) < 12 || !bytes.Equal(head[4:8], []byte("ftyp")) {
+		return false
+	}
+	brand := string(head[8:12])
+	return brand == "avif" || brand == "avis"
+}
+
+// baseType отбрасывает параметры MIME-типа: "text/plain; charset=utf-8" → "text/plain".
+func baseType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.TrimSpace(strings.ToLower(contentType))
+}
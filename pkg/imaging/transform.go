@@ -0,0 +1,209 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Режимы вписывания изображения в заданный прямоугольник.
+const (
+	// FitCover заполняет прямоугольник целиком, обрезая лишнее по центру.
+	FitCover = "cover"
+	// FitContain вписывает изображение в прямоугольник целиком, сохраняя пропорции.
+	FitContain = "contain"
+)
+
+// MaxTransformSize — предельная сторона результата трансформации.
+const MaxTransformSize = 4096
+
+// ErrCropTooSmall — при крайних пропорциях оригинала область для fit=cover меньше пикселя.
+var ErrCropTooSmall = errors.New("пропорции оригинала не позволяют вырезать область для fit=cover")
+
+// Transform — параметры трансформации изображения.
+type Transform struct {
+	Width   int
+	Height  int
+	Fit     string
+	Quality int
+	// Format — выходной формат: "jpeg", "png", "webp" или пусто (как у оригинала).
+	Format string
+}
+
+// ParseTransform разбирает параметры из query: w, h, fit, q, fmt.
+func ParseTransform(query url.Values) (Transform, error) {
+	var t Transform
+	var err error
+	if t.Width, err = parseDimension(query.Get("w")); err != nil {
+		return t, fmt.Errorf("w: %w", err)
+	}
+	if t.Height, err = parseDimension(query.Get("h")); err != nil {
+		return t, fmt.Errorf("h: %w", err)
+	}
+	t.Fit = query.Get("fit")
+	t.Format = query.Get("fmt")
+	if q := query.Get("q"); q != "" {
+		if t.Quality, err = strconv.Atoi(q); err != nil {
+			return t, fmt.Errorf("q: %w", err)
+		}
+	}
+	return t.normalize()
+}
+
+// ParsePresets разбирает список пресетов вида "avatar=128x128:cover,card=400x300:contain:80:jpeg".
+// Формат значения: WxH[:fit[:quality[:format]]]; ширину или высоту можно опустить ("x300", "400x").
+func ParsePresets(spec string) (map[string]Transform, error) {
+	presets := make(map[string]Transform)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || !IsVariantName(name) {
+			return nil, fmt.Errorf("некорректный пресет %q", item)
+		}
+		fields := strings.Split(value, ":")
+		width, height, _ := strings.Cut(fields[0], "x")
+		query := url.Values{"w": {width}, "h": {height}}
+		for i, key := range []string{"fit", "q", "fmt"} {
+			if len(fields) > i+1 {
+				query.Set(key, fields[i+1])
+			}
+		}
+		t, err := ParseTransform(query)
+		if err != nil {
+			return nil, fmt.Errorf("пресет %q: %w", name, err)
+		}
+		presets[name] = t
+	}
+	return presets, nil
+}
+
+// CanonicalQuery возвращает параметры в фиксированном порядке — по этой строке считается подпись.
+func (t Transform) CanonicalQuery() string {
+	return fmt.Sprintf("fit=%s&fmt=%s&h=%d&q=%d&w=%d", t.Fit, t.Format, t.Height, t.Quality, t.Width)
+}
+
+// CacheName — короткое имя для ключа закэшированного результата: "tr-400x300-cover-q85-jpeg".
+func (t Transform) CacheName() string {
+	name := fmt.Sprintf("tr-%dx%d-%s-q%d", t.Width, t.Height, t.Fit, t.Quality)
+	if t.Format != "" {
+		name += "-" + t.Format
+	}
+	return name
+}
+
+// OutputType возвращает MIME-тип результата для оригинала с типом sourceType.
+func (t Transform) OutputType(sourceType string) string {
+	switch t.Format {
+	case "jpeg":
+		return "image/jpeg"
+	case "png":
+		return "image/png"
+	case "webp":
+		return "image/webp"
+	default:
+		return EncodeFormat(sourceType)
+	}
+}
+
+// Apply применяет трансформацию к изображению.
+func (t Transform) Apply(img image.Image) (image.Image, error) {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	width, height := t.Width, t.Height
+
+	if t.Fit == FitCover {
+		// Вырезаем из центра оригинала область с пропорциями результата и масштабируем её
+		crop := bounds
+		if srcW*height > srcH*width {
+			cropW := srcH * width / height
+			if cropW == 0 {
+				return nil, ErrCropTooSmall
+			}
+			crop.Min.X += (srcW - cropW) / 2
+			crop.Max.X = crop.Min.X + cropW
+		} else {
+			cropH := srcW * height / width
+			if cropH == 0 {
+				return nil, ErrCropTooSmall
+			}
+			crop.Min.Y += (srcH - cropH) / 2
+			crop.Max.Y = crop.Min.Y + cropH
+		}
+		return resizeRect(img, crop, width, height), nil
+	}
+
+	// contain: вписываем целиком. Незаданная сторона не ограничивает результат сверх MaxTransformSize,
+	// поэтому у оригинала с крайними пропорциями (10000x1 при h=4096) она не вырастает до миллионов
+	if width == 0 {
+		width = MaxTransformSize
+	}
+	if height == 0 {
+		height = MaxTransformSize
+	}
+	if srcW*height > srcH*width {
+		height = max(1, srcH*width/srcW)
+	} else {
+		width = max(1, srcW*height/srcH)
+	}
+	// Увеличение не добавляет деталей, а только тратит CPU и память
+	if width > srcW || height > srcH {
+		width, height = srcW, srcH
+	}
+	return Resize(img, width, height), nil
+}
+
+// normalize проверяет параметры и подставляет значения по умолчанию.
+func (t Transform) normalize() (Transform, error) {
+	if t.Width == 0 && t.Height == 0 {
+		return t, fmt.Errorf("нужно указать ширину или высоту")
+	}
+	switch t.Fit {
+	case "":
+		t.Fit = FitContain
+	case FitCover, FitContain:
+	default:
+		return t, fmt.Errorf("неизвестный режим fit %q", t.Fit)
+	}
+	if t.Fit == FitCover && (t.Width == 0 || t.Height == 0) {
+		return t, fmt.Errorf("для fit=cover нужны ширина и высота")
+	}
+	if t.Quality == 0 {
+		t.Quality = DefaultQuality
+	}
+	if t.Quality < 1 || t.Quality > 100 {
+		return t, fmt.Errorf("качество должно быть от 1 до 100")
+	}
+	switch t.Format {
+	case "", "jpeg", "png":
+	case "webp":
+		if !WebPSupported {
+			return t, ErrWebPUnsupported
+		}
+	case "jpg":
+		t.Format = "jpeg"
+	default:
+		return t, fmt.Errorf("неподдерживаемый формат %q", t.Format)
+	}
+	return t, nil
+}
+
+// parseDimension разбирает размер стороны: пусто — не задан (0).
+func parseDimension(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 || n > MaxTransformSize {
+		return 0, fmt.Errorf("размер должен быть от 1 до %d", MaxTransformSize)
+	}
+	return n, nil
+}
@@ -0,0 +1,42 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestApplyClampsOneSidedTransform(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		srcW, srcH            int
+		transform             Transform
+		wantWidth, wantHeight int
+	}{
+		{"wide source by height", 10000, 1, Transform{Height: MaxTransformSize, Fit: FitContain}, MaxTransformSize, 1},
+		{"tall source by width", 1, 10000, Transform{Width: MaxTransformSize, Fit: FitContain}, 1, MaxTransformSize},
+		{"no upscaling by width", 16, 8, Transform{Width: 400, Fit: FitContain}, 16, 8},
+		{"no upscaling by height", 16, 8, Transform{Height: 400, Fit: FitContain}, 16, 8},
+		{"downscale keeps aspect", 16, 8, Transform{Width: 4, Fit: FitContain}, 4, 2},
+	} {
+		img, err := tc.transform.Apply(image.NewGray(image.Rect(0, 0, tc.srcW, tc.srcH)))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if b := img.Bounds(); b.Dx() != tc.wantWidth || b.Dy() != tc.wantHeight {
+			t.Errorf("%s: expected %dx%d, got %dx%d", tc.name, tc.wantWidth, tc.wantHeight, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestApplyRejectsEmptyCoverCrop(t *testing.T) {
+	for _, src := range []image.Rectangle{image.Rect(0, 0, 10000, 1), image.Rect(0, 0, 1, 10000)} {
+		transform := Transform{Width: 1, Height: MaxTransformSize, Fit: FitCover}
+		if src.Dx() == 1 {
+			transform = Transform{Width: MaxTransformSize, Height: 1, Fit: FitCover}
+		}
+		if _, err := transform.Apply(image.NewGray(src)); !errors.Is(err, ErrCropTooSmall) {
+			t.Errorf("%v: expected ErrCropTooSmall, got %v", src, err)
+		}
+	}
+}
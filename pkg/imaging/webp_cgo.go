@@ -0,0 +1,21 @@
+//go:build cgo
+
+package imaging
+
+import (
+	"image"
+	"io"
+
+	"github.com/chai2010/webp"
+)
+
+// WebPSupported сообщает, умеет ли сборка кодировать WebP (libwebp доступна только с cgo).
+const WebPSupported = true
+
+func encodeWebP(w io.Writer, img image.Image, quality int) error {
+	return webp.Encode(w, img, &webp.Options{Quality: float32(quality)})
+}
+
+func encodeWebPLossless(w io.Writer, img image.Image) error {
+	return webp.Encode(w, img, &webp.Options{Lossless: true, Exact: true})
+}
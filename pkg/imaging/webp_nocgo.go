@@ -0,0 +1,19 @@
+//go:build !cgo
+
+package imaging
+
+import (
+	"image"
+	"io"
+)
+
+// WebPSupported сообщает, умеет ли сборка кодировать WebP (libwebp доступна только с cgo).
+const WebPSupported = false
+
+func encodeWebP(io.Writer, image.Image, int) error {
+	return ErrWebPUnsupported
+}
+
+func encodeWebPLossless(io.Writer, image.Image) error {
+	return ErrWebPUnsupported
+}